@@ -0,0 +1,26 @@
+package bus
+
+import (
+	"fmt"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/contract"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// New creates the message bus selected by BUS. The redis bus reuses the
+// shared client, so rdb must be set when BUS=redis.
+func New(cfg *config.AppConfig, rdb *redis.Client) (contract.MessageBus, error) {
+	switch cfg.Cluster.Bus {
+	case "redis":
+		if rdb == nil {
+			return nil, fmt.Errorf("redis bus requires a redis connection")
+		}
+		return NewRedisBus(rdb), nil
+	case "nats":
+		return NewNATSBus(cfg.NATS.URL, cfg.Server.NodeID)
+	default:
+		return NewMemoryBus(), nil
+	}
+}
@@ -0,0 +1,76 @@
+package bus
+
+import (
+	"context"
+	"sync"
+
+	"projectwebcurhat/contract"
+)
+
+// memoryBus delivers messages inside one process. It is the default for a
+// single instance and keeps the signaling code path identical to a cluster.
+type memoryBus struct {
+	mutex    sync.RWMutex
+	handlers map[string]map[*memorySubscription]func(data []byte)
+}
+
+type memorySubscription struct {
+	bus     *memoryBus
+	subject string
+}
+
+func NewMemoryBus() *memoryBus {
+	return &memoryBus{
+		handlers: make(map[string]map[*memorySubscription]func(data []byte)),
+	}
+}
+
+func (b *memoryBus) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *memoryBus) Publish(subject string, data []byte) error {
+	b.mutex.RLock()
+	handlers := make([]func(data []byte), 0, len(b.handlers[subject]))
+	for _, handler := range b.handlers[subject] {
+		handlers = append(handlers, handler)
+	}
+	b.mutex.RUnlock()
+
+	// Handlers run without the lock so they may publish or unsubscribe themselves
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(subject string, handler func(data []byte)) (contract.Subscription, error) {
+	sub := &memorySubscription{bus: b, subject: subject}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.handlers[subject] == nil {
+		b.handlers[subject] = make(map[*memorySubscription]func(data []byte))
+	}
+	b.handlers[subject][sub] = handler
+	return sub, nil
+}
+
+func (b *memoryBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers = make(map[string]map[*memorySubscription]func(data []byte))
+	return nil
+}
+
+func (s *memorySubscription) Unsubscribe() error {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+
+	delete(s.bus.handlers[s.subject], s)
+	if len(s.bus.handlers[s.subject]) == 0 {
+		delete(s.bus.handlers, s.subject)
+	}
+	return nil
+}
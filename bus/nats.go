@@ -0,0 +1,63 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"projectwebcurhat/contract"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsBus struct {
+	conn *nats.Conn
+}
+
+func NewNATSBus(url, nodeID string) (*natsBus, error) {
+	slog.Info("Connecting to NATS")
+
+	conn, err := nats.Connect(url,
+		nats.Name("webcurhat-"+nodeID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("NATS disconnected", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			slog.Info("NATS reconnected")
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("NATS connected successfully")
+	return &natsBus{conn: conn}, nil
+}
+
+func (b *natsBus) Publish(subject string, data []byte) error {
+	return b.conn.Publish(subject, data)
+}
+
+func (b *natsBus) Subscribe(subject string, handler func(data []byte)) (contract.Subscription, error) {
+	sub, err := b.conn.Subscribe(subject, func(msg *nats.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (b *natsBus) Ping(ctx context.Context) error {
+	if !b.conn.IsConnected() {
+		return fmt.Errorf("nats connection is %s", b.conn.Status())
+	}
+	return nil
+}
+
+func (b *natsBus) Close() error {
+	return b.conn.Drain()
+}
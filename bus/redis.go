@@ -0,0 +1,128 @@
+package bus
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"projectwebcurhat/contract"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisBusTimeout = 2 * time.Second
+
+// redisBus uses Redis pub/sub. All subscriptions share one PubSub connection
+// and a single dispatcher, so thousands of clients do not need thousands of
+// Redis connections.
+type redisBus struct {
+	rdb      *redis.Client
+	pubsub   *redis.PubSub
+	mutex    sync.RWMutex
+	handlers map[string]map[*redisSubscription]func(data []byte)
+	done     chan struct{}
+}
+
+type redisSubscription struct {
+	bus     *redisBus
+	subject string
+}
+
+func NewRedisBus(rdb *redis.Client) *redisBus {
+	b := &redisBus{
+		rdb:      rdb,
+		pubsub:   rdb.Subscribe(context.Background()),
+		handlers: make(map[string]map[*redisSubscription]func(data []byte)),
+		done:     make(chan struct{}),
+	}
+	go b.dispatch()
+	return b
+}
+
+func (b *redisBus) Publish(subject string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisBusTimeout)
+	defer cancel()
+	return b.rdb.Publish(ctx, subject, data).Err()
+}
+
+func (b *redisBus) Subscribe(subject string, handler func(data []byte)) (contract.Subscription, error) {
+	sub := &redisSubscription{bus: b, subject: subject}
+
+	b.mutex.Lock()
+	first := b.handlers[subject] == nil
+	if first {
+		b.handlers[subject] = make(map[*redisSubscription]func(data []byte))
+	}
+	b.handlers[subject][sub] = handler
+	b.mutex.Unlock()
+
+	if first {
+		ctx, cancel := context.WithTimeout(context.Background(), redisBusTimeout)
+		defer cancel()
+		if err := b.pubsub.Subscribe(ctx, subject); err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Ping checks both the publishing client and the shared subscriber connection
+func (b *redisBus) Ping(ctx context.Context) error {
+	if err := b.rdb.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	return b.pubsub.Ping(ctx)
+}
+
+func (b *redisBus) Close() error {
+	close(b.done)
+	return b.pubsub.Close()
+}
+
+func (b *redisBus) dispatch() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case msg, ok := <-b.pubsub.Channel():
+			if !ok {
+				return
+			}
+
+			b.mutex.RLock()
+			handlers := make([]func(data []byte), 0, len(b.handlers[msg.Channel]))
+			for _, handler := range b.handlers[msg.Channel] {
+				handlers = append(handlers, handler)
+			}
+			b.mutex.RUnlock()
+
+			for _, handler := range handlers {
+				handler([]byte(msg.Payload))
+			}
+		}
+	}
+}
+
+func (s *redisSubscription) Unsubscribe() error {
+	s.bus.mutex.Lock()
+	delete(s.bus.handlers[s.subject], s)
+	last := len(s.bus.handlers[s.subject]) == 0
+	if last {
+		delete(s.bus.handlers, s.subject)
+	}
+	s.bus.mutex.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisBusTimeout)
+	defer cancel()
+	if err := s.bus.pubsub.Unsubscribe(ctx, s.subject); err != nil {
+		slog.Error("Error unsubscribing from redis channel", "subject", s.subject, "error", err)
+		return err
+	}
+	return nil
+}
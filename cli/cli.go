@@ -0,0 +1,169 @@
+// Package cli implements the subcommands of the webcurhat binary. Every
+// command loads the configuration with config.Load and, when it needs the
+// database, wires the same repositories and services as the server.
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"projectwebcurhat/bus"
+	"projectwebcurhat/config"
+	dbConfig "projectwebcurhat/config/database"
+	"projectwebcurhat/config/logger"
+	"projectwebcurhat/config/pkg/token"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/metrics"
+	"projectwebcurhat/repository"
+	"projectwebcurhat/service"
+
+	"gorm.io/gorm"
+)
+
+// errUsage makes Run exit with status 2 after the usage has been printed
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "start the signaling server (default)", serve},
+	{"migrate", "manage database migrations: up, down, status, force, verify", migrate},
+	{"db", "development data: seed", dbCommand},
+	{"user", "manage users: create, set-role, ban, unban", userCommand},
+	{"token", "debugging tokens: issue", tokenCommand},
+	{"config", "inspect configuration: check", configCommand},
+}
+
+// Run executes the command named after the global flags and returns the
+// exit status. Global flags are -config and one flag per setting key, for
+// example -server.port 9000. Without a command the server is started, as
+// before subcommands existed.
+func Run(args []string) int {
+	global := flag.NewFlagSet("webcurhat", flag.ContinueOnError)
+	sources := config.BindFlags(global)
+	global.Usage = func() {
+		printUsage(os.Stderr)
+		fmt.Fprintln(os.Stderr, "\nglobal flags:")
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	args = global.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	name := args[0]
+	if name == "help" {
+		global.Usage()
+		return 0
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if err := config.Load(*sources); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := cmd.run(args[1:]); err != nil {
+			if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+				return 2
+			}
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: webcurhat [global flags] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// subcommand dispatches a command group like "user create"
+func subcommand(group string, args []string, subs map[string]func(args []string) error) error {
+	if len(args) > 0 {
+		if run, ok := subs[args[0]]; ok {
+			return run(args[1:])
+		}
+		fmt.Fprintf(os.Stderr, "unknown %s command %q\n", group, args[0])
+	}
+
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: webcurhat %s <%s> [flags]\n", group, strings.Join(names, "|"))
+	return errUsage
+}
+
+// newFlagSet returns a flag set that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// app holds what commands that touch the database need. Logs go to stderr,
+// so stdout only carries command output.
+type app struct {
+	db      *gorm.DB
+	sqlDB   *sql.DB
+	service *contract.Service
+}
+
+func openApp() (*app, error) {
+	cfg := config.Get()
+	logger.InitWriter(cfg, os.Stderr)
+
+	if err := token.Init(cfg); err != nil {
+		return nil, fmt.Errorf("failed to load JWT signing keys: %w", err)
+	}
+
+	db, sqlDB, err := dbConfig.ConnectDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+
+	// Rooms and the bus stay in memory: commands never serve clients
+	repo, err := repository.New(db, nil, "memory")
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	serv := service.New(repo, bus.NewMemoryBus(), metrics.Noop{})
+
+	return &app{db: db, sqlDB: sqlDB, service: serv}, nil
+}
+
+func (a *app) Close() {
+	a.sqlDB.Close()
+}
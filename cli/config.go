@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/pkg/token"
+)
+
+func configCommand(args []string) error {
+	return subcommand("config", args, map[string]func([]string) error{
+		"check": configCheck,
+	})
+}
+
+// configCheck runs after config.Load has validated every setting, then makes
+// sure the signing keys load and prints what is in effect and where it came
+// from. It only reads the key directory, unlike serve it never adds a key.
+func configCheck(args []string) error {
+	fs := newFlagSet("config check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := token.Check(config.Get()); err != nil {
+		return fmt.Errorf("failed to load JWT signing keys: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, setting := range config.Describe() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Source)
+	}
+	w.Flush()
+
+	fmt.Println("\nconfiguration is valid")
+	return nil
+}
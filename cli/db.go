@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+func dbCommand(args []string) error {
+	return subcommand("db", args, map[string]func([]string) error{
+		"seed": dbSeed,
+	})
+}
+
+// dbSeed creates numbered fake users sharing one password. Users that
+// already exist are skipped, so it can be run repeatedly.
+func dbSeed(args []string) error {
+	fs := newFlagSet("db seed")
+	count := fs.Int("count", 10, "number of users")
+	prefix := fs.String("prefix", "seed", "username prefix")
+	password := fs.String("password", "password123", "password of every seeded user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if config.Get().Server.Production {
+		return errors.New("refusing to seed fake users with IS_PRODUCTION=true")
+	}
+
+	a, err := openApp()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	var created []*dto.UserProfile
+	skipped := 0
+	for i := 1; i <= *count; i++ {
+		username := fmt.Sprintf("%s%02d", *prefix, i)
+		payload := &dto.RegisterRequest{
+			Username: username,
+			Email:    username + "@example.test",
+			Password: *password,
+		}
+
+		if err := binding.Validator.ValidateStruct(payload); err != nil {
+			return err
+		}
+
+		profile, err := a.service.Admin.CreateUser(context.Background(), payload, database.RoleUser)
+		if err != nil {
+			var messageErr errs.MessageError
+			if errors.As(err, &messageErr) && messageErr.Status() == http.StatusBadRequest {
+				skipped++
+				continue
+			}
+			return err
+		}
+		created = append(created, profile)
+	}
+
+	if len(created) > 0 {
+		printProfiles(created...)
+	}
+	fmt.Printf("created %d user(s), skipped %d existing, password %q\n", len(created), skipped, *password)
+	return nil
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"projectwebcurhat/config"
+	dbConfig "projectwebcurhat/config/database"
+	"projectwebcurhat/config/logger"
+	dbMigration "projectwebcurhat/database"
+)
+
+const migrateUsage = `usage: webcurhat migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [N]      revert the last N migrations (default 1)
+  status        list migrations and whether they are applied
+  force VERSION mark the schema as being at VERSION without running SQL
+  verify        check that the schema matches the models`
+
+// migrate only needs the database, so it skips the services openApp wires
+func migrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return errUsage
+	}
+
+	logger.InitWriter(config.Get(), os.Stderr)
+
+	db, sqlDB, err := dbConfig.ConnectDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer sqlDB.Close()
+
+	migrator, err := dbMigration.NewMigrator(db)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Printf("applied %d migration(s)\n", applied)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		fmt.Printf("reverted %d migration(s)\n", reverted)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(statuses)
+		return nil
+	case "force":
+		if len(args) < 2 {
+			return fmt.Errorf("force needs a version")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return migrator.Force(ctx, version)
+	case "verify":
+		if err := migrator.VerifySchema(ctx); err != nil {
+			return err
+		}
+		fmt.Println("schema matches the models")
+		return nil
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return errUsage
+	}
+}
+
+func printMigrationStatus(statuses []dbMigration.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if status.Dirty {
+			state = "dirty"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	w.Flush()
+}
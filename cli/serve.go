@@ -0,0 +1,13 @@
+package cli
+
+import "projectwebcurhat/config/server"
+
+func serve(args []string) error {
+	fs := newFlagSet("serve")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	server.Run()
+	return nil
+}
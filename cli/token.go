@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/dto"
+)
+
+func tokenCommand(args []string) error {
+	return subcommand("token", args, map[string]func([]string) error{
+		"issue": tokenIssue,
+	})
+}
+
+// tokenIssue starts a real session, so the token passes the session check
+// and shows up in the user's session list where it can be revoked. The token
+// has to be signed with a key the server knows: the shared secret of HS256,
+// or a key in jwt.keys_dir. Without a directory the key would only live as
+// long as this command.
+func tokenIssue(args []string) error {
+	fs := newFlagSet("token issue")
+	login := fs.String("user", "", "email or username (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *login == "" {
+		fs.Usage()
+		return errUsage
+	}
+	if cfg := config.Get(); cfg.JWT.Algorithm != "HS256" && cfg.JWT.KeysDir == "" {
+		return errors.New("jwt.keys_dir must be the server's key directory, a token signed with a throwaway key is rejected")
+	}
+
+	a, err := openApp()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	result, err := a.service.Admin.IssueToken(context.Background(), *login, &dto.SessionMeta{UserAgent: "webcurhat token issue"})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result.Token)
+	return nil
+}
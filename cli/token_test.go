@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"projectwebcurhat/config"
+)
+
+// TestTokenIssueNeedsTheKeyDirectory makes sure no token is signed with a
+// key that only this command would know
+func TestTokenIssueNeedsTheKeyDirectory(t *testing.T) {
+	err := config.Load(config.Sources{Flags: map[string]string{
+		"jwt.algorithm": "RS256",
+		"jwt.keys_dir":  "",
+		"log.level":     "warn",
+	}})
+	if err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+
+	err = tokenIssue([]string{"-user", "admin"})
+	if err == nil || !strings.Contains(err.Error(), "jwt.keys_dir") {
+		t.Fatalf("token issue without a key directory returned %v", err)
+	}
+}
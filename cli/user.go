@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+func userCommand(args []string) error {
+	return subcommand("user", args, map[string]func([]string) error{
+		"create":   userCreate,
+		"set-role": userSetRole,
+		"ban":      func(args []string) error { return userBan("ban", args, true) },
+		"unban":    func(args []string) error { return userBan("unban", args, false) },
+	})
+}
+
+// userCreate generates a password when none is given and prints it once
+func userCreate(args []string) error {
+	fs := newFlagSet("user create")
+	email := fs.String("email", "", "email address (required)")
+	username := fs.String("username", "", "username (required)")
+	password := fs.String("password", "", "password, generated when empty")
+	role := fs.String("role", database.RoleUser, "user or admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	generated := *password == ""
+	if generated {
+		*password = randomPassword()
+	}
+
+	payload := &dto.RegisterRequest{Username: *username, Email: *email, Password: *password}
+	if err := binding.Validator.ValidateStruct(payload); err != nil {
+		return err
+	}
+
+	a, err := openApp()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	profile, err := a.service.Admin.CreateUser(context.Background(), payload, *role)
+	if err != nil {
+		return err
+	}
+
+	printProfiles(profile)
+	if generated {
+		fmt.Printf("\npassword: %s\n", *password)
+	}
+	return nil
+}
+
+func userSetRole(args []string) error {
+	fs := newFlagSet("user set-role")
+	login := fs.String("user", "", "email or username (required)")
+	role := fs.String("role", "", "user or admin (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *login == "" || *role == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	a, err := openApp()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	profile, err := a.service.Admin.SetUserRole(context.Background(), *login, *role)
+	if err != nil {
+		return err
+	}
+	printProfiles(profile)
+	return nil
+}
+
+func userBan(name string, args []string, banned bool) error {
+	fs := newFlagSet("user " + name)
+	login := fs.String("user", "", "email or username (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *login == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	a, err := openApp()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	profile, err := a.service.Admin.SetUserBanned(context.Background(), *login, banned)
+	if err != nil {
+		return err
+	}
+	printProfiles(profile)
+	return nil
+}
+
+func printProfiles(profiles ...*dto.UserProfile) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tROLE\tBANNED")
+	for _, profile := range profiles {
+		banned := "-"
+		if profile.BannedAt != nil {
+			banned = profile.BannedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", profile.ID, profile.Username, profile.Email, profile.Role, banned)
+	}
+	w.Flush()
+}
+
+func randomPassword() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
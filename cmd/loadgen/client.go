@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"projectwebcurhat/dto"
+
+	"github.com/gorilla/websocket"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// sentAt holds the send time of every SDP and candidate in flight, keyed by
+// its text, which is unique per message. Both ends of a call live in this
+// process, so the receiver looks up when its copy was sent.
+var sentAt sync.Map
+
+// errStep ends a client's run; kind is the key it is counted under
+type errStep struct {
+	kind string
+	err  error
+}
+
+func (e *errStep) Error() string {
+	return fmt.Sprintf("%s: %v", e.kind, e.err)
+}
+
+func fail(kind string, err error) error {
+	return &errStep{kind: kind, err: err}
+}
+
+// client is one simulated user: authenticate, connect, say hello, join,
+// exchange SDP and candidates with whoever it is matched with, hold the call and leave
+type client struct {
+	index int
+	runID string
+	opts  options
+	stats *stats
+
+	conn     *websocket.Conn
+	messages chan dto.Message
+	done     chan struct{}
+	readErr  error
+	sent     int
+}
+
+func (c *client) Run() {
+	c.done = make(chan struct{})
+	err := c.call()
+	close(c.done)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	var step *errStep
+	switch {
+	case err == nil:
+		c.stats.CallCompleted()
+	case errors.As(err, &step):
+		c.stats.Error(step.kind)
+	default:
+		c.stats.Error("other")
+	}
+	c.stats.MessagesSent(c.sent)
+}
+
+func (c *client) call() error {
+	accessToken, err := c.authenticate()
+	if err != nil {
+		return fail("auth", err)
+	}
+
+	endpoint := "ws" + strings.TrimPrefix(c.opts.URL, "http") + "/ws"
+	if accessToken != "" {
+		endpoint += "?token=" + url.QueryEscape(accessToken)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: c.opts.Timeout}
+	conn, _, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		return fail("dial", err)
+	}
+	c.conn = conn
+	c.stats.Connected()
+
+	c.messages = make(chan dto.Message, 32)
+	go c.read()
+
+	hello := dto.HelloPayload{Versions: []int{dto.LatestProtocolVersion}, Capabilities: []string{dto.CapabilityChat}}
+	if err := c.send(dto.Message{Type: dto.MessageTypeHello, Payload: hello}); err != nil {
+		return err
+	}
+	if _, err := c.expect(dto.MessageTypeHello); err != nil {
+		return err
+	}
+
+	joinedAt := time.Now()
+	if err := c.send(dto.Message{Type: dto.MessageTypeJoin}); err != nil {
+		return err
+	}
+	if _, err := c.expect(dto.MessageTypeReady); err != nil {
+		return err
+	}
+	join, err := c.expect(dto.MessageTypeJoin)
+	if err != nil {
+		return err
+	}
+	c.stats.Matched(time.Since(joinedAt))
+
+	// The server names the peer that offers
+	var role dto.JoinPayload
+	if err := decodePayload(join, &role); err != nil {
+		return fail("bad_payload", err)
+	}
+	if role.Initiator {
+		if err := c.sendSDP(dto.MessageTypeOffer); err != nil {
+			return err
+		}
+		if err := c.expectRelayed(dto.MessageTypeAnswer); err != nil {
+			return err
+		}
+	} else {
+		if err := c.expectRelayed(dto.MessageTypeOffer); err != nil {
+			return err
+		}
+		if err := c.sendSDP(dto.MessageTypeAnswer); err != nil {
+			return err
+		}
+	}
+
+	if err := c.exchangeCandidates(); err != nil {
+		return err
+	}
+
+	time.Sleep(c.opts.Hold)
+
+	// The offerer hangs up, the answerer waits to be told
+	if role.Initiator {
+		return c.send(dto.Message{Type: dto.MessageTypeLeave})
+	}
+	_, err = c.expect(dto.MessageTypeLeave)
+	return err
+}
+
+// exchangeCandidates trickles this side's candidates while collecting the
+// peer's. Sending runs on its own goroutine, the only writer until it ends.
+func (c *client) exchangeCandidates() error {
+	sendErr := make(chan error, 1)
+	go func() {
+		for i := range c.opts.Candidates {
+			if i > 0 {
+				time.Sleep(c.opts.Interval)
+			}
+			candidate := dto.ICECandidateMessage{
+				Candidate:     fmt.Sprintf("candidate:%s%d%d 1 udp 2122260223 10.%d.%d.%d %d typ host", c.runID, c.index, i, c.index>>16&255, c.index>>8&255, c.index&255, 40000+i),
+				SDPMid:        "0",
+				SDPMLineIndex: 0,
+			}
+			sentAt.Store(candidate.Candidate, time.Now())
+			if err := c.send(dto.Message{Type: dto.MessageTypeCandidate, Payload: candidate}); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- nil
+	}()
+
+	for range c.opts.Candidates {
+		if err := c.expectRelayed(dto.MessageTypeCandidate); err != nil {
+			// Unblocks a pending write, so the sender is done before we return
+			c.conn.Close()
+			<-sendErr
+			return err
+		}
+	}
+	return <-sendErr
+}
+
+func (c *client) sendSDP(msgType string) error {
+	sdp := fmt.Sprintf("v=0\r\no=loadgen %s%d 1 IN IP4 127.0.0.1\r\ns=%s\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n", c.runID, c.index, msgType)
+	sentAt.Store(sdp, time.Now())
+	return c.send(dto.Message{Type: msgType, Payload: dto.SDPMessage{Type: msgType, SDP: sdp}})
+}
+
+// expectRelayed waits for a message from the peer and records how long the
+// server took to relay it
+func (c *client) expectRelayed(msgType string) error {
+	msg, err := c.expect(msgType)
+	if err != nil {
+		return err
+	}
+
+	var key string
+	if msgType == dto.MessageTypeCandidate {
+		var candidate dto.ICECandidateMessage
+		err = decodePayload(msg, &candidate)
+		key = candidate.Candidate
+	} else {
+		var sdp dto.SDPMessage
+		err = decodePayload(msg, &sdp)
+		key = sdp.SDP
+	}
+	if err != nil {
+		return fail("bad_payload", err)
+	}
+
+	if sent, ok := sentAt.LoadAndDelete(key); ok {
+		c.stats.Relayed(msgType, time.Since(sent.(time.Time)))
+	}
+	return nil
+}
+
+// decodePayload converts the generic JSON payload of a received message into v
+func decodePayload(msg dto.Message, v any) error {
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *client) send(msg dto.Message) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	if err := c.conn.WriteJSON(msg); err != nil {
+		return fail("write", err)
+	}
+	c.sent++
+	return nil
+}
+
+// expect returns the next message, failing unless it has the given type
+func (c *client) expect(msgType string) (dto.Message, error) {
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case msg, ok := <-c.messages:
+		switch {
+		case !ok:
+			return msg, fail("closed", c.readErr)
+		case msg.Type == msgType:
+			return msg, nil
+		case msg.Type == dto.MessageTypeMatchTimeout:
+			return msg, fail("match_timeout", errors.New("server gave up matching"))
+		case msg.Type == dto.MessageTypeError:
+			return msg, fail("server_error", fmt.Errorf("%v", msg.Payload))
+		default:
+			return msg, fail("unexpected_"+msg.Type, fmt.Errorf("got %s while waiting for %s", msg.Type, msgType))
+		}
+	case <-timer.C:
+		return dto.Message{}, fail("timeout_"+msgType, fmt.Errorf("no %s within %s", msgType, c.opts.Timeout))
+	}
+}
+
+// read splits frames into messages; the server puts messages that queued up
+// while it was writing into one frame, one per line
+func (c *client) read() {
+	defer close(c.messages)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var msg dto.Message
+			if err := decoder.Decode(&msg); err != nil {
+				c.readErr = err
+				return
+			}
+			c.stats.MessageReceived()
+			select {
+			case c.messages <- msg:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// authenticate returns an access token for the chosen -auth mode, or an
+// empty one to connect anonymously
+func (c *client) authenticate() (string, error) {
+	var result dto.AuthResponse
+	switch c.opts.Auth {
+	case "register":
+		name := fmt.Sprintf("lg%s%d", c.runID, c.index)
+		payload := dto.RegisterRequest{Username: name, Email: name + "@loadgen.invalid", Password: "loadgen-" + c.runID}
+		if err := postJSON(c.opts.URL+"/auth/register", payload, &result); err != nil {
+			return "", err
+		}
+	case "guest":
+		payload, err := solveGuestChallenge(c.opts.URL)
+		if err != nil {
+			return "", err
+		}
+		if err := postJSON(c.opts.URL+"/auth/guest", payload, &result); err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	return result.Token, nil
+}
+
+// solveGuestChallenge fetches a proof-of-work puzzle and finds its nonce.
+// The challenge endpoint answers 404 when proof-of-work is off, and the
+// guest request is sent without one.
+func solveGuestChallenge(baseURL string) (dto.GuestRequest, error) {
+	resp, err := httpClient.Get(baseURL + "/auth/guest/challenge")
+	if err != nil {
+		return dto.GuestRequest{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return dto.GuestRequest{}, nil
+	}
+
+	var challenge dto.GuestChallengeResponse
+	if err := decodeResponse(resp, &challenge); err != nil {
+		return dto.GuestRequest{}, err
+	}
+
+	for nonce := 0; ; nonce++ {
+		candidate := strconv.Itoa(nonce)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge.Challenge+":"+candidate))) >= challenge.Bits {
+			return dto.GuestRequest{Challenge: challenge.Challenge, Nonce: candidate}, nil
+		}
+	}
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
+
+func postJSON(endpoint string, payload, data any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, data)
+}
+
+// decodeResponse unwraps the data field of a successful response envelope
+func decodeResponse(resp *http.Response, data any) error {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: status %d: %s", resp.Request.URL.Path, resp.StatusCode, raw)
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Data, data)
+}
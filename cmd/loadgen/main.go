@@ -0,0 +1,177 @@
+// Command loadgen measures how many concurrent calls one signaling server
+// can carry. It opens simulated WebSocket clients against /ws at a fixed
+// rate; clients the server pairs up run the join, offer, answer and
+// candidate exchange with fake SDP, hold the call and leave. The run ends
+// with a JSON report of match and relay latency percentiles, error counts
+// and the server's memory from its metrics endpoint, for comparing runs.
+//
+//	go run ./cmd/loadgen -url http://localhost:8080 -clients 1000 -rate 100 -hold 30s
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type options struct {
+	URL          string
+	Clients      int
+	Rate         float64
+	Candidates   int
+	Interval     time.Duration
+	Hold         time.Duration
+	Timeout      time.Duration
+	Auth         string
+	MetricsURL   string
+	MetricsToken string
+	Out          string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("loadgen: ")
+
+	opts, err := parseFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	report := run(opts)
+
+	if err := writeReport(opts.Out, report); err != nil {
+		log.Fatal(err)
+	}
+	report.Summarize(log.Writer())
+}
+
+func parseFlags(args []string) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.StringVar(&opts.URL, "url", "http://localhost:8080", "base URL of the server; /ws and /auth are below it")
+	fs.IntVar(&opts.Clients, "clients", 100, "simulated clients, two per call")
+	fs.Float64Var(&opts.Rate, "rate", 50, "new connections per second, 0 opens all at once")
+	fs.IntVar(&opts.Candidates, "candidates", 4, "ICE candidates each side sends per call")
+	fs.DurationVar(&opts.Interval, "candidate-interval", 50*time.Millisecond, "pause between the candidates of one client")
+	fs.DurationVar(&opts.Hold, "hold", 10*time.Second, "how long a call stays up after the exchange, to keep calls concurrent")
+	fs.DurationVar(&opts.Timeout, "timeout", 30*time.Second, "longest wait for any expected message, including the match")
+	fs.StringVar(&opts.Auth, "auth", "none", "none, guest or register; guest tokens are rate limited per IP by the server")
+	fs.StringVar(&opts.MetricsURL, "metrics", "", `Prometheus endpoint to sample server memory from (default <url>/metrics, "off" disables)`)
+	fs.StringVar(&opts.MetricsToken, "metrics-token", os.Getenv("METRICS_TOKEN"), "bearer token of the metrics endpoint (default $METRICS_TOKEN)")
+	fs.StringVar(&opts.Out, "out", "loadgen-result.json", `file the JSON report is written to, "-" for stdout`)
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+
+	problems := validate(&opts)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(fs.Output(), "loadgen: "+problem)
+		}
+		fs.Usage()
+		return opts, flag.ErrHelp
+	}
+	return opts, nil
+}
+
+func validate(opts *options) []string {
+	var problems []string
+	base, err := url.Parse(opts.URL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		problems = append(problems, "-url must be an http:// or https:// URL")
+	}
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+
+	if opts.Clients < 2 || opts.Clients%2 != 0 {
+		problems = append(problems, "-clients must be an even number of at least 2, the server matches clients in pairs")
+	}
+	if opts.Rate < 0 {
+		problems = append(problems, "-rate must not be negative")
+	}
+	if opts.Candidates < 0 || opts.Interval < 0 || opts.Hold < 0 {
+		problems = append(problems, "-candidates, -candidate-interval and -hold must not be negative")
+	}
+	if opts.Timeout <= 0 {
+		problems = append(problems, "-timeout must be positive")
+	}
+	switch opts.Auth {
+	case "none", "guest", "register":
+	default:
+		problems = append(problems, "-auth must be none, guest or register")
+	}
+
+	switch opts.MetricsURL {
+	case "off":
+		opts.MetricsURL = ""
+	case "":
+		opts.MetricsURL = opts.URL + "/metrics"
+	}
+	return problems
+}
+
+// run opens the clients at the configured rate and waits until every one of
+// them has finished its call or failed, sampling server memory meanwhile
+func run(opts options) *Report {
+	stats := newStats()
+	runID := fmt.Sprintf("%x", time.Now().UnixNano()&0xffffffff)
+	log.Printf("starting %d clients at %g/s against %s (run %s)", opts.Clients, opts.Rate, opts.URL, runID)
+
+	sampleCtx, stopSampling := context.WithCancel(context.Background())
+	sampler := newSampler(opts.MetricsURL, opts.MetricsToken)
+	samplerDone := make(chan struct{})
+	go func() {
+		defer close(samplerDone)
+		sampler.Run(sampleCtx, time.Second)
+	}()
+
+	var pause time.Duration
+	if opts.Rate > 0 {
+		pause = time.Duration(float64(time.Second) / opts.Rate)
+	}
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := range opts.Clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &client{index: i, runID: runID, opts: opts, stats: stats}
+			c.Run()
+		}()
+		if pause > 0 {
+			time.Sleep(pause)
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	stopSampling()
+	<-samplerDone
+
+	return stats.Report(opts, started, elapsed, sampler.Result())
+}
+
+func writeReport(path string, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("report written to %s", path)
+	return nil
+}
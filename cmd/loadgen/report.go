@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is the JSON result of one run. Latencies are in milliseconds.
+type Report struct {
+	StartedAt      time.Time                `json:"started_at"`
+	ElapsedSeconds float64                  `json:"elapsed_seconds"`
+	Options        reportOptions            `json:"options"`
+	Clients        ClientCounts             `json:"clients"`
+	Messages       MessageCounts            `json:"messages"`
+	MatchLatency   Latency                  `json:"match_latency_ms"`
+	RelayLatency   map[string]Latency       `json:"relay_latency_ms"`
+	Errors         map[string]int           `json:"errors"`
+	Server         map[string]*ServerSample `json:"server,omitempty"`
+}
+
+// reportOptions repeats the flags of the run, with readable durations
+type reportOptions struct {
+	URL               string  `json:"url"`
+	Clients           int     `json:"clients"`
+	Rate              float64 `json:"rate"`
+	Candidates        int     `json:"candidates"`
+	CandidateInterval string  `json:"candidate_interval"`
+	Hold              string  `json:"hold"`
+	Timeout           string  `json:"timeout"`
+	Auth              string  `json:"auth"`
+	MetricsURL        string  `json:"metrics_url,omitempty"`
+}
+
+type ClientCounts struct {
+	Requested int `json:"requested"`
+	Connected int `json:"connected"`
+	Matched   int `json:"matched"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type MessageCounts struct {
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+}
+
+type Latency struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// stats collects the measurements of all clients
+type stats struct {
+	mutex     sync.Mutex
+	connected int
+	matched   []time.Duration
+	relayed   map[string][]time.Duration
+	completed int
+	sent      int
+	received  int
+	errors    map[string]int
+}
+
+func newStats() *stats {
+	return &stats{
+		relayed: make(map[string][]time.Duration),
+		errors:  make(map[string]int),
+	}
+}
+
+func (s *stats) Connected() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected++
+}
+
+func (s *stats) Matched(wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.matched = append(s.matched, wait)
+}
+
+func (s *stats) Relayed(msgType string, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.relayed[msgType] = append(s.relayed[msgType], latency)
+}
+
+func (s *stats) CallCompleted() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.completed++
+}
+
+func (s *stats) MessagesSent(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent += n
+}
+
+func (s *stats) MessageReceived() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.received++
+}
+
+func (s *stats) Error(kind string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors[kind]++
+}
+
+func (s *stats) Report(opts options, started time.Time, elapsed time.Duration, server map[string]*ServerSample) *Report {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	failed := 0
+	for _, n := range s.errors {
+		failed += n
+	}
+
+	var all []time.Duration
+	relay := make(map[string]Latency, len(s.relayed)+1)
+	for msgType, latencies := range s.relayed {
+		relay[msgType] = summarize(latencies)
+		all = append(all, latencies...)
+	}
+	relay["all"] = summarize(all)
+
+	return &Report{
+		StartedAt:      started.UTC(),
+		ElapsedSeconds: round(elapsed.Seconds()),
+		Options: reportOptions{
+			URL:               opts.URL,
+			Clients:           opts.Clients,
+			Rate:              opts.Rate,
+			Candidates:        opts.Candidates,
+			CandidateInterval: opts.Interval.String(),
+			Hold:              opts.Hold.String(),
+			Timeout:           opts.Timeout.String(),
+			Auth:              opts.Auth,
+			MetricsURL:        opts.MetricsURL,
+		},
+		Clients: ClientCounts{
+			Requested: opts.Clients,
+			Connected: s.connected,
+			Matched:   len(s.matched),
+			Completed: s.completed,
+			Failed:    failed,
+		},
+		Messages:     MessageCounts{Sent: s.sent, Received: s.received},
+		MatchLatency: summarize(s.matched),
+		RelayLatency: relay,
+		Errors:       s.errors,
+		Server:       server,
+	}
+}
+
+// summarize computes nearest-rank percentiles
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		return ms(sorted[max(rank, 0)])
+	}
+
+	return Latency{
+		Count: len(sorted),
+		Mean:  ms(total / time.Duration(len(sorted))),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P95:   percentile(95),
+		P99:   percentile(99),
+		Max:   ms(sorted[len(sorted)-1]),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return round(float64(d) / float64(time.Millisecond))
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+// Summarize prints the headline numbers for a human watching the run
+func (r *Report) Summarize(w io.Writer) {
+	fmt.Fprintf(w, "clients: %d connected, %d matched, %d completed, %d failed in %.1fs\n",
+		r.Clients.Connected, r.Clients.Matched, r.Clients.Completed, r.Clients.Failed, r.ElapsedSeconds)
+	fmt.Fprintf(w, "match latency ms: p50 %.1f  p99 %.1f  max %.1f\n", r.MatchLatency.P50, r.MatchLatency.P99, r.MatchLatency.Max)
+	all := r.RelayLatency["all"]
+	fmt.Fprintf(w, "relay latency ms: p50 %.2f  p99 %.2f  max %.2f (%d messages)\n", all.P50, all.P99, all.Max, all.Count)
+
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "errors %s: %d\n", kind, r.Errors[kind])
+	}
+
+	if peak := r.Server["peak"]; peak != nil {
+		fmt.Fprintf(w, "server peak: %.1f MiB resident, %.1f MiB heap, %.0f goroutines\n",
+			peak.ResidentBytes/(1<<20), peak.HeapInuseBytes/(1<<20), peak.Goroutines)
+	}
+}
+
+// ServerSample is what the server reported on its metrics endpoint
+type ServerSample struct {
+	ResidentBytes   float64 `json:"resident_bytes"`
+	HeapInuseBytes  float64 `json:"heap_inuse_bytes"`
+	Goroutines      float64 `json:"goroutines"`
+	DroppedMessages float64 `json:"dropped_messages"`
+}
+
+// serverMetrics maps Prometheus series to the sample field they fill. Series
+// with labels are summed.
+var serverMetrics = map[string]func(s *ServerSample) *float64{
+	"process_resident_memory_bytes":    func(s *ServerSample) *float64 { return &s.ResidentBytes },
+	"go_memstats_heap_inuse_bytes":     func(s *ServerSample) *float64 { return &s.HeapInuseBytes },
+	"go_goroutines":                    func(s *ServerSample) *float64 { return &s.Goroutines },
+	"webcurhat_messages_dropped_total": func(s *ServerSample) *float64 { return &s.DroppedMessages },
+}
+
+// sampler polls the metrics endpoint during the run and keeps the first,
+// last and peak values. The server must run with METRICS_ENABLED=true.
+type sampler struct {
+	url    string
+	token  string
+	before *ServerSample
+	after  *ServerSample
+	peak   *ServerSample
+	failed error
+}
+
+func newSampler(url, token string) *sampler {
+	return &sampler{url: url, token: token}
+}
+
+func (s *sampler) Run(ctx context.Context, every time.Duration) {
+	if s.url == "" {
+		return
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		s.sample()
+		select {
+		case <-ctx.Done():
+			s.sample()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *sampler) sample() {
+	current, err := scrape(s.url, s.token)
+	if err != nil {
+		s.failed = err
+		return
+	}
+
+	if s.before == nil {
+		s.before = current
+		peak := *current
+		s.peak = &peak
+	}
+	s.after = current
+	s.peak.ResidentBytes = max(s.peak.ResidentBytes, current.ResidentBytes)
+	s.peak.HeapInuseBytes = max(s.peak.HeapInuseBytes, current.HeapInuseBytes)
+	s.peak.Goroutines = max(s.peak.Goroutines, current.Goroutines)
+	s.peak.DroppedMessages = max(s.peak.DroppedMessages, current.DroppedMessages)
+}
+
+// Result is nil when sampling was off or never succeeded
+func (s *sampler) Result() map[string]*ServerSample {
+	if s.before == nil {
+		if s.failed != nil {
+			log.Printf("no server metrics: %v", s.failed)
+		}
+		return nil
+	}
+	return map[string]*ServerSample{"before": s.before, "peak": s.peak, "after": s.after}
+}
+
+// scrape reads the Prometheus text format, which is one "name{labels} value"
+// per line with comments starting with #
+func scrape(url, token string) (*ServerSample, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+
+	var sample ServerSample
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lines.Scan() {
+		line := lines.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Label values may contain spaces, so the value starts after the labels
+		var name, value string
+		if open := strings.IndexByte(line, '{'); open >= 0 {
+			end := strings.LastIndexByte(line, '}')
+			if end < open {
+				continue
+			}
+			name, value = line[:open], line[end+1:]
+		} else {
+			var ok bool
+			if name, value, ok = strings.Cut(line, " "); !ok {
+				continue
+			}
+		}
+		field, known := serverMetrics[name]
+		if !known {
+			continue
+		}
+		// A timestamp may follow the value
+		value, _, _ = strings.Cut(strings.TrimSpace(value), " ")
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			*field(&sample) += f
+		}
+	}
+	return &sample, lines.Err()
+}
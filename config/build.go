@@ -0,0 +1,33 @@
+package config
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// Version and Commit are set at build time:
+//
+//	go build -ldflags "-X projectwebcurhat/config.Version=1.4.0 -X projectwebcurhat/config.Commit=$(git rev-parse --short HEAD)"
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// StartTime is when the process started, used to report uptime
+var StartTime = time.Now()
+
+// BuildCommit returns Commit, falling back to the VCS revision the Go
+// toolchain embeds when building from a git checkout
+func BuildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -2,88 +2,360 @@ package config
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
 )
 
+// AppConfig is the typed configuration of every subsystem. Each leaf has a
+// dotted key used in config files and flags (`config` tag) and usually an
+// environment variable (`env` tag); see Load for how the sources are layered.
 type AppConfig struct {
-	Port           int
-	IsProduction   bool
-	AllowedOrigins []string
-	MaxRoomSize    int
-	DbURI          string
-	JWTSecret      string
-	AccessTokenTTL int64 // in seconds
+	Server    ServerConfig    `config:"server"`
+	Log       LogConfig       `config:"log"`
+	Database  DatabaseConfig  `config:"database"`
+	CORS      CORSConfig      `config:"cors"`
+	WebSocket WebSocketConfig `config:"websocket"`
+	Matching  MatchingConfig  `config:"matching"`
+	Cluster   ClusterConfig   `config:"cluster"`
+	Redis     RedisConfig     `config:"redis"`
+	NATS      NATSConfig      `config:"nats"`
+	JWT       JWTConfig       `config:"jwt"`
+	OAuth     OAuthConfig     `config:"oauth"`
+	Guest     GuestConfig     `config:"guest"`
+	Metrics   MetricsConfig   `config:"metrics"`
+	Tracing   TracingConfig   `config:"tracing"`
+	Health    HealthConfig    `config:"health"`
 }
 
-var cfg *AppConfig
+type ServerConfig struct {
+	Port            int           `config:"port" env:"PORT" help:"HTTP port"`
+	Production      bool          `config:"production" env:"IS_PRODUCTION" help:"enable production safety checks"`
+	NodeID          string        `config:"node_id" env:"NODE_ID" help:"identifies this replica in shared room state (default hostname-pid)"`
+	TLS             TLSConfig     `config:"tls"`
+	ShutdownDrain   time.Duration `config:"shutdown_drain" env:"SHUTDOWN_DRAIN_TIMEOUT" help:"how long calls may continue after SIGTERM"`
+	ShutdownTimeout time.Duration `config:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"time allowed for in-flight HTTP requests once draining is over"`
+	TrustedProxies  []string      `config:"trusted_proxies" env:"TRUSTED_PROXIES" help:"comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For is believed, empty trusts none"`
+}
 
-func Get() *AppConfig {
-	return cfg
+// TLSConfig serves HTTPS and WSS directly when both files are set
+type TLSConfig struct {
+	CertFile string `config:"cert_file" env:"TLS_CERT_FILE" help:"PEM certificate chain"`
+	KeyFile  string `config:"key_file" env:"TLS_KEY_FILE" help:"PEM private key"`
 }
 
-func Load() {
-	log.Println("Loading config from environment...")
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
 
-	// Load .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Printf("Warning: Error loading .env file, using OS environment variables: %v", err)
+type LogConfig struct {
+	Level  slog.Level `config:"level" env:"LOG_LEVEL" help:"debug, info, warn or error"`
+	Format string     `config:"format" env:"LOG_FORMAT" help:"json or text"`
+}
+
+// DatabaseConfig is either a DATABASE_URL or the separate connection fields
+type DatabaseConfig struct {
+	URL            string     `config:"url" env:"DATABASE_URL" secret:"true" help:"postgres://, sqlite: or file: URL, overrides the separate fields"`
+	Host           string     `config:"host" env:"DB_HOST"`
+	Port           int        `config:"port" env:"DB_PORT"`
+	User           string     `config:"user" env:"DB_USER"`
+	Password       string     `config:"password" env:"DB_PASS" secret:"true"`
+	Name           string     `config:"name" env:"DB_NAME"`
+	SSLMode        string     `config:"sslmode" env:"DB_SSLMODE" help:"disable, allow, prefer, require, verify-ca or verify-full"`
+	TimeZone       string     `config:"time_zone" env:"DB_TIME_ZONE"`
+	MigrateOnStart bool       `config:"migrate_on_start" env:"MIGRATE_ON_START" help:"apply pending migrations when the server starts"`
+	Pool           PoolConfig `config:"pool"`
+	// ReplicaURLs serve profile and session history reads, see repository.New
+	ReplicaURLs      []string      `config:"replica_urls" env:"DATABASE_REPLICA_URLS" secret:"true" help:"comma separated postgres:// URLs of read replicas"`
+	ConnectTimeout   time.Duration `config:"connect_timeout" env:"DB_CONNECT_TIMEOUT" help:"how long startup keeps retrying while the database comes up"`
+	QueryTimeout     time.Duration `config:"query_timeout" env:"DB_QUERY_TIMEOUT" help:"context deadline of each user query"`
+	StatementTimeout time.Duration `config:"statement_timeout" env:"DB_STATEMENT_TIMEOUT" help:"Postgres statement_timeout of every connection, 0 disables it"`
+}
+
+// DSN returns DATABASE_URL when set and a key/value DSN built from the
+// separate fields otherwise. SQLite URLs become a go-sqlite3 DSN.
+func (d DatabaseConfig) DSN() string {
+	if path, params, ok := d.sqlite(); ok {
+		return sqliteDSN(path, params)
+	}
+	if d.URL != "" {
+		return d.withStatementTimeout(d.URL)
 	}
+	return d.withStatementTimeout(fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d TimeZone=%s sslmode=%s",
+		d.Host, d.User, d.Password, d.Name, d.Port, d.TimeZone, d.SSLMode))
+}
 
-	port, err := strconv.Atoi(os.Getenv("PORT"))
-	if err != nil {
-		port = 8080
+// ReplicaDSNs returns the replica URLs with the same statement timeout as the primary
+func (d DatabaseConfig) ReplicaDSNs() []string {
+	dsns := make([]string, len(d.ReplicaURLs))
+	for i, replica := range d.ReplicaURLs {
+		dsns[i] = d.withStatementTimeout(replica)
+	}
+	return dsns
+}
+
+// withStatementTimeout adds statement_timeout as a runtime parameter, which
+// pgx sends to the server when connecting
+func (d DatabaseConfig) withStatementTimeout(dsn string) string {
+	if d.StatementTimeout <= 0 {
+		return dsn
 	}
+	ms := d.StatementTimeout.Milliseconds()
 
-	isProduction := os.Getenv("IS_PRODUCTION") == "true"
+	parsed, err := url.Parse(dsn)
+	if err != nil || parsed.Scheme == "" {
+		return fmt.Sprintf("%s statement_timeout=%d", dsn, ms)
+	}
+	query := parsed.Query()
+	if !query.Has("statement_timeout") {
+		query.Set("statement_timeout", fmt.Sprint(ms))
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
 
-	maxRoomSize, err := strconv.Atoi(os.Getenv("MAX_ROOM_SIZE"))
-	if err != nil || maxRoomSize <= 0 {
-		maxRoomSize = 2
+// Dialect is "sqlite" when DATABASE_URL uses the sqlite: or file: scheme and
+// "postgres" otherwise
+func (d DatabaseConfig) Dialect() string {
+	if _, _, ok := d.sqlite(); ok {
+		return "sqlite"
 	}
+	return "postgres"
+}
+
+// InMemory reports whether the database is a SQLite in-memory database, which
+// lives only as long as its connection
+func (d DatabaseConfig) InMemory() bool {
+	path, params, ok := d.sqlite()
+	return ok && (path == ":memory:" || params.Get("mode") == "memory")
+}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "default-secret-change-me"
-		log.Println("[WARN] JWT_SECRET not set, using insecure default for development")
+// sqlite splits a SQLite DATABASE_URL into its path and query. Accepted are
+// sqlite::memory:, sqlite:app.db, sqlite://app.db, sqlite:///var/lib/app.db
+// and SQLite's own file: URIs.
+func (d DatabaseConfig) sqlite() (string, url.Values, bool) {
+	scheme, rest, ok := strings.Cut(d.URL, ":")
+	if !ok {
+		return "", nil, false
+	}
+	switch strings.ToLower(scheme) {
+	case "sqlite", "sqlite3":
+		rest = strings.TrimPrefix(rest, "//")
+	case "file":
+	default:
+		return "", nil, false
 	}
 
-	accessTokenTTL, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_TTL"))
-	if err != nil || accessTokenTTL <= 0 {
-		accessTokenTTL = 86400 // 24 hours
+	path, query, _ := strings.Cut(rest, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		params = url.Values{}
 	}
+	return path, params, true
+}
 
-	cfg = &AppConfig{
-		Port:           port,
-		IsProduction:   isProduction,
-		AllowedOrigins: []string{"*"},
-		MaxRoomSize:    maxRoomSize,
-		DbURI:          loadDatabaseConfig(),
-		JWTSecret:      jwtSecret,
-		AccessTokenTTL: int64(accessTokenTTL),
+// sqliteDSN enables foreign keys, which cascade deletes of users, and waits
+// on a locked database instead of failing at once. File databases use WAL so
+// readers do not block the writer.
+func sqliteDSN(path string, params url.Values) string {
+	defaults := map[string]string{"_foreign_keys": "1", "_busy_timeout": "5000"}
+	if path != ":memory:" && params.Get("mode") != "memory" {
+		defaults["_journal_mode"] = "WAL"
 	}
+	for key, value := range defaults {
+		if !params.Has(key) {
+			params.Set(key, value)
+		}
+	}
+	return "file:" + path + "?" + params.Encode()
+}
+
+type PoolConfig struct {
+	MaxOpenConns    int           `config:"max_open_conns" env:"DB_MAX_OPEN_CONNS" help:"0 means unlimited"`
+	MaxIdleConns    int           `config:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `config:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `config:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" help:"0 keeps idle connections until their lifetime ends"`
+}
+
+type CORSConfig struct {
+	// Exact origins, "https://*.example.com" wildcards or "*". Defaults to *
+	// in development and to nothing (same-origin only) in production.
+	AllowedOrigins   []string      `config:"allowed_origins" env:"ALLOWED_ORIGINS" help:"comma separated origins, wildcards like https://*.example.com"`
+	AllowCredentials bool          `config:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `config:"max_age" env:"CORS_MAX_AGE" help:"how long browsers may cache a preflight result"`
+}
+
+type WebSocketConfig struct {
+	ReadBufferSize     int           `config:"read_buffer_size" env:"WS_READ_BUFFER_SIZE"`
+	WriteBufferSize    int           `config:"write_buffer_size" env:"WS_WRITE_BUFFER_SIZE"`
+	MaxMessageSize     int64         `config:"max_message_size" env:"WS_MAX_MESSAGE_SIZE" help:"largest message a client may send, in bytes"`
+	MaxSDPSize         int           `config:"max_sdp_size" env:"WS_MAX_SDP_SIZE" help:"largest SDP in an offer or answer, in bytes"`
+	MaxCandidateSize   int           `config:"max_candidate_size" env:"WS_MAX_CANDIDATE_SIZE" help:"largest ICE candidate string, in bytes"`
+	MinProtocolVersion int           `config:"min_protocol_version" env:"WS_MIN_PROTOCOL_VERSION" help:"oldest signaling protocol version clients may speak, older ones are told to upgrade"`
+	SendBuffer         int           `config:"send_buffer" env:"WS_SEND_BUFFER" help:"messages queued per client before it is disconnected as too slow"`
+	PingInterval       time.Duration `config:"ping_interval" env:"WS_PING_INTERVAL"`
+	PongWait           time.Duration `config:"pong_wait" env:"WS_PONG_WAIT" help:"a client silent for this long is disconnected"`
+	WriteWait          time.Duration `config:"write_wait" env:"WS_WRITE_WAIT"`
+}
+
+type MatchingConfig struct {
+	MaxRoomSize int    `config:"max_room_size" env:"MAX_ROOM_SIZE" help:"peers per room, calls are one to one"`
+	RoomStore   string `config:"room_store" env:"ROOM_STORE" help:"memory or redis"`
+}
+
+type ClusterConfig struct {
+	Bus           string        `config:"bus" env:"BUS" help:"memory, redis or nats"`
+	NodeHeartbeat time.Duration `config:"node_heartbeat" env:"NODE_HEARTBEAT"`
+	NodeTimeout   time.Duration `config:"node_timeout" env:"NODE_TIMEOUT" help:"a replica silent for this long is treated as dead"`
+}
+
+type RedisConfig struct {
+	URL string `config:"url" env:"REDIS_URL" secret:"true"`
+}
+
+type NATSConfig struct {
+	URL string `config:"url" env:"NATS_URL" secret:"true"`
+}
+
+type JWTConfig struct {
+	Algorithm      string        `config:"algorithm" env:"JWT_ALGORITHM" help:"RS256, EdDSA or HS256"`
+	Secret         string        `config:"secret" env:"JWT_SECRET" secret:"true" help:"shared secret for HS256"`
+	KeysDir        string        `config:"keys_dir" env:"JWT_KEYS_DIR" help:"directory of PEM signing keys for RS256 and EdDSA"`
+	KeyRotation    time.Duration `config:"key_rotation" env:"JWT_KEY_ROTATION" help:"generate a new signing key this often, 0 disables rotation"`
+	KeyReload      time.Duration `config:"key_reload" env:"JWT_KEY_RELOAD"`
+	AccessTokenTTL time.Duration `config:"access_token_ttl" env:"ACCESS_TOKEN_TTL"`
+}
+
+// OAuthConfig is read from the oauth.providers table of the config file and
+// from OAUTH_PROVIDERS plus OAUTH_<NAME>_* variables
+type OAuthConfig struct {
+	Providers map[string]OAuthProvider `config:"-"`
+}
+
+// OAuthProvider holds the client settings for one OIDC identity provider
+type OAuthProvider struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type GuestConfig struct {
+	RateLimit int           `config:"rate_limit" env:"GUEST_RATE_LIMIT" help:"guest tokens per IP per hour"`
+	PoWBits   int           `config:"pow_bits" env:"GUEST_POW_BITS" help:"leading zero bits required by the guest proof-of-work, 0 disables it"`
+	IdleTTL   time.Duration `config:"idle_ttl" env:"GUEST_IDLE_TTL" help:"guests without a session seen for this long are deleted, 0 keeps them"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `config:"enabled" env:"METRICS_ENABLED" help:"expose Prometheus metrics on /metrics"`
+	Token   string `config:"token" env:"METRICS_TOKEN" secret:"true" help:"bearer token scrapers must send to /metrics, empty leaves it open"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `config:"exporter" env:"TRACING_EXPORTER" help:"none, stdout or otlp"`
+	SampleRatio float64 `config:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type HealthConfig struct {
+	CheckTimeout time.Duration `config:"check_timeout" env:"HEALTH_CHECK_TIMEOUT" help:"bound for each readiness check"`
+}
+
+// InsecureJWTSecret is the development fallback for HS256, never accepted in production
+const InsecureJWTSecret = "default-secret-change-me"
+
+var cfg *AppConfig
+
+func Get() *AppConfig {
+	return cfg
 }
 
-func loadDatabaseConfig() string {
-	host := getEnvOrDefault("DB_HOST", "localhost")
-	user := getEnvOrDefault("DB_USER", "postgres")
-	pass := getEnvOrDefault("DB_PASS", "postgres")
-	name := getEnvOrDefault("DB_NAME", "webcurhat")
-	port := getEnvOrDefault("DB_PORT", "5432")
-	timeZone := getEnvOrDefault("DB_TIME_ZONE", "Asia/Makassar")
+// defaults are the lowest layer. Values that depend on other settings, like
+// the CORS origins and the node ID, are filled in after all layers are applied.
+func defaults() *AppConfig {
+	return &AppConfig{
+		Server: ServerConfig{
+			Port:            8080,
+			ShutdownDrain:   30 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  slog.LevelInfo,
+			Format: "json",
+		},
+		Database: DatabaseConfig{
+			Host:           "localhost",
+			Port:           5432,
+			User:           "postgres",
+			Password:       "postgres",
+			Name:           "webcurhat",
+			SSLMode:        "disable",
+			TimeZone:       "Asia/Makassar",
+			MigrateOnStart: true,
+			ConnectTimeout: 30 * time.Second,
+			QueryTimeout:   5 * time.Second,
+			Pool: PoolConfig{
+				MaxOpenConns:    25,
+				MaxIdleConns:    10,
+				ConnMaxLifetime: time.Hour,
+			},
+		},
+		CORS: CORSConfig{
+			MaxAge: 10 * time.Minute,
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:     1024,
+			WriteBufferSize:    1024,
+			MaxMessageSize:     64 * 1024,
+			MaxSDPSize:         32 * 1024,
+			MaxCandidateSize:   1024,
+			MinProtocolVersion: 1,
+			SendBuffer:         256,
+			PingInterval:       30 * time.Second,
+			PongWait:           60 * time.Second,
+			WriteWait:          10 * time.Second,
+		},
+		Matching: MatchingConfig{
+			MaxRoomSize: 2,
+			RoomStore:   "memory",
+		},
+		Cluster: ClusterConfig{
+			Bus:           "memory",
+			NodeHeartbeat: 5 * time.Second,
+			NodeTimeout:   15 * time.Second,
+		},
+		Redis: RedisConfig{URL: "redis://localhost:6379/0"},
+		NATS:  NATSConfig{URL: "nats://localhost:4222"},
+		JWT: JWTConfig{
+			Algorithm:      "RS256",
+			KeyReload:      time.Minute,
+			AccessTokenTTL: 24 * time.Hour,
+		},
+		Guest: GuestConfig{
+			RateLimit: 10,
+			IdleTTL:   7 * 24 * time.Hour,
+		},
+		Metrics: MetricsConfig{Enabled: true},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Health: HealthConfig{CheckTimeout: 2 * time.Second},
+	}
+}
 
-	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s TimeZone=%s sslmode=disable",
-		host, user, pass, name, port, timeZone)
+// wellKnownIssuers lets common providers be enabled without spelling out the issuer URL
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
 }
 
-func getEnvOrDefault(key, defaultVal string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultVal
+// redactURL hides the password of a URL, for printing settings
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
 	}
-	return value
+	return parsed.Redacted()
 }
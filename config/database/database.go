@@ -2,52 +2,138 @@ package database
 
 import (
 	"database/sql"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
 	"projectwebcurhat/config"
+	models "projectwebcurhat/database"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 )
 
+// ConnectDB opens the primary database and, when configured, routes reads
+// of users and sessions to the read replicas. While Postgres is still coming
+// up, connecting is retried with exponential backoff for DB_CONNECT_TIMEOUT.
+// A sqlite: or file: DATABASE_URL opens SQLite instead, for development and
+// tests without a Postgres server.
 func ConnectDB() (*gorm.DB, *sql.DB, error) {
 	cfg := config.Get()
 
-	sqlLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Info,
-			IgnoreRecordNotFoundError: true,
-			ParameterizedQueries:      true,
-			Colorful:                  true,
-		})
-
-	log.Println("Connecting to database...")
-
-	db, err := gorm.Open(postgres.Open(cfg.DbURI), &gorm.Config{
-		Logger:                 sqlLogger,
-		SkipDefaultTransaction: true,
-		AllowGlobalUpdate:      false,
+	// Statements are logged with placeholders only, so no values leak into logs
+	sqlLogger := logger.NewSlogLogger(slog.Default(), logger.Config{
+		SlowThreshold:             time.Second,
+		LogLevel:                  gormLogLevel(cfg.Log.Level),
+		IgnoreRecordNotFoundError: true,
+		ParameterizedQueries:      true,
 	})
 
+	slog.Info("Connecting to database", "dialect", cfg.Database.Dialect())
+
+	db, err := openWithRetry(dialector(cfg.Database), cfg.Database.ConnectTimeout, func() *gorm.Config {
+		return &gorm.Config{
+			// Failed attempts are logged once below instead of by GORM too
+			Logger:                 sqlLogger.LogMode(logger.Silent),
+			SkipDefaultTransaction: true,
+			AllowGlobalUpdate:      false,
+		}
+	})
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		return nil, nil, err
 	}
+	db.Logger = sqlLogger
 
-	log.Println("Setting database connection configuration...")
+	slog.Debug("Setting database connection configuration")
 
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("Error setting database connection configuration: %v", err)
+		return nil, nil, err
+	}
+
+	pool := cfg.Database.Pool
+	if cfg.Database.InMemory() {
+		// Every connection to :memory: opens a new empty database, so the one
+		// connection that holds the data must never be closed or replaced
+		pool = config.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1}
 	}
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
 
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	if replicas := cfg.Database.ReplicaDSNs(); len(replicas) > 0 {
+		// A replica that is down should not keep the primary from serving
+		if err := useReplicas(db, replicas, pool); err != nil {
+			slog.Error("Failed to connect to read replicas, reading from the primary", "error", err)
+		} else {
+			slog.Info("Read replicas enabled", "replicas", len(replicas))
+		}
+	}
 
-	log.Println("Database connected successfully")
+	slog.Info("Database connected successfully")
 	return db, sqlDB, nil
 }
+
+// dialector picks the GORM driver from the scheme of DATABASE_URL
+func dialector(cfg config.DatabaseConfig) func() gorm.Dialector {
+	dsn := cfg.DSN()
+	if cfg.Dialect() == "sqlite" {
+		return func() gorm.Dialector { return sqlite.Open(dsn) }
+	}
+	return func() gorm.Dialector { return postgres.Open(dsn) }
+}
+
+func openWithRetry(newDialector func() gorm.Dialector, timeout time.Duration, newConfig func() *gorm.Config) (*gorm.DB, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := 500 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		db, err := gorm.Open(newDialector(), newConfig())
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+
+		slog.Warn("Database not reachable, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// useReplicas sends reads of users and sessions to the replicas. Repositories
+// pin reads that must see the latest write to the primary.
+func useReplicas(db *gorm.DB, dsns []string, pool config.PoolConfig) error {
+	replicas := make([]gorm.Dialector, len(dsns))
+	for i, dsn := range dsns {
+		replicas[i] = postgres.Open(dsn)
+	}
+
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}, &models.User{}, &models.Session{}).
+		SetMaxOpenConns(pool.MaxOpenConns).
+		SetMaxIdleConns(pool.MaxIdleConns).
+		SetConnMaxLifetime(pool.ConnMaxLifetime).
+		SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+
+	return db.Use(resolver)
+}
+
+// gormLogLevel logs every statement only at debug level; otherwise just slow
+// queries and errors are written
+func gormLogLevel(level slog.Level) logger.LogLevel {
+	switch {
+	case level <= slog.LevelDebug:
+		return logger.Info
+	case level <= slog.LevelWarn:
+		return logger.Warn
+	default:
+		return logger.Error
+	}
+}
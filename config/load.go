@@ -0,0 +1,514 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Sources are the explicit inputs to Load: a config file and flag values
+// keyed by setting key. Both are optional.
+type Sources struct {
+	File  string
+	Flags map[string]string
+}
+
+// Error lists every problem found while loading, so all of them can be
+// fixed in one go
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Setting is one effective value, for printing. Secrets are masked.
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// setting is a leaf of AppConfig found by walking the config tags
+type setting struct {
+	key    string
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	levelType    = reflect.TypeOf(slog.Level(0))
+)
+
+// loaded remembers where each effective value came from, for Describe
+var loaded map[string]string
+
+// warnings are about legal but risky settings of the last Load. Load runs
+// before the logger is set up, so the logger reports them once it is.
+var loadWarnings []string
+
+// Warnings returns the warnings of the last successful Load
+func Warnings() []string {
+	return loadWarnings
+}
+
+// BindFlags registers -config and one flag per setting key on fs, for
+// example -server.port. The returned Sources are filled in by fs.Parse.
+func BindFlags(fs *flag.FlagSet) *Sources {
+	src := &Sources{Flags: make(map[string]string)}
+	fs.StringVar(&src.File, "config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+
+	for _, s := range settings(defaults()) {
+		key := s.key
+		usage := s.help
+		if s.env != "" {
+			usage = strings.TrimSpace(usage + " ($" + s.env + ")")
+		}
+		fs.Func(key, usage, func(value string) error {
+			src.Flags[key] = value
+			return nil
+		})
+	}
+	return src
+}
+
+// Load builds the configuration from, in increasing priority: defaults, the
+// config file (src.File or $CONFIG_FILE), the environment including .env, and
+// flags. Every value is parsed strictly and validated; all problems are
+// returned together as an *Error.
+func Load(src Sources) error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read .env: %w", err)
+	}
+
+	next := defaults()
+	all := settings(next)
+	byKey := make(map[string]setting, len(all))
+	for _, s := range all {
+		byKey[s.key] = s
+	}
+
+	sources := make(map[string]string)
+	var problems []string
+	set := func(key, raw, source string) {
+		s, ok := byKey[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting (from %s)", key, source))
+			return
+		}
+		if err := parseValue(s.value, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v (from %s)", key, err, source))
+			return
+		}
+		sources[key] = source
+	}
+
+	// Config file
+	file := src.File
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	var fileProviders map[string]map[string]string
+	if file != "" {
+		values, providers, err := readFile(file)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, key := range sortedKeys(values) {
+			set(key, values[key], "file "+file)
+		}
+		fileProviders = providers
+	}
+
+	// Environment, with NAME_FILE read as the value of NAME
+	for _, s := range all {
+		if s.env == "" {
+			continue
+		}
+		raw, source, ok, err := lookupEnv(s.env)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.key, err))
+			continue
+		}
+		if ok {
+			set(s.key, raw, source)
+		}
+	}
+
+	// Flags
+	for _, key := range sortedKeys(src.Flags) {
+		set(key, src.Flags[key], "flag -"+key)
+	}
+
+	providers, providerProblems := loadOAuthProviders(fileProviders)
+	next.OAuth.Providers = providers
+	problems = append(problems, providerProblems...)
+
+	risky := finish(next, sources)
+	problems = append(problems, validate(next)...)
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+
+	cfg = next
+	loaded = sources
+	loadWarnings = risky
+	return nil
+}
+
+// finish fills in values that depend on other settings and normalizes
+// spellings. It returns warnings about legal but risky combinations.
+func finish(c *AppConfig, sources map[string]string) []string {
+	var warnings []string
+
+	if _, ok := sources["cors.allowed_origins"]; !ok && !c.Server.Production {
+		c.CORS.AllowedOrigins = []string{"*"}
+	}
+	for i, origin := range c.CORS.AllowedOrigins {
+		c.CORS.AllowedOrigins[i] = strings.TrimSuffix(origin, "/")
+	}
+	if len(c.CORS.AllowedOrigins) == 0 && c.Server.Production {
+		warnings = append(warnings, "cors.allowed_origins is empty, cross-origin requests and WebSocket upgrades will be rejected")
+	}
+
+	if c.Server.NodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "node"
+		}
+		c.Server.NodeID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	c.Log.Format = strings.ToLower(c.Log.Format)
+	c.Matching.RoomStore = strings.ToLower(c.Matching.RoomStore)
+	c.Cluster.Bus = strings.ToLower(c.Cluster.Bus)
+	c.Tracing.Exporter = strings.ToLower(c.Tracing.Exporter)
+	c.JWT.Algorithm = strings.ToUpper(c.JWT.Algorithm)
+	if c.JWT.Algorithm == "EDDSA" {
+		c.JWT.Algorithm = "EdDSA"
+	}
+
+	if c.JWT.Secret == "" && c.JWT.Algorithm == "HS256" && !c.Server.Production {
+		c.JWT.Secret = InsecureJWTSecret
+		warnings = append(warnings, "jwt.secret not set, using insecure default for development")
+	}
+	if c.JWT.KeysDir == "" && c.JWT.Algorithm != "HS256" && !c.Server.Production {
+		warnings = append(warnings, "jwt.keys_dir not set, signing with ephemeral keys for development")
+	}
+	return warnings
+}
+
+// Describe lists every effective setting with its source. Secrets are masked.
+func Describe() []Setting {
+	if cfg == nil {
+		return nil
+	}
+
+	var result []Setting
+	for _, s := range settings(cfg) {
+		source := loaded[s.key]
+		if source == "" {
+			source = "default"
+		}
+		result = append(result, Setting{Key: s.key, Value: describeValue(s), Source: source})
+	}
+
+	for _, name := range sortedKeys(cfg.OAuth.Providers) {
+		provider := cfg.OAuth.Providers[name]
+		result = append(result, Setting{
+			Key:    "oauth.providers." + name,
+			Value:  fmt.Sprintf("client_id=%s issuer=%s redirect=%s", provider.ClientID, provider.IssuerURL, provider.RedirectURL),
+			Source: "oauth",
+		})
+	}
+	return result
+}
+
+func describeValue(s setting) string {
+	value := formatValue(s.value)
+	if !s.secret || value == "" {
+		return value
+	}
+	if strings.Contains(value, "://") {
+		parts := strings.Split(value, ",")
+		for i, part := range parts {
+			parts[i] = redactURL(part)
+		}
+		return strings.Join(parts, ",")
+	}
+	return "xxxxx"
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// settings walks the config tags of c and returns its leaves, whose values
+// point into c
+func settings(c *AppConfig) []setting {
+	var result []setting
+	walk(reflect.ValueOf(c).Elem(), "", &result)
+	return result
+}
+
+func walk(v reflect.Value, prefix string, result *[]setting) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("config")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			walk(v.Field(i), key+".", result)
+			continue
+		}
+
+		*result = append(*result, setting{
+			key:    key,
+			env:    field.Tag.Get("env"),
+			help:   field.Tag.Get("help"),
+			secret: field.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+}
+
+// parseValue parses raw strictly into v. Durations also accept a bare number
+// of seconds, which is how the older integer settings were written.
+func parseValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	switch v.Type() {
+	case durationType:
+		if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			v.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case levelType:
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(raw)); err != nil {
+			return fmt.Errorf("invalid log level %q", raw)
+		}
+		v.SetInt(int64(level))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// lookupEnv reads NAME, or the file named by NAME_FILE so secrets can come
+// from mounted files. Setting both is an error.
+func lookupEnv(name string) (value, source string, ok bool, err error) {
+	value, ok = os.LookupEnv(name)
+	path, fromFile := os.LookupEnv(name + "_FILE")
+	if !fromFile || path == "" {
+		return value, "env " + name, ok && value != "", nil
+	}
+	if ok && value != "" {
+		return "", "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false, fmt.Errorf("cannot read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), "env " + name + "_FILE", true, nil
+}
+
+// readFile parses a YAML or TOML file into dotted keys. The oauth.providers
+// table is returned separately because its keys are provider names.
+func readFile(path string) (map[string]string, map[string]map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	var tree map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &tree)
+	case ".toml":
+		err = toml.Unmarshal(content, &tree)
+	default:
+		return nil, nil, fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+
+	var providers map[string]map[string]string
+	if oauth, ok := tree["oauth"].(map[string]any); ok {
+		if table, ok := oauth["providers"].(map[string]any); ok {
+			providers = make(map[string]map[string]string)
+			for name, fields := range table {
+				flat := make(map[string]string)
+				if fields, ok := fields.(map[string]any); ok {
+					flatten(fields, "", flat)
+				}
+				providers[strings.ToLower(name)] = flat
+			}
+			delete(oauth, "providers")
+		}
+		if len(oauth) == 0 {
+			delete(tree, "oauth")
+		}
+	}
+
+	values := make(map[string]string)
+	flatten(tree, "", values)
+	return values, providers, nil
+}
+
+func flatten(tree map[string]any, prefix string, out map[string]string) {
+	for key, value := range tree {
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(value, prefix+key+".", out)
+		case []any:
+			parts := make([]string, len(value))
+			for i, item := range value {
+				parts[i] = fmt.Sprint(item)
+			}
+			out[prefix+key] = strings.Join(parts, ",")
+		default:
+			out[prefix+key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// loadOAuthProviders merges the providers of the config file with the ones
+// listed in OAUTH_PROVIDERS, whose OAUTH_<NAME>_* variables win
+func loadOAuthProviders(fromFile map[string]map[string]string) (map[string]OAuthProvider, []string) {
+	fields := make(map[string]map[string]string)
+	for name, values := range fromFile {
+		fields[name] = values
+	}
+
+	if list, ok := os.LookupEnv("OAUTH_PROVIDERS"); ok {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if fields[name] == nil {
+				fields[name] = make(map[string]string)
+			}
+
+			prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+			for _, field := range []string{"issuer_url", "client_id", "client_secret", "redirect_url", "scopes"} {
+				if value, _, ok, _ := lookupEnv(prefix + strings.ToUpper(field)); ok {
+					fields[name][field] = value
+				}
+			}
+		}
+	}
+
+	providers := make(map[string]OAuthProvider)
+	var problems []string
+	for _, name := range sortedKeys(fields) {
+		values := fields[name]
+		provider := OAuthProvider{
+			Name:         name,
+			IssuerURL:    values["issuer_url"],
+			ClientID:     values["client_id"],
+			ClientSecret: values["client_secret"],
+			RedirectURL:  values["redirect_url"],
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+		if provider.IssuerURL == "" {
+			provider.IssuerURL = wellKnownIssuers[name]
+		}
+		if scopes := values["scopes"]; scopes != "" {
+			provider.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+
+		for key := range values {
+			switch key {
+			case "issuer_url", "client_id", "client_secret", "redirect_url", "scopes":
+			default:
+				problems = append(problems, fmt.Sprintf("oauth.providers.%s.%s: unknown setting", name, key))
+			}
+		}
+		if provider.IssuerURL == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			problems = append(problems, fmt.Sprintf("oauth.providers.%s: issuer_url, client_id and redirect_url are required", name))
+			continue
+		}
+		providers[name] = provider
+	}
+	return providers, problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+
+	"projectwebcurhat/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// Init replaces the default logger. Standard library log calls are routed
+// through it as well, at info level. The warnings of config.Load are logged
+// right away, since there was no logger to take them before.
+func Init(cfg *config.AppConfig) {
+	InitWriter(cfg, os.Stdout)
+}
+
+// InitWriter is Init with the records written to w, so command line tools
+// can keep their stdout for results
+func InitWriter(cfg *config.AppConfig, w io.Writer) {
+	opts := &slog.HandlerOptions{
+		Level:       cfg.Log.Level,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+	if cfg.Log.Format == "text" {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	for _, warning := range config.Warnings() {
+		slog.Warn(warning)
+	}
+}
+
+// With returns a context whose log records carry the given attributes
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+	merged = append(merged, existing...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return With(ctx, slog.String("request_id", requestID))
+}
+
+// contextHandler adds the attributes stored with With and the current trace
+// ID to every record logged with a context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Fatal logs at error level and exits, the slog counterpart of log.Fatal
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,34 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/logger"
+)
+
+func TestConfigWarningsAreLogged(t *testing.T) {
+	flags := map[string]string{"server.production": "false", "jwt.algorithm": "HS256", "jwt.secret": "", "log.format": "json"}
+	if err := config.Load(config.Sources{Flags: flags}); err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+
+	var out bytes.Buffer
+	logger.InitWriter(config.Get(), &out)
+
+	for line := range bytes.Lines(out.Bytes()) {
+		var record struct {
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("logged %q, want JSON records: %v", line, err)
+		}
+		if record.Level == "WARN" && record.Msg == "jwt.secret not set, using insecure default for development" {
+			return
+		}
+	}
+	t.Fatalf("logged %q, want a warning about jwt.secret", out.String())
+}
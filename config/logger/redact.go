@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// sensitiveKeys are attributes whose value is never logged
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"id_token":      true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+	"client_secret": true,
+	"code":          true,
+	"sdp":           true,
+	"candidate":     true,
+}
+
+var (
+	emailPattern     = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	jwtPattern       = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+	sdpPattern       = regexp.MustCompile(`v=0(?:\\r\\n|\r\n|\\n|\n)o=[^"]*`)
+	candidatePattern = regexp.MustCompile(`candidate:[^"]*`)
+)
+
+// redact masks secrets and personal data before a record is written. Known
+// keys are dropped entirely; free text, including messages and errors, is
+// scrubbed of emails, JWTs and SDP or ICE candidate bodies.
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, "[REDACTED]")
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(scrub(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			attr.Value = slog.StringValue(scrub(err.Error()))
+		}
+	}
+	return attr
+}
+
+func scrub(value string) string {
+	value = jwtPattern.ReplaceAllString(value, "[REDACTED]")
+	value = sdpPattern.ReplaceAllString(value, "[REDACTED SDP]")
+	value = candidatePattern.ReplaceAllString(value, "[REDACTED CANDIDATE]")
+	return emailPattern.ReplaceAllStringFunc(value, maskEmail)
+}
+
+// maskEmail keeps the first character and the domain, which is usually
+// enough to tell accounts apart in an incident
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "[REDACTED]"
+	}
+	return email[:1] + "***" + email[at:]
+}
@@ -1,16 +1,43 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"projectwebcurhat/config/logger"
+	"projectwebcurhat/config/pkg/errs"
 	"projectwebcurhat/config/pkg/token"
+	"projectwebcurhat/contract"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates the JWT token from Authorization header
-func AuthMiddleware() gin.HandlerFunc {
+// StaticToken lets a request through only with "Authorization: Bearer
+// <expected>", for machine clients such as metrics scrapers. An empty
+// expected token lets every request through.
+func StaticToken(expected string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if expected == "" {
+			c.Next()
+			return
+		}
+
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header must be: Bearer <token>"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// AuthMiddleware validates the JWT token from Authorization header and
+// rejects tokens whose session has been revoked
+func AuthMiddleware(sessions contract.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -33,10 +60,24 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if err := sessions.ValidateSession(c.Request.Context(), claims.UserID, claims.SessionID); err != nil {
+			status, message := http.StatusUnauthorized, "Invalid session"
+			var messageErr errs.MessageError
+			if errors.As(err, &messageErr) {
+				status, message = messageErr.Status(), messageErr.Message()
+			}
+			c.JSON(status, gin.H{"error": message})
+			c.Abort()
+			return
+		}
+
 		// Set user info in context for downstream handlers
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
+		c.Set("isGuest", claims.Guest)
+		c.Set("sessionID", claims.SessionID)
+		c.Request = c.Request.WithContext(logger.With(c.Request.Context(), slog.Int("user_id", claims.UserID)))
 
 		c.Next()
 	}
@@ -0,0 +1,127 @@
+package middleware_test
+
+import (
+	"net/http"
+	"testing"
+
+	"projectwebcurhat/testutil"
+
+	"github.com/gorilla/websocket"
+)
+
+// originTests hold for WebSocket upgrades and CORS preflights alike. A
+// request without an Origin does not come from a browser page and is never
+// refused for it.
+var originTests = []struct {
+	name    string
+	origin  string
+	allowed bool
+}{
+	{"exact match", "https://app.example.com", true},
+	{"wildcard subdomain", "https://chat.example.org", true},
+	{"wildcard bare domain", "https://example.org", false},
+	{"unlisted origin", "https://evil.test", false},
+	{"missing origin", "", true},
+}
+
+func newServer(t *testing.T) *testutil.Server {
+	return testutil.NewServer(t, map[string]string{
+		"cors.allowed_origins":   "https://app.example.com,https://*.example.org",
+		"cors.allow_credentials": "true",
+		"cors.max_age":           "10m",
+	})
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s := newServer(t)
+
+	for _, tt := range originTests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+
+			conn, resp, err := websocket.DefaultDialer.Dial(s.WebSocketURL(""), header)
+			if conn != nil {
+				conn.Close()
+			}
+			if tt.allowed && err != nil {
+				t.Fatalf("upgrade refused: %v", err)
+			}
+			if !tt.allowed && (err == nil || resp == nil || resp.StatusCode != http.StatusForbidden) {
+				t.Fatalf("upgrade from %q was not refused with 403: %v", tt.origin, err)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := newServer(t)
+
+	for _, tt := range originTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodOptions, s.URL+"/auth/login", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			resp, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatalf("preflight: %v", err)
+			}
+			resp.Body.Close()
+
+			allowOrigin := resp.Header.Get("Access-Control-Allow-Origin")
+			switch {
+			case tt.origin == "":
+				// Not a browser preflight, so no CORS headers either way
+				if allowOrigin != "" {
+					t.Fatalf("Access-Control-Allow-Origin %q without an Origin", allowOrigin)
+				}
+			case tt.allowed:
+				if resp.StatusCode != http.StatusNoContent || allowOrigin != tt.origin {
+					t.Fatalf("status %d, allowed origin %q, want 204 and %q", resp.StatusCode, allowOrigin, tt.origin)
+				}
+				if resp.Header.Get("Access-Control-Allow-Credentials") != "true" || resp.Header.Get("Access-Control-Max-Age") != "600" {
+					t.Fatalf("credentials %q and max age %q, want true and 600",
+						resp.Header.Get("Access-Control-Allow-Credentials"), resp.Header.Get("Access-Control-Max-Age"))
+				}
+			default:
+				if resp.StatusCode != http.StatusForbidden || allowOrigin != "" {
+					t.Fatalf("status %d, allowed origin %q, want 403 without one", resp.StatusCode, allowOrigin)
+				}
+			}
+		})
+	}
+}
+
+// A wildcard allowlist answers every origin with *, never with credentials
+func TestCORSAnyOrigin(t *testing.T) {
+	s := testutil.NewServer(t, map[string]string{
+		"cors.allowed_origins":   "*",
+		"cors.allow_credentials": "true",
+	})
+
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "https://anything.test")
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("Access-Control-Allow-Origin %q, want *", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Fatalf("Access-Control-Allow-Credentials %q with a wildcard origin", got)
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/url"
+	"strings"
+)
+
+// OriginMatcher checks request origins against the configured allowlist.
+// Entries are exact origins ("https://app.example.com"), wildcard subdomains
+// ("https://*.example.com") or "*" for any origin.
+type OriginMatcher struct {
+	allowAll  bool
+	exact     map[string]bool
+	wildcards []wildcardOrigin
+}
+
+type wildcardOrigin struct {
+	scheme string
+	suffix string // ".example.com", optionally with ":port"
+}
+
+func NewOriginMatcher(origins []string) *OriginMatcher {
+	m := &OriginMatcher{exact: make(map[string]bool)}
+
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == "*":
+			m.allowAll = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			m.wildcards = append(m.wildcards, wildcardOrigin{scheme: scheme, suffix: host})
+		case origin != "":
+			m.exact[origin] = true
+		}
+	}
+
+	return m
+}
+
+// AllowAll reports whether the allowlist is "*"
+func (m *OriginMatcher) AllowAll() bool {
+	return m.allowAll
+}
+
+func (m *OriginMatcher) Allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if m.allowAll {
+		return true
+	}
+
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	normalized := u.Scheme + "://" + u.Host
+	if m.exact[normalized] {
+		return true
+	}
+
+	for _, w := range m.wildcards {
+		// The suffix starts with a dot, so "example.com" itself and
+		// look-alikes such as "evilexample.com" never match
+		if u.Scheme == w.scheme && strings.HasSuffix(u.Host, w.suffix) && len(u.Host) > len(w.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
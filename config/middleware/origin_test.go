@@ -0,0 +1,40 @@
+package middleware
+
+import "testing"
+
+func TestOriginMatcher(t *testing.T) {
+	allowlist := []string{"https://app.example.com", " HTTP://Localhost:3000 ", "https://*.example.org"}
+
+	tests := []struct {
+		name      string
+		allowlist []string
+		origin    string
+		want      bool
+	}{
+		{"exact match", allowlist, "https://app.example.com", true},
+		{"exact match ignores case", allowlist, "HTTPS://APP.Example.com", true},
+		{"exact match with port", allowlist, "http://localhost:3000", true},
+		{"other port", allowlist, "http://localhost:3001", false},
+		{"other scheme", allowlist, "http://app.example.com", false},
+		{"trailing path is ignored", allowlist, "https://app.example.com/", true},
+		{"unlisted origin", allowlist, "https://evil.test", false},
+		{"wildcard subdomain", allowlist, "https://chat.example.org", true},
+		{"wildcard nested subdomain", allowlist, "https://a.b.example.org", true},
+		{"wildcard excludes the bare domain", allowlist, "https://example.org", false},
+		{"wildcard excludes look-alikes", allowlist, "https://evilexample.org", false},
+		{"wildcard keeps the scheme", allowlist, "http://chat.example.org", false},
+		{"missing origin", allowlist, "", false},
+		{"opaque origin", allowlist, "null", false},
+		{"any origin", []string{"*"}, "https://anything.test", true},
+		{"any origin still needs one", []string{"*"}, "", false},
+		{"empty allowlist", nil, "https://app.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewOriginMatcher(tt.allowlist).Allowed(tt.origin); got != tt.want {
+				t.Fatalf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
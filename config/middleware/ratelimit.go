@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit allows at most limit() requests per client IP in each fixed
+// window. The limit is read on every request, so it can change at runtime.
+func RateLimit(limit func() int, window time.Duration) gin.HandlerFunc {
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	windowEnd := time.Now().Add(window)
+
+	return func(c *gin.Context) {
+		mutex.Lock()
+		now := time.Now()
+		if now.After(windowEnd) {
+			counts = make(map[string]int)
+			windowEnd = now.Add(window)
+		}
+
+		ip := c.ClientIP()
+		counts[ip]++
+		count := counts[ip]
+		retryAfter := int(time.Until(windowEnd).Seconds()) + 1
+		mutex.Unlock()
+
+		if count > limit() {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
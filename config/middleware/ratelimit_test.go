@@ -0,0 +1,50 @@
+package middleware_test
+
+import (
+	"net/http"
+	"testing"
+
+	"projectwebcurhat/testutil"
+)
+
+// TestRateLimitIgnoresForwardedForFromUntrustedPeers makes sure a client
+// cannot get a fresh guest limit by making up an X-Forwarded-For. Behind a
+// trusted proxy, the forwarded address is what is limited.
+func TestRateLimitIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		// statuses of three requests, each claiming another forwarded address
+		want []int
+	}{
+		{"no trusted proxies", "", []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests}},
+		{"untrusted peer", "10.0.0.0/8", []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests}},
+		{"trusted peer", "127.0.0.1", []int{http.StatusCreated, http.StatusCreated, http.StatusCreated}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testutil.NewServer(t, map[string]string{
+				"guest.rate_limit":       "2",
+				"server.trusted_proxies": tt.trustedProxies,
+			})
+
+			for i, forwardedFor := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+				req, err := http.NewRequest(http.MethodPost, s.URL+"/auth/guest", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("X-Forwarded-For", forwardedFor)
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.want[i] {
+					t.Fatalf("request %d from %s got %d, want %d", i+1, forwardedFor, resp.StatusCode, tt.want[i])
+				}
+			}
+		})
+	}
+}
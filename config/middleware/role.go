@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"projectwebcurhat/contract"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets users with one of the given roles through. It must
+// run after AuthMiddleware. The role is read from the database rather than
+// the token, so a demotion takes effect immediately.
+func RequireRole(auth contract.AuthService, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		profile, err := auth.GetProfile(c.Request.Context(), c.GetInt("userID"))
+		if err != nil || !slices.Contains(roles, profile.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Set("role", profile.Role)
+		c.Next()
+	}
+}
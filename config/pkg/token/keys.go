@@ -0,0 +1,321 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"projectwebcurhat/config"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one private key of the key set, identified by its kid
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+	retiredAt time.Time // zero while the key may still be used for signing
+}
+
+// keySet holds every key that can verify tokens that are still valid. Only the
+// newest non-retired key signs; retired keys are kept for the token TTL so
+// tokens issued just before a rotation keep working.
+type keySet struct {
+	mutex     sync.RWMutex
+	keys      map[string]*signingKey
+	active    *signingKey
+	algorithm string
+	dir       string
+	rotation  time.Duration
+	grace     time.Duration
+}
+
+func newKeySet(cfg *config.AppConfig) *keySet {
+	return &keySet{
+		keys:      make(map[string]*signingKey),
+		algorithm: cfg.JWT.Algorithm,
+		dir:       cfg.JWT.KeysDir,
+		rotation:  cfg.JWT.KeyRotation,
+		grace:     cfg.JWT.AccessTokenTTL,
+	}
+}
+
+// load reads every *.pem file in the key directory. Files that disappeared
+// since the last load are retired instead of being forgotten immediately.
+func (ks *keySet) load() error {
+	if ks.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*signingKey, len(paths))
+	for _, path := range paths {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load JWT key %s: %w", path, err)
+		}
+		// jwt.algorithm decides how tokens are signed. A key of another
+		// algorithm only verifies, until the grace period since it was
+		// written has passed, so switching algorithms keeps sessions alive.
+		if key.method.Alg() != ks.algorithm {
+			key.retiredAt = key.createdAt
+		}
+		loaded[key.kid] = key
+	}
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	now := time.Now()
+	for kid, key := range ks.keys {
+		if _, exists := loaded[kid]; !exists && key.retiredAt.IsZero() {
+			key.retiredAt = now
+		}
+	}
+	for kid, key := range loaded {
+		if _, exists := ks.keys[kid]; !exists {
+			ks.keys[kid] = key
+			if key.method.Alg() != ks.algorithm && now.Sub(key.retiredAt) <= ks.grace {
+				slog.Warn("JWT key does not match jwt.algorithm, using it for verification only",
+					"kid", kid, "key_algorithm", key.method.Alg(), "algorithm", ks.algorithm)
+			}
+		}
+	}
+
+	ks.pickActiveLocked()
+	return nil
+}
+
+// rotateIfDue creates a new signing key once the active one is older than the
+// rotation interval. The kid is derived from the rotation period, so replicas
+// sharing a key directory agree on it and only one of them writes the file.
+func (ks *keySet) rotateIfDue() error {
+	ks.mutex.RLock()
+	active := ks.active
+	ks.mutex.RUnlock()
+
+	now := time.Now()
+	if active != nil && (ks.rotation <= 0 || now.Sub(active.createdAt) < ks.rotation) {
+		return nil
+	}
+
+	kid := fmt.Sprintf("key-%d", now.Unix())
+	if ks.rotation > 0 {
+		kid = fmt.Sprintf("key-%d", now.Truncate(ks.rotation).Unix())
+	}
+
+	key, err := generateKey(kid, ks.algorithm)
+	if err != nil {
+		return err
+	}
+
+	if ks.dir != "" {
+		if err := writeKeyFile(ks.dir, key); err != nil {
+			return err
+		}
+		slog.Info("JWT signing key written", "kid", kid, "dir", ks.dir)
+		return ks.load()
+	}
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if _, exists := ks.keys[kid]; !exists {
+		ks.keys[kid] = key
+	}
+	ks.pickActiveLocked()
+	slog.Info("JWT signing key rotated to ephemeral key", "kid", kid)
+	return nil
+}
+
+// pickActiveLocked makes the newest usable key the signing key. Every older key
+// counts as retired from the moment its successor was created, and is dropped
+// once the grace period has passed. Callers must hold the write lock.
+func (ks *keySet) pickActiveLocked() {
+	ordered := make([]*signingKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		ordered = append(ordered, key)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].createdAt.After(ordered[j].createdAt)
+	})
+
+	now := time.Now()
+	ks.active = nil
+	for i, key := range ordered {
+		if key.retiredAt.IsZero() {
+			if ks.active == nil {
+				ks.active = key
+				continue
+			}
+			key.retiredAt = ordered[i-1].createdAt
+		}
+		if now.Sub(key.retiredAt) > ks.grace {
+			delete(ks.keys, key.kid)
+		}
+	}
+}
+
+func (ks *keySet) signingKey() (*signingKey, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if ks.active == nil {
+		return nil, errors.New("no active JWT signing key")
+	}
+	return ks.active, nil
+}
+
+func (ks *keySet) verificationKey(kid string) (*signingKey, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	key, exists := ks.keys[kid]
+	return key, exists
+}
+
+func (ks *keySet) publicKeys() jose.JSONWebKeySet {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	set := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       key.private.Public(),
+			KeyID:     key.kid,
+			Algorithm: key.method.Alg(),
+			Use:       "sig",
+		})
+	}
+	return set
+}
+
+// run reloads the key directory and rotates keys until the process exits
+func (ks *keySet) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := ks.load(); err != nil {
+			slog.Warn("Failed to reload JWT keys", "error", err)
+		}
+		if err := ks.rotateIfDue(); err != nil {
+			slog.Warn("Failed to rotate JWT key", "error", err)
+		}
+	}
+}
+
+func generateKey(kid, algorithm string) (*signingKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate JWT key: %w", err)
+	}
+
+	return newSigningKey(kid, private, time.Now())
+}
+
+func newSigningKey(kid string, private crypto.Signer, createdAt time.Time) (*signingKey, error) {
+	key := &signingKey{kid: kid, private: private, createdAt: createdAt}
+
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	return key, nil
+}
+
+func readKeyFile(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return newSigningKey(kid, private, info.ModTime())
+}
+
+// writeKeyFile stores the key as <kid>.pem. It is written to a temporary file
+// first and then linked into place, so readers never see a partial file and a
+// replica that loses the race simply reuses the winner's key.
+func writeKeyFile(dir string, key *signingKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".jwt-key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := pem.Encode(tmp, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	err = os.Link(tmp.Name(), filepath.Join(dir, key.kid+".pem"))
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
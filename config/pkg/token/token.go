@@ -1,38 +1,104 @@
 package token
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"projectwebcurhat/config"
+	"projectwebcurhat/database"
 
+	"github.com/go-jose/go-jose/v4"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Guest     bool   `json:"guest,omitempty"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a JWT token for the given user
-func GenerateToken(userID int, username, email string) (string, error) {
+var keys *keySet
+
+// Init loads the signing keys and starts the reload/rotation loop. With
+// HS256 the shared JWT secret is used instead and no key set is kept.
+func Init(cfg *config.AppConfig) error {
+	if cfg.JWT.Algorithm == "HS256" {
+		return nil
+	}
+
+	ks := newKeySet(cfg)
+	if err := ks.load(); err != nil {
+		return err
+	}
+
+	if ks.active == nil && cfg.Server.Production {
+		return fmt.Errorf("no %s JWT signing keys found in %s", cfg.JWT.Algorithm, cfg.JWT.KeysDir)
+	}
+	if err := ks.rotateIfDue(); err != nil {
+		return err
+	}
+
+	keys = ks
+	go ks.run(cfg.JWT.KeyReload)
+	return nil
+}
+
+// Check loads the signing keys the way Init does, but never writes, rotates
+// or keeps them, so validating a configuration leaves the key directory as
+// it is. A directory without keys is fine outside production, where the
+// server creates the first key when it starts.
+func Check(cfg *config.AppConfig) error {
+	if cfg.JWT.Algorithm == "HS256" {
+		return nil
+	}
+
+	ks := newKeySet(cfg)
+	if err := ks.load(); err != nil {
+		return err
+	}
+	if ks.active == nil && cfg.Server.Production {
+		return fmt.Errorf("no %s JWT signing keys found in %s", cfg.JWT.Algorithm, cfg.JWT.KeysDir)
+	}
+	return nil
+}
+
+// GenerateToken creates a JWT token for the given user, bound to one session
+func GenerateToken(user *database.User, sessionID string) (string, error) {
 	cfg := config.Get()
 
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Email:    email,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Guest:     user.IsGuest,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(cfg.AccessTokenTTL) * time.Second)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWT.AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "projectwebcurhat",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.JWTSecret))
+	if cfg.JWT.Algorithm == "HS256" {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(cfg.JWT.Secret))
+	}
+
+	if keys == nil {
+		return "", errors.New("token keys are not initialized")
+	}
+	key, err := keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
 }
 
 // ValidateToken validates a JWT token and returns the claims
@@ -40,11 +106,26 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	cfg := config.Get()
 
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if cfg.JWT.Algorithm == "HS256" {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(cfg.JWT.Secret), nil
+		}
+
+		if keys == nil {
+			return nil, errors.New("token keys are not initialized")
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.verificationKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(cfg.JWTSecret), nil
-	})
+		return key.private.Public(), nil
+	}, jwt.WithIssuer("projectwebcurhat"))
 
 	if err != nil {
 		return nil, err
@@ -57,3 +138,11 @@ func ValidateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// JWKS returns the public keys that can verify tokens issued by this server
+func JWKS() jose.JSONWebKeySet {
+	if keys == nil {
+		return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	}
+	return keys.publicKeys()
+}
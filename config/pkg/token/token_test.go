@@ -0,0 +1,158 @@
+package token_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/pkg/token"
+	"projectwebcurhat/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func keysConfig(dir string, production bool) *config.AppConfig {
+	return &config.AppConfig{
+		Server: config.ServerConfig{Production: production},
+		JWT: config.JWTConfig{
+			Algorithm:      "RS256",
+			KeysDir:        dir,
+			KeyRotation:    time.Minute,
+			KeyReload:      time.Minute,
+			AccessTokenTTL: time.Hour,
+		},
+	}
+}
+
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+// writeKey stores private as dir/name, written at modified
+func writeKey(t *testing.T, dir, name string, private any, modified time.Time) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCheckLeavesTheKeysAlone checks a key that Init would rotate, since it
+// is older than key_rotation, and an empty directory Init would write to
+func TestCheckLeavesTheKeysAlone(t *testing.T) {
+	dir := t.TempDir()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "key-1.pem", private, time.Now().Add(-time.Hour))
+
+	if err := token.Check(keysConfig(dir, true)); err != nil {
+		t.Fatalf("checking a valid key: %v", err)
+	}
+	if names := dirEntries(t, dir); !slices.Equal(names, []string{"key-1.pem"}) {
+		t.Fatalf("key directory holds %v after the check, want only key-1.pem", names)
+	}
+
+	empty := t.TempDir()
+	if err := token.Check(keysConfig(empty, false)); err != nil {
+		t.Fatalf("checking an empty directory in development: %v", err)
+	}
+	if names := dirEntries(t, empty); len(names) != 0 {
+		t.Fatalf("the check wrote %v", names)
+	}
+	if err := token.Check(keysConfig(empty, true)); err == nil {
+		t.Fatal("an empty key directory passed in production")
+	}
+}
+
+func TestCheckRejectsUnreadableKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := token.Check(keysConfig(dir, false)); err == nil {
+		t.Fatal("a broken key file passed the check")
+	}
+}
+
+// A key of another algorithm never signs, even when it is the newest one
+func TestKeysOfAnotherAlgorithmDoNotSign(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKey(t, dir, "key-ed.pem", edKey, time.Now())
+
+	if err := token.Check(keysConfig(dir, true)); err == nil {
+		t.Fatal("an EdDSA key passed as the signing key for RS256")
+	}
+
+	cfg := keysConfig(dir, true)
+	cfg.JWT.Algorithm = "EdDSA"
+	if err := token.Check(cfg); err != nil {
+		t.Fatalf("checking an EdDSA key for EdDSA: %v", err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "key-rsa.pem", rsaKey, time.Now().Add(-time.Minute))
+	if err := token.Check(keysConfig(dir, true)); err != nil {
+		t.Fatalf("checking an RSA key next to a newer EdDSA key: %v", err)
+	}
+
+	if err := config.Load(config.Sources{Flags: map[string]string{
+		"jwt.algorithm":    "RS256",
+		"jwt.keys_dir":     dir,
+		"jwt.key_rotation": "24h",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := token.Init(config.Get()); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := token.GenerateToken(&database.User{ID: 1, Username: "alice"}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(signed, &token.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method.Alg() != "RS256" || parsed.Header["kid"] != "key-rsa" {
+		t.Fatalf("token signed with %s by %v, want RS256 by key-rsa", parsed.Method.Alg(), parsed.Header["kid"])
+	}
+	if _, err := token.ValidateToken(signed); err != nil {
+		t.Fatalf("validating the token: %v", err)
+	}
+}
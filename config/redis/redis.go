@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"projectwebcurhat/config"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func ConnectRedis() (*goredis.Client, error) {
+	cfg := config.Get()
+
+	slog.Info("Connecting to redis")
+
+	options, err := goredis.ParseURL(cfg.Redis.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := goredis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	slog.Info("Redis connected successfully")
+	return client, nil
+}
@@ -1,59 +1,192 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"projectwebcurhat/bus"
 	"projectwebcurhat/config"
 	dbConfig "projectwebcurhat/config/database"
+	"projectwebcurhat/config/logger"
 	"projectwebcurhat/config/middleware"
+	"projectwebcurhat/config/pkg/token"
+	redisConfig "projectwebcurhat/config/redis"
+	"projectwebcurhat/contract"
 	"projectwebcurhat/controller"
 	dbMigration "projectwebcurhat/database"
+	"projectwebcurhat/metrics"
 	"projectwebcurhat/repository"
 	"projectwebcurhat/service"
+	"projectwebcurhat/tracing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
 func Run() {
-	log.Println("Starting application...")
-
 	cfg := config.Get()
 	if cfg == nil {
-		log.Fatal("Failed to load configuration")
+		logger.Fatal("Failed to load configuration")
+		return
+	}
+
+	logger.Init(cfg)
+	slog.Info("Starting application", "node_id", cfg.Server.NodeID)
+
+	// Load JWT signing keys
+	if err := token.Init(cfg); err != nil {
+		logger.Fatal("Failed to load JWT signing keys", "error", err)
+		return
+	}
+
+	// Tracing has to be set up before anything creates spans
+	shutdownTracing, err := tracing.Init(cfg)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", "error", err)
 		return
 	}
 
 	// Connect to database
-	db, _, err := dbConfig.ConnectDB()
+	db, sqlDB, err := dbConfig.ConnectDB()
+	if err != nil {
+		logger.Fatal("Failed to connect to the database", "error", err)
+		return
+	}
+	if err := tracing.InstrumentDB(db); err != nil {
+		slog.Error("Error instrumenting database for tracing", "error", err)
+	}
+
+	// Run migrations; replicas starting together wait on the migration lock
+	if cfg.Database.MigrateOnStart {
+		if err := dbMigration.RunMigration(db); err != nil {
+			logger.Fatal("Failed to run migrations", "error", err)
+			return
+		}
+	}
+
+	// Connect to redis when rooms or messages are shared between replicas
+	var rdb *redis.Client
+	if cfg.Matching.RoomStore == "redis" || cfg.Cluster.Bus == "redis" {
+		rdb, err = redisConfig.ConnectRedis()
+		if err != nil {
+			logger.Fatal("Failed to connect to redis", "error", err)
+			return
+		}
+	}
+
+	// Message bus for relaying signaling between replicas
+	messageBus, err := bus.New(cfg, rdb)
 	if err != nil {
-		log.Fatal("Failed to connect to the database:", err)
+		logger.Fatal("Failed to create message bus", "error", err)
 		return
 	}
 
-	// Run migrations
-	if err := dbMigration.RunMigration(db); err != nil {
-		log.Fatal("Failed to run migrations:", err)
+	// Rooms are only shared when asked for; the bus alone may need redis
+	repo, err := repository.New(db, rdb, cfg.Matching.RoomStore)
+	if err != nil {
+		logger.Fatal("Failed to create repositories", "error", err)
 		return
 	}
 
-	startServer(cfg, db)
+	startServer(cfg, db, repo, messageBus)
+
+	if rdb != nil {
+		rdb.Close()
+	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	slog.Info("Server stopped")
 }
 
-func startServer(cfg *config.AppConfig, db *gorm.DB) {
-	repo := repository.New(db)
-	serv := service.New(repo)
+func startServer(cfg *config.AppConfig, db *gorm.DB, repo *contract.Repository, messageBus contract.MessageBus) {
+	var observer contract.Metrics = metrics.Noop{}
+	var prom *metrics.Prometheus
+	if cfg.Metrics.Enabled {
+		prom = metrics.NewPrometheus()
+		if err := prom.InstrumentDB(db); err != nil {
+			slog.Error("Error instrumenting database for metrics", "error", err)
+		}
+		observer = prom
+	}
+
+	serv := service.New(repo, messageBus, observer)
+	r := NewRouter(cfg, serv, prom)
 
-	if cfg.IsProduction {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:      r,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		tls := cfg.Server.TLS
+		scheme := "ws"
+		if tls.Enabled() {
+			scheme = "wss"
+		}
+		slog.Info("Server is running", "port", cfg.Server.Port, "websocket", fmt.Sprintf("%s://localhost:%d/ws", scheme, cfg.Server.Port))
+
+		var err error
+		if tls.Enabled() {
+			err = srv.ListenAndServeTLS(tls.CertFile, tls.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("HTTP server failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	shutdown(cfg, srv, serv)
+
+	if err := messageBus.Close(); err != nil {
+		slog.Error("Error closing message bus", "error", err)
+	}
+}
+
+// NewRouter builds the HTTP handler with its middleware and every controller.
+// prom is nil when metrics are disabled. testutil serves the same router, so
+// integration tests see what production does.
+func NewRouter(cfg *config.AppConfig, serv *contract.Service, prom *metrics.Prometheus) *gin.Engine {
+	if cfg.Server.Production {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	r := gin.New()
+	// ClientIP, and so the rate limits, only believes X-Forwarded-For from
+	// these; an empty list makes it the connecting address
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatal("Invalid trusted proxies", "error", err)
+	}
+	r.Use(tracing.Middleware())
+	r.Use(middleware.RequestID())
+	r.Use(middleware.RequestLogger())
+	if prom != nil {
+		r.Use(prom.Middleware())
+	}
 	r.Use(middleware.CORSMiddleware())
-	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
 	r.GET("/", func(c *gin.Context) {
@@ -62,17 +195,37 @@ func startServer(cfg *config.AppConfig, db *gorm.DB) {
 
 	r.StaticFile("/test-client", "./test-client.html")
 
+	if prom != nil {
+		prom.ObserveService(serv)
+		r.GET("/metrics", middleware.StaticToken(cfg.Metrics.Token), prom.Handler())
+	}
+
 	controller.New(r, serv)
+	return r
+}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      r,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+// shutdown stops taking new calls, gives ongoing calls the drain period to
+// end on their own, then closes the remaining sockets and the HTTP server
+func shutdown(cfg *config.AppConfig, srv *http.Server, serv *contract.Service) {
+	slog.Info("Shutdown signal received, draining", "drain_timeout", cfg.Server.ShutdownDrain)
+	ctx := context.Background()
+
+	serv.Lifecycle.StartDraining()
+	serv.Signaling.NotifyShutdown(ctx, cfg.Server.ShutdownDrain)
+
+	deadline := time.Now().Add(cfg.Server.ShutdownDrain)
+	for serv.Signaling.LocalClientCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
 	}
 
-	log.Printf("Server is running on port %d", cfg.Port)
-	log.Printf("WebSocket endpoint: ws://localhost:%d/ws", cfg.Port)
-	log.Fatal(srv.ListenAndServe())
+	if remaining := serv.Signaling.LocalClientCount(); remaining > 0 {
+		slog.Warn("Drain period over, closing remaining connections", "connections", remaining)
+	}
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.Server.ShutdownTimeout)
+	defer cancel()
+	serv.Signaling.CloseAllClients(shutdownCtx)
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down HTTP server", "error", err)
+	}
 }
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+
+	"projectwebcurhat/dto"
+)
+
+// validate checks every setting and returns one message per problem
+func validate(c *AppConfig) []string {
+	var problems []string
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		check(slices.Contains(allowed, value), key, "must be one of %v, got %q", allowed, value)
+	}
+
+	// Server
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	check((c.Server.TLS.CertFile == "") == (c.Server.TLS.KeyFile == ""), "server.tls", "cert_file and key_file must be set together")
+	if c.Server.TLS.Enabled() {
+		_, certErr := os.Stat(c.Server.TLS.CertFile)
+		check(certErr == nil, "server.tls.cert_file", "cannot read %s", c.Server.TLS.CertFile)
+		_, keyErr := os.Stat(c.Server.TLS.KeyFile)
+		check(keyErr == nil, "server.tls.key_file", "cannot read %s", c.Server.TLS.KeyFile)
+	}
+	check(c.Server.ShutdownDrain >= 0, "server.shutdown_drain", "must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "server.trusted_proxies", "%q is neither an IP nor a CIDR", proxy)
+	}
+
+	oneOf("log.format", c.Log.Format, "json", "text")
+
+	// Database
+	if path, _, ok := c.Database.sqlite(); ok {
+		check(path != "", "database.url", "must name a SQLite file or :memory:")
+		check(len(c.Database.ReplicaURLs) == 0, "database.replica_urls", "are only supported with Postgres")
+		check(c.Database.StatementTimeout == 0, "database.statement_timeout", "is only supported with Postgres")
+	} else if c.Database.URL != "" {
+		u, err := url.Parse(c.Database.URL)
+		check(err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql"), "database.url", "must be a postgres://, postgresql://, sqlite: or file: URL")
+	} else {
+		check(c.Database.Host != "", "database.host", "is required when database.url is not set")
+		check(c.Database.Name != "", "database.name", "is required when database.url is not set")
+		check(c.Database.User != "", "database.user", "is required when database.url is not set")
+		check(c.Database.Port > 0 && c.Database.Port <= 65535, "database.port", "must be between 1 and 65535, got %d", c.Database.Port)
+		oneOf("database.sslmode", c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	}
+	for i, replica := range c.Database.ReplicaURLs {
+		u, err := url.Parse(replica)
+		check(err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql"), fmt.Sprintf("database.replica_urls[%d]", i), "must be a postgres:// or postgresql:// URL")
+	}
+	check(c.Database.ConnectTimeout >= 0, "database.connect_timeout", "must not be negative")
+	check(c.Database.QueryTimeout > 0, "database.query_timeout", "must be positive")
+	check(c.Database.StatementTimeout >= 0, "database.statement_timeout", "must not be negative")
+
+	pool := c.Database.Pool
+	check(pool.MaxOpenConns >= 0, "database.pool.max_open_conns", "must not be negative")
+	check(pool.MaxIdleConns >= 0, "database.pool.max_idle_conns", "must not be negative")
+	check(pool.MaxOpenConns == 0 || pool.MaxIdleConns <= pool.MaxOpenConns, "database.pool.max_idle_conns", "must not exceed max_open_conns")
+	check(pool.ConnMaxLifetime >= 0, "database.pool.conn_max_lifetime", "must not be negative")
+	check(pool.ConnMaxIdleTime >= 0, "database.pool.conn_max_idle_time", "must not be negative")
+
+	check(c.CORS.MaxAge >= 0, "cors.max_age", "must not be negative")
+
+	// WebSocket
+	ws := c.WebSocket
+	check(ws.ReadBufferSize > 0, "websocket.read_buffer_size", "must be positive")
+	check(ws.WriteBufferSize > 0, "websocket.write_buffer_size", "must be positive")
+	check(ws.MaxMessageSize > 0, "websocket.max_message_size", "must be positive")
+	check(ws.MaxSDPSize > 0 && int64(ws.MaxSDPSize) < ws.MaxMessageSize, "websocket.max_sdp_size", "must be positive and smaller than max_message_size")
+	check(ws.MaxCandidateSize > 0 && int64(ws.MaxCandidateSize) < ws.MaxMessageSize, "websocket.max_candidate_size", "must be positive and smaller than max_message_size")
+	check(ws.MinProtocolVersion >= dto.ProtocolVersion1 && ws.MinProtocolVersion <= dto.LatestProtocolVersion, "websocket.min_protocol_version", "must be between %d and %d", dto.ProtocolVersion1, dto.LatestProtocolVersion)
+	check(ws.SendBuffer > 0, "websocket.send_buffer", "must be positive")
+	check(ws.PingInterval > 0, "websocket.ping_interval", "must be positive")
+	check(ws.PongWait > ws.PingInterval, "websocket.pong_wait", "must be longer than ping_interval")
+	check(ws.WriteWait > 0, "websocket.write_wait", "must be positive")
+
+	// Matching and cluster
+	check(c.Matching.MaxRoomSize == 2, "matching.max_room_size", "only one to one calls (2) are supported, got %d", c.Matching.MaxRoomSize)
+	oneOf("matching.room_store", c.Matching.RoomStore, "memory", "redis")
+	oneOf("cluster.bus", c.Cluster.Bus, "memory", "redis", "nats")
+	// Shared rooms pair clients across replicas, and a memory bus would drop
+	// every message to a peer on another one
+	check(c.Matching.RoomStore != "redis" || c.Cluster.Bus != "memory", "cluster.bus", "must be redis or nats with matching.room_store=redis")
+	check(c.Cluster.NodeHeartbeat > 0, "cluster.node_heartbeat", "must be positive")
+	check(c.Cluster.NodeTimeout > c.Cluster.NodeHeartbeat, "cluster.node_timeout", "must be longer than node_heartbeat")
+	if c.Matching.RoomStore == "redis" || c.Cluster.Bus == "redis" {
+		u, err := url.Parse(c.Redis.URL)
+		check(err == nil && (u.Scheme == "redis" || u.Scheme == "rediss"), "redis.url", "must be a redis:// or rediss:// URL")
+	}
+	if c.Cluster.Bus == "nats" {
+		check(c.NATS.URL != "", "nats.url", "is required with cluster.bus=nats")
+	}
+
+	// Metrics name users' rooms and the server's internals
+	if c.Server.Production && c.Metrics.Enabled {
+		check(c.Metrics.Token != "", "metrics.token", "is required in production while metrics are enabled")
+	}
+
+	// JWT
+	oneOf("jwt.algorithm", c.JWT.Algorithm, "RS256", "EdDSA", "HS256")
+	if c.Server.Production {
+		check(c.JWT.Secret != InsecureJWTSecret, "jwt.secret", "is set to the insecure default, refusing to start in production")
+		if c.JWT.Algorithm == "HS256" {
+			check(c.JWT.Secret != "", "jwt.secret", "is required for HS256 in production")
+		} else {
+			check(c.JWT.KeysDir != "", "jwt.keys_dir", "is required for %s in production", c.JWT.Algorithm)
+		}
+	}
+	check(c.JWT.KeyRotation >= 0, "jwt.key_rotation", "must not be negative")
+	check(c.JWT.KeyReload > 0, "jwt.key_reload", "must be positive")
+	check(c.JWT.AccessTokenTTL > 0, "jwt.access_token_ttl", "must be positive")
+
+	check(c.Guest.RateLimit > 0, "guest.rate_limit", "must be positive")
+	check(c.Guest.PoWBits >= 0 && c.Guest.PoWBits <= 32, "guest.pow_bits", "must be between 0 and 32, got %d", c.Guest.PoWBits)
+	// Deleting a guest ends its session, so it must stay idle for longer than a token lasts
+	check(c.Guest.IdleTTL == 0 || c.Guest.IdleTTL >= c.JWT.AccessTokenTTL, "guest.idle_ttl", "must be 0 or at least jwt.access_token_ttl")
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "otlp")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+
+	check(c.Health.CheckTimeout > 0, "health.check_timeout", "must be positive")
+
+	return problems
+}
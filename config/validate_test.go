@@ -0,0 +1,25 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"projectwebcurhat/config"
+)
+
+func TestSharedRoomsNeedAClusterBus(t *testing.T) {
+	for bus, valid := range map[string]bool{"memory": false, "redis": true, "nats": true} {
+		err := config.Load(config.Sources{Flags: map[string]string{
+			"matching.room_store": "redis",
+			"cluster.bus":         bus,
+			"nats.url":            "nats://localhost:4222",
+		}})
+
+		var problems *config.Error
+		rejected := errors.As(err, &problems) && strings.Contains(strings.Join(problems.Problems, "\n"), "cluster.bus")
+		if rejected == valid {
+			t.Errorf("room_store=redis with bus=%s: got %v", bus, err)
+		}
+	}
+}
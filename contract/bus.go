@@ -0,0 +1,18 @@
+package contract
+
+import "context"
+
+// MessageBus carries messages between replicas. Subjects are plain strings
+// such as "webcurhat.client.<id>"; every implementation delivers a message
+// to all current subscribers of its subject.
+type MessageBus interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, handler func(data []byte)) (Subscription, error)
+	// Ping reports whether the bus can currently deliver messages
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+type Subscription interface {
+	Unsubscribe() error
+}
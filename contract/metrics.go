@@ -0,0 +1,19 @@
+package contract
+
+import "time"
+
+// Metrics receives instrumentation events from controllers and services, so
+// they never depend on a metrics library. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// ClientMatched records how long a client waited for a partner
+	ClientMatched(wait time.Duration)
+	CallEnded(duration time.Duration)
+	MessageRelayed(msgType string)
+	// MessageDropped counts outbound messages that never reached a client
+	MessageDropped(reason string)
+	// MessageRejected counts inbound messages refused with an error, by code
+	MessageRejected(code string)
+	UpgradeFailed(reason string)
+	AuthAttempt(method string, success bool)
+}
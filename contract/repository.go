@@ -1,27 +1,108 @@
 package contract
 
-import "projectwebcurhat/database"
+import (
+	"context"
+	"time"
+
+	"projectwebcurhat/database"
+)
 
 type Repository struct {
-	Room RoomRepository
-	User UserRepository
+	Room       RoomRepository
+	User       UserRepository
+	OAuthState OAuthStateRepository
+	Guest      GuestChallengeRepository
+	Moderation ModerationRepository
+	Session    SessionRepository
+	Settings   SettingsRepository
+	Health     HealthRepository
 }
 
 type RoomRepository interface {
-	CreateRoom(id string) *database.Room
-	GetRoom(roomID string) *database.Room
-	DeleteRoom(roomID string)
-	GetRoomCount() int
-	GetWaitingRoom() *database.Room
-	SetWaitingRoom(room *database.Room)
-	StoreRoom(room *database.Room)
+	// MatchClient atomically adds the client to the waiting room, or to a
+	// new room that becomes the waiting room when nobody is waiting. It
+	// fails when the store cannot be reached.
+	MatchClient(ctx context.Context, client *database.Client) (*database.Room, error)
+	GetRoom(ctx context.Context, roomID string) *database.Room
+	// ListRooms returns a snapshot of every room on all replicas
+	ListRooms(ctx context.Context) []*database.Room
+	SetRoomState(ctx context.Context, roomID, state string)
+	// UpdateNegotiation applies update to the room's negotiation state
+	// atomically across replicas. update may run more than once, and nothing
+	// is saved when it fails. A room that is gone is left alone.
+	UpdateNegotiation(ctx context.Context, roomID string, update func(negotiation *database.Negotiation) error) error
+	// RemoveClient takes the client out of the room and deletes the room
+	// once it is empty. It returns the number of clients left.
+	RemoveClient(ctx context.Context, roomID, clientID string) int
+	DeleteRoom(ctx context.Context, roomID string)
+	GetRoomCount(ctx context.Context) int
+	// GetWaitingCount returns the number of rooms waiting for a partner
+	GetWaitingCount(ctx context.Context) int
+	// CountRoomsByState counts the rooms of all replicas by GetState
+	CountRoomsByState(ctx context.Context) map[string]int
+	// RemoveNodeClients drops every client owned by a replica that died
+	RemoveNodeClients(ctx context.Context, nodeID string) int
 }
 
 type UserRepository interface {
-	CreateUser(user *database.User) (*database.User, error)
-	GetUserByEmail(email string) (*database.User, error)
-	GetUserByID(id int) (*database.User, error)
-	GetUserByUsername(username string) (*database.User, error)
-	UpdateUser(user *database.User) (*database.User, error)
-	SetOnlineStatus(userID int, online bool) error
+	CreateUser(ctx context.Context, user *database.User) (*database.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*database.User, error)
+	GetUserByID(ctx context.Context, id int) (*database.User, error)
+	// GetUserProfile is GetUserByID for display only; it may read a replica
+	GetUserProfile(ctx context.Context, id int) (*database.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*database.User, error)
+	UpdateUser(ctx context.Context, user *database.User) (*database.User, error)
+	SetOnlineStatus(ctx context.Context, userID int, online bool) error
+	// DeleteIdleGuests deletes guests created before idleSince that have no
+	// active session seen since then, with their sessions and identities
+	DeleteIdleGuests(ctx context.Context, idleSince time.Time) (int64, error)
+	GetIdentity(ctx context.Context, provider, subject string) (*database.UserIdentity, error)
+	CreateIdentity(ctx context.Context, identity *database.UserIdentity) (*database.UserIdentity, error)
+}
+
+type OAuthStateRepository interface {
+	SaveState(ctx context.Context, state *database.OAuthState) error
+	// TakeState removes the state and returns it, or nil when it is unknown
+	// or expired. Each state is returned once, whichever replica asks.
+	TakeState(ctx context.Context, state string) (*database.OAuthState, error)
+}
+
+type GuestChallengeRepository interface {
+	SaveChallenge(ctx context.Context, challenge *database.GuestChallenge) error
+	// TakeChallenge removes the challenge and returns it, or nil when it is
+	// unknown or expired. Each challenge is returned once, whichever replica asks.
+	TakeChallenge(ctx context.Context, challenge string) (*database.GuestChallenge, error)
+}
+
+// ModerationRepository keeps the blocks and reports between users
+type ModerationRepository interface {
+	// BlockUser records that blocker blocked blocked; blocking again is no error
+	BlockUser(ctx context.Context, blockerID, blockedID int) error
+	// BlockedUserIDs returns the users that userID blocked or was blocked by
+	BlockedUserIDs(ctx context.Context, userID int) ([]int, error)
+	CreateReport(ctx context.Context, report *database.Report) error
+	// ListReports returns up to limit reports, newest first
+	ListReports(ctx context.Context, limit int) ([]database.Report, error)
+}
+
+// HealthRepository checks the stores the repositories depend on
+type HealthRepository interface {
+	PingDatabase(ctx context.Context) error
+	PingRedis(ctx context.Context) error
+}
+
+type SettingsRepository interface {
+	ListSettings(ctx context.Context) ([]database.Setting, error)
+	// SaveSettings inserts or replaces the given settings in one transaction
+	SaveSettings(ctx context.Context, settings []database.Setting) error
+}
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *database.Session) (*database.Session, error)
+	GetSession(ctx context.Context, id string) (*database.Session, error)
+	ListActiveSessions(ctx context.Context, userID int) ([]database.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	// RevokeUserSessions logs the user out everywhere
+	RevokeUserSessions(ctx context.Context, userID int) error
+	TouchSession(ctx context.Context, id string, lastSeen time.Time) error
 }
@@ -1,6 +1,9 @@
 package contract
 
 import (
+	"context"
+	"time"
+
 	"projectwebcurhat/database"
 	"projectwebcurhat/dto"
 )
@@ -9,22 +12,98 @@ type Service struct {
 	Room      RoomService
 	Signaling SignalingService
 	Auth      AuthService
+	OAuth     OAuthService
+	Session   SessionService
+	Lifecycle LifecycleService
+	Health    HealthService
+	Admin     AdminService
+	Settings  SettingsService
+	Metrics   Metrics
 }
 
 type RoomService interface {
-	FindOrCreateRoom(client *database.Client) *database.Room
-	GetRoom(roomID string) *database.Room
-	RemoveClientFromRoom(client *database.Client)
-	GetRoomCount() int
+	FindOrCreateRoom(ctx context.Context, client *database.Client) (*database.Room, error)
+	GetRoom(ctx context.Context, roomID string) *database.Room
+	ListRooms(ctx context.Context) []*database.Room
+	SetRoomState(ctx context.Context, roomID, state string)
+	UpdateNegotiation(ctx context.Context, roomID string, update func(negotiation *database.Negotiation) error) error
+	DeleteRoom(ctx context.Context, roomID string)
+	RemoveClientFromRoom(ctx context.Context, client *database.Client)
+	RemoveNodeClients(ctx context.Context, nodeID string)
+	// Report saves a report about a peer, and blocks the peer if it says so
+	Report(ctx context.Context, report *database.Report) error
+	GetRoomCount(ctx context.Context) int
+	GetWaitingCount(ctx context.Context) int
+	CountRoomsByState(ctx context.Context) map[string]int
 }
 
+// SignalingService methods that act for one client take that connection's
+// context, which is cancelled once the socket is gone
 type SignalingService interface {
-	HandleMessage(client *database.Client, data []byte) error
-	DisconnectClient(client *database.Client)
+	ConnectClient(ctx context.Context, client *database.Client) error
+	HandleMessage(ctx context.Context, client *database.Client, data []byte) error
+	// DisconnectClient cleans up after the connection, so ctx must outlive it
+	DisconnectClient(ctx context.Context, client *database.Client)
+	// NotifyShutdown stops new matches and warns every local client
+	NotifyShutdown(ctx context.Context, closeIn time.Duration)
+	LocalClientCount() int
+	CloseAllClients(ctx context.Context)
+	// KickClient sends the client a notice of the given type and closes its
+	// connection, on whichever replica it is connected to
+	KickClient(ctx context.Context, clientID, noticeType, reason string) error
+}
+
+type LifecycleService interface {
+	StartDraining()
+	IsDraining() bool
+}
+
+type HealthService interface {
+	// Ready checks every configured dependency; a draining replica is never ready
+	Ready(ctx context.Context) *dto.HealthReport
+	Details(ctx context.Context) *dto.HealthDetails
+}
+
+type AdminService interface {
+	ListRooms(ctx context.Context) []dto.AdminRoom
+	GetRoom(ctx context.Context, roomID string) (*dto.AdminRoom, error)
+	CloseRoom(ctx context.Context, roomID, reason string) error
+	KickClient(ctx context.Context, clientID, reason string) error
+	// User management; login is an email address or a username
+	CreateUser(ctx context.Context, payload *dto.RegisterRequest, role string) (*dto.UserProfile, error)
+	SetUserRole(ctx context.Context, login, role string) (*dto.UserProfile, error)
+	// SetUserBanned bans or unbans a user; a ban also ends all their sessions
+	SetUserBanned(ctx context.Context, login string, banned bool) (*dto.UserProfile, error)
+	// ListReports returns the newest reports about peers
+	ListReports(ctx context.Context) ([]dto.AdminReport, error)
+	// IssueToken starts a session for the user without a password, for debugging
+	IssueToken(ctx context.Context, login string, meta *dto.SessionMeta) (*dto.AuthResponse, error)
+}
+
+// SettingsService holds the runtime settings shared by all replicas
+type SettingsService interface {
+	// Get returns the current settings; slices in it must not be modified
+	Get() dto.Settings
+	Reload(ctx context.Context) error
+	Update(ctx context.Context, userID int, payload *dto.SettingsUpdateRequest) (*dto.Settings, error)
 }
 
 type AuthService interface {
-	Register(payload *dto.RegisterRequest) (*dto.AuthResponse, error)
-	Login(payload *dto.LoginRequest) (*dto.AuthResponse, error)
-	GetProfile(userID int) (*dto.UserProfile, error)
+	Register(ctx context.Context, payload *dto.RegisterRequest, meta *dto.SessionMeta) (*dto.AuthResponse, error)
+	Login(ctx context.Context, payload *dto.LoginRequest, meta *dto.SessionMeta) (*dto.AuthResponse, error)
+	GetProfile(ctx context.Context, userID int) (*dto.UserProfile, error)
+	GuestChallenge(ctx context.Context) (*dto.GuestChallengeResponse, error)
+	Guest(ctx context.Context, payload *dto.GuestRequest, meta *dto.SessionMeta) (*dto.AuthResponse, error)
+	UpgradeGuest(ctx context.Context, userID int, sessionID string, payload *dto.RegisterRequest) (*dto.AuthResponse, error)
+}
+
+type OAuthService interface {
+	StartLogin(ctx context.Context, provider string) (string, error)
+	HandleCallback(ctx context.Context, provider string, payload *dto.OAuthCallbackRequest, meta *dto.SessionMeta) (*dto.AuthResponse, error)
+}
+
+type SessionService interface {
+	ValidateSession(ctx context.Context, userID int, sessionID string) error
+	ListSessions(ctx context.Context, userID int, currentSessionID string) ([]dto.SessionResponse, error)
+	RevokeSession(ctx context.Context, userID int, sessionID string) error
 }
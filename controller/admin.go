@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"projectwebcurhat/config/middleware"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminController struct {
+	service *contract.Service
+}
+
+func (a *AdminController) GetPrefix() string {
+	return "/admin"
+}
+
+func (a *AdminController) InitService(service *contract.Service) {
+	a.service = service
+}
+
+func (a *AdminController) InitRoute(app *gin.RouterGroup) {
+	app.Use(
+		middleware.AuthMiddleware(a.service.Session),
+		middleware.RequireRole(a.service.Auth, database.RoleAdmin),
+	)
+	app.GET("/rooms", a.ListRooms)
+	app.GET("/rooms/:id", a.GetRoom)
+	app.POST("/rooms/:id/close", a.CloseRoom)
+	app.POST("/clients/:id/kick", a.KickClient)
+	app.GET("/reports", a.ListReports)
+	app.GET("/settings", a.GetSettings)
+	app.PUT("/settings", a.UpdateSettings)
+}
+
+// ListRooms godoc
+// @Summary List live rooms on all replicas with their state and participants
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.AdminRoom
+// @Router /admin/rooms [get]
+func (a *AdminController) ListRooms(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Rooms retrieved",
+		"data":    a.service.Admin.ListRooms(ctx.Request.Context()),
+	})
+}
+
+// GetRoom godoc
+// @Summary Get one live room
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Room ID"
+// @Success 200 {object} dto.AdminRoom
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/rooms/{id} [get]
+func (a *AdminController) GetRoom(ctx *gin.Context) {
+	room, err := a.service.Admin.GetRoom(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Room retrieved",
+		"data":    room,
+	})
+}
+
+// CloseRoom godoc
+// @Summary Close a room, telling both participants why and disconnecting them
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Room ID"
+// @Param request body dto.AdminActionRequest false "Reason shown to the participants"
+// @Success 200
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/rooms/{id}/close [post]
+func (a *AdminController) CloseRoom(ctx *gin.Context) {
+	var payload dto.AdminActionRequest
+	if err := ctx.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.service.Admin.CloseRoom(ctx.Request.Context(), ctx.Param("id"), payload.Reason); err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Room closed",
+	})
+}
+
+// ListReports godoc
+// @Summary List the newest reports users made about their peers
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.AdminReport
+// @Router /admin/reports [get]
+func (a *AdminController) ListReports(ctx *gin.Context) {
+	reports, err := a.service.Admin.ListReports(ctx.Request.Context())
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Reports retrieved",
+		"data":    reports,
+	})
+}
+
+// KickClient godoc
+// @Summary Disconnect one client, telling it why
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param request body dto.AdminActionRequest false "Reason shown to the client"
+// @Success 200
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/clients/{id}/kick [post]
+func (a *AdminController) KickClient(ctx *gin.Context) {
+	var payload dto.AdminActionRequest
+	if err := ctx.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.service.Admin.KickClient(ctx.Request.Context(), ctx.Param("id"), payload.Reason); err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Client kicked",
+	})
+}
+
+// GetSettings godoc
+// @Summary Get the runtime settings shared by all replicas
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.Settings
+// @Router /admin/settings [get]
+func (a *AdminController) GetSettings(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Settings retrieved",
+		"data":    a.service.Settings.Get(),
+	})
+}
+
+// UpdateSettings godoc
+// @Summary Change runtime settings on every replica without a restart; omitted fields keep their value
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.SettingsUpdateRequest true "Settings to change"
+// @Success 200 {object} dto.Settings
+// @Failure 400 {object} map[string]interface{}
+// @Router /admin/settings [put]
+func (a *AdminController) UpdateSettings(ctx *gin.Context) {
+	var payload dto.SettingsUpdateRequest
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := a.service.Settings.Update(ctx.Request.Context(), ctx.GetInt("userID"), &payload)
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Settings updated",
+		"data":    settings,
+	})
+}
@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
 	"projectwebcurhat/config/middleware"
 	"projectwebcurhat/contract"
@@ -25,7 +28,18 @@ func (a *AuthController) InitService(service *contract.Service) {
 func (a *AuthController) InitRoute(app *gin.RouterGroup) {
 	app.POST("/register", a.Register)
 	app.POST("/login", a.Login)
-	app.GET("/profile", middleware.AuthMiddleware(), a.GetProfile)
+	app.GET("/profile", middleware.AuthMiddleware(a.service.Session), a.GetProfile)
+	app.GET("/oauth/:provider/start", a.OAuthStart)
+	app.GET("/oauth/:provider/callback", a.OAuthCallback)
+	app.GET("/guest/challenge", a.GuestChallenge)
+	app.POST("/guest", middleware.RateLimit(a.guestRateLimit, time.Hour), a.Guest)
+	app.POST("/guest/upgrade", middleware.AuthMiddleware(a.service.Session), a.UpgradeGuest)
+	app.GET("/sessions", middleware.AuthMiddleware(a.service.Session), a.ListSessions)
+	app.DELETE("/sessions/:id", middleware.AuthMiddleware(a.service.Session), a.RevokeSession)
+}
+
+func (a *AuthController) guestRateLimit() int {
+	return a.service.Settings.Get().GuestRateLimit
 }
 
 // Register godoc
@@ -43,7 +57,8 @@ func (a *AuthController) Register(ctx *gin.Context) {
 		return
 	}
 
-	result, err := a.service.Auth.Register(&payload)
+	result, err := a.service.Auth.Register(ctx.Request.Context(), &payload, sessionMeta(ctx))
+	a.service.Metrics.AuthAttempt("register", err == nil)
 	if err != nil {
 		HandlerError(ctx, err)
 		return
@@ -71,7 +86,8 @@ func (a *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	result, err := a.service.Auth.Login(&payload)
+	result, err := a.service.Auth.Login(ctx.Request.Context(), &payload, sessionMeta(ctx))
+	a.service.Metrics.AuthAttempt("password", err == nil)
 	if err != nil {
 		HandlerError(ctx, err)
 		return
@@ -98,7 +114,7 @@ func (a *AuthController) GetProfile(ctx *gin.Context) {
 		return
 	}
 
-	profile, err := a.service.Auth.GetProfile(userID.(int))
+	profile, err := a.service.Auth.GetProfile(ctx.Request.Context(), userID.(int))
 	if err != nil {
 		HandlerError(ctx, err)
 		return
@@ -109,3 +125,173 @@ func (a *AuthController) GetProfile(ctx *gin.Context) {
 		"data":    profile,
 	})
 }
+
+// OAuthStart godoc
+// @Summary Start social login with an OIDC provider
+// @Tags Auth
+// @Param provider path string true "Provider name, e.g. google"
+// @Success 302
+// @Router /auth/oauth/{provider}/start [get]
+func (a *AuthController) OAuthStart(ctx *gin.Context) {
+	authURL, err := a.service.OAuth.StartLogin(ctx.Request.Context(), ctx.Param("provider"))
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// OAuthCallback godoc
+// @Summary Finish social login after the provider redirects back
+// @Tags Auth
+// @Produce json
+// @Param provider path string true "Provider name, e.g. google"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State issued by /start"
+// @Success 200 {object} dto.AuthResponse
+// @Router /auth/oauth/{provider}/callback [get]
+func (a *AuthController) OAuthCallback(ctx *gin.Context) {
+	var payload dto.OAuthCallbackRequest
+	if err := ctx.ShouldBindQuery(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := a.service.OAuth.HandleCallback(ctx.Request.Context(), ctx.Param("provider"), &payload, sessionMeta(ctx))
+	a.service.Metrics.AuthAttempt("oauth", err == nil)
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Login successful",
+		"data":    result,
+	})
+}
+
+// GuestChallenge godoc
+// @Summary Get a proof-of-work challenge for a guest token
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} dto.GuestChallengeResponse
+// @Router /auth/guest/challenge [get]
+func (a *AuthController) GuestChallenge(ctx *gin.Context) {
+	result, err := a.service.Auth.GuestChallenge(ctx.Request.Context())
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// Guest godoc
+// @Summary Get an anonymous guest token with a generated nickname
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body dto.GuestRequest false "Proof-of-work solution, when enabled"
+// @Success 201 {object} dto.AuthResponse
+// @Router /auth/guest [post]
+func (a *AuthController) Guest(ctx *gin.Context) {
+	var payload dto.GuestRequest
+	if err := ctx.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := a.service.Auth.Guest(ctx.Request.Context(), &payload, sessionMeta(ctx))
+	a.service.Metrics.AuthAttempt("guest", err == nil)
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Guest session created",
+		"data":    result,
+	})
+}
+
+// UpgradeGuest godoc
+// @Summary Turn the current guest into a full account
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body dto.RegisterRequest true "Account details"
+// @Success 200 {object} dto.AuthResponse
+// @Router /auth/guest/upgrade [post]
+func (a *AuthController) UpgradeGuest(ctx *gin.Context) {
+	var payload dto.RegisterRequest
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := a.service.Auth.UpgradeGuest(ctx.Request.Context(), ctx.GetInt("userID"), ctx.GetString("sessionID"), &payload)
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Account upgraded",
+		"data":    result,
+	})
+}
+
+// ListSessions godoc
+// @Summary List the active sessions of the current user
+// @Tags Auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.SessionResponse
+// @Router /auth/sessions [get]
+func (a *AuthController) ListSessions(ctx *gin.Context) {
+	sessions, err := a.service.Session.ListSessions(ctx.Request.Context(), ctx.GetInt("userID"), ctx.GetString("sessionID"))
+	if err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sessions,
+	})
+}
+
+// RevokeSession godoc
+// @Summary Log out one session of the current user
+// @Tags Auth
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200
+// @Router /auth/sessions/{id} [delete]
+func (a *AuthController) RevokeSession(ctx *gin.Context) {
+	if err := a.service.Session.RevokeSession(ctx.Request.Context(), ctx.GetInt("userID"), ctx.Param("id")); err != nil {
+		HandlerError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session revoked",
+	})
+}
+
+func sessionMeta(ctx *gin.Context) *dto.SessionMeta {
+	return &dto.SessionMeta{
+		UserAgent: ctx.Request.UserAgent(),
+		IPAddress: ctx.ClientIP(),
+	}
+}
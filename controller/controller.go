@@ -2,10 +2,9 @@ package controller
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 
-	"projectwebcurhat/config/middleware"
 	"projectwebcurhat/config/pkg/errs"
 	"projectwebcurhat/contract"
 
@@ -23,14 +22,15 @@ func New(app *gin.Engine, service *contract.Service) {
 		&HealthController{},
 		&WebSocketController{},
 		&AuthController{},
+		&JWKSController{},
+		&AdminController{},
 	}
 
 	for _, c := range allController {
 		c.InitService(service)
 		group := app.Group(c.GetPrefix())
-		group.Use(middleware.CORSMiddleware())
 		c.InitRoute(group)
-		log.Printf("initiate route %s\n", c.GetPrefix())
+		slog.Debug("initiate route", "prefix", c.GetPrefix())
 	}
 }
 
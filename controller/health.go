@@ -3,7 +3,9 @@ package controller
 import (
 	"net/http"
 
+	"projectwebcurhat/config/middleware"
 	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,15 +24,84 @@ func (h *HealthController) InitService(service *contract.Service) {
 
 func (h *HealthController) InitRoute(app *gin.RouterGroup) {
 	app.GET("/health", h.HandleHealth)
+	app.GET("/livez", h.HandleLive)
+	app.GET("/readyz", h.HandleReady)
+	app.GET("/health/details",
+		middleware.AuthMiddleware(h.service.Session),
+		middleware.RequireRole(h.service.Auth, database.RoleAdmin),
+		h.HandleDetails,
+	)
 }
 
+// HandleHealth godoc
+// @Summary Readiness summary with the room count, kept for existing monitors
+// @Tags Health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /health [get]
 func (h *HealthController) HandleHealth(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Server is running",
+	report := h.service.Health.Ready(ctx.Request.Context())
+
+	status, message := http.StatusOK, "Server is running"
+	switch report.Status {
+	case "draining":
+		status, message = http.StatusServiceUnavailable, "Server is shutting down"
+	case "not_ready":
+		status, message = http.StatusServiceUnavailable, "A dependency is unavailable"
+	}
+
+	ctx.JSON(status, gin.H{
+		"success": report.IsReady(),
+		"message": message,
 		"data": gin.H{
-			"status":     "healthy",
-			"room_count": h.service.Room.GetRoomCount(),
+			"status":     report.Status,
+			"components": report.Components,
+			"room_count": h.service.Room.GetRoomCount(ctx.Request.Context()),
 		},
 	})
 }
+
+// HandleLive godoc
+// @Summary Liveness probe, succeeds as long as the process serves HTTP
+// @Tags Health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /livez [get]
+func (h *HealthController) HandleLive(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleReady godoc
+// @Summary Readiness probe, checks the database, Redis and the message bus
+// @Tags Health
+// @Produce json
+// @Success 200 {object} dto.HealthReport
+// @Failure 503 {object} dto.HealthReport
+// @Router /readyz [get]
+func (h *HealthController) HandleReady(ctx *gin.Context) {
+	report := h.service.Health.Ready(ctx.Request.Context())
+
+	status := http.StatusOK
+	if !report.IsReady() {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
+
+// HandleDetails godoc
+// @Summary Component status with errors, build version and uptime (admin only)
+// @Tags Health
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.HealthDetails
+// @Router /health/details [get]
+func (h *HealthController) HandleDetails(ctx *gin.Context) {
+	details := h.service.Health.Details(ctx.Request.Context())
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Health details retrieved",
+		"data":    details,
+	})
+}
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"net/http"
+
+	"projectwebcurhat/config/pkg/token"
+	"projectwebcurhat/contract"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSController struct {
+	service *contract.Service
+}
+
+func (j *JWKSController) GetPrefix() string {
+	return "/.well-known"
+}
+
+func (j *JWKSController) InitService(service *contract.Service) {
+	j.service = service
+}
+
+func (j *JWKSController) InitRoute(app *gin.RouterGroup) {
+	app.GET("/jwks.json", j.HandleJWKS)
+}
+
+// HandleJWKS godoc
+// @Summary Public keys for verifying access tokens
+// @Tags Auth
+// @Produce json
+// @Router /.well-known/jwks.json [get]
+func (j *JWKSController) HandleJWKS(ctx *gin.Context) {
+	// Short cache so verifiers pick up rotated keys quickly
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, token.JWKS())
+}
@@ -1,9 +1,16 @@
 package controller
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/middleware"
+	"projectwebcurhat/config/pkg/token"
 	"projectwebcurhat/contract"
 	"projectwebcurhat/database"
 
@@ -12,16 +19,10 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 type WebSocketController struct {
-	service *contract.Service
+	service  *contract.Service
+	upgrader websocket.Upgrader
+	limits   config.WebSocketConfig
 }
 
 func (w *WebSocketController) GetPrefix() string {
@@ -33,80 +34,181 @@ func (w *WebSocketController) InitService(service *contract.Service) {
 }
 
 func (w *WebSocketController) InitRoute(app *gin.RouterGroup) {
+	cfg := config.Get()
+	origins := middleware.NewOriginMatcher(cfg.CORS.AllowedOrigins)
+	w.limits = cfg.WebSocket
+	w.upgrader = websocket.Upgrader{
+		ReadBufferSize:  cfg.WebSocket.ReadBufferSize,
+		WriteBufferSize: cfg.WebSocket.WriteBufferSize,
+		CheckOrigin: func(r *http.Request) bool {
+			return checkOrigin(r, origins)
+		},
+	}
+
 	app.GET("", w.HandleConnection)
 }
 
 func (w *WebSocketController) HandleConnection(ctx *gin.Context) {
-	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if w.service.Lifecycle.IsDraining() {
+		w.service.Metrics.UpgradeFailed("draining")
+		ctx.Header("Retry-After", "5")
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+
+	// Browsers cannot set headers on WebSocket requests, so the access token
+	// (full or guest) travels in the query string
+	var claims *token.Claims
+	if tokenString := ctx.Query("token"); tokenString != "" {
+		var err error
+		claims, err = token.ValidateToken(tokenString)
+		if err != nil {
+			w.service.Metrics.UpgradeFailed("invalid_token")
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		if err := w.service.Session.ValidateSession(ctx.Request.Context(), claims.UserID, claims.SessionID); err != nil {
+			w.service.Metrics.UpgradeFailed("invalid_session")
+			HandlerError(ctx, err)
+			return
+		}
+	}
+
+	conn, err := w.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Printf("Error upgrading connection: %v", err)
+		slog.WarnContext(ctx.Request.Context(), "Error upgrading connection", "error", err)
+		w.service.Metrics.UpgradeFailed("handshake")
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upgrade connection"})
 		return
 	}
 
-	username := ctx.Query("username")
-	if username == "" {
-		username = "Anonymous"
+	// Names are only taken from verified tokens so nobody can pose as a registered user
+	username := "Anonymous"
+	if claims != nil {
+		username = claims.Username
 	}
 
 	clientID := uuid.New().String()
-	client := database.NewClient(clientID, conn, username)
+	client := database.NewClient(clientID, conn, username, w.limits.SendBuffer)
+	client.NodeID = config.Get().Server.NodeID
+	if claims != nil {
+		client.UserID = claims.UserID
+		client.IsGuest = claims.Guest
+	}
 
-	log.Printf("New client connected: %s (username: %s)", clientID, username)
+	// The request context ends when this handler returns, so the connection
+	// gets its own. It is cancelled once the socket is gone, which stops
+	// whatever work a message of this client still has in flight.
+	connCtx, cancel := context.WithCancel(context.Background())
 
-	go w.writePump(client)
-	go w.readPump(client)
+	if err := w.service.Signaling.ConnectClient(connCtx, client); err != nil {
+		client.Logger().ErrorContext(ctx.Request.Context(), "Error registering client", "error", err)
+		cancel()
+		conn.Close()
+		return
+	}
+
+	// Logged with the request ID of the upgrade, which ties it to the client ID
+	client.Logger().InfoContext(ctx.Request.Context(), "New client connected", "guest", client.IsGuest)
+
+	go w.writePump(connCtx, cancel, client)
+	go w.readPump(connCtx, cancel, client)
 }
 
-func (w *WebSocketController) readPump(client *database.Client) {
+// checkOrigin guards against cross-site WebSocket hijacking. Clients that send
+// no Origin (native apps, CLIs) and same-origin pages are always accepted.
+func checkOrigin(r *http.Request, origins *middleware.OriginMatcher) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	if !origins.Allowed(origin) {
+		slog.WarnContext(r.Context(), "Rejected WebSocket upgrade", "origin", origin)
+		return false
+	}
+	return true
+}
+
+// readPump drops clients that send oversized messages or stop answering pings
+func (w *WebSocketController) readPump(ctx context.Context, cancel context.CancelFunc, client *database.Client) {
 	defer func() {
-		w.service.Signaling.DisconnectClient(client)
+		// Leaving the room has to finish even though the connection is gone
+		w.service.Signaling.DisconnectClient(context.WithoutCancel(ctx), client)
+		cancel()
 		client.Conn.Close()
-		log.Printf("Client %s disconnected", client.ID)
+		client.Logger().Info("Client disconnected")
 	}()
 
+	client.Conn.SetReadLimit(w.limits.MaxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(w.limits.PongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(w.limits.PongWait))
+	})
+
 	for {
 		_, message, err := client.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				client.Logger().Warn("WebSocket error", "error", err)
 			}
 			break
 		}
 
-		if err := w.service.Signaling.HandleMessage(client, message); err != nil {
-			log.Printf("Error handling message: %v", err)
+		if err := w.service.Signaling.HandleMessage(ctx, client, message); err != nil {
+			client.Logger().Warn("Error handling message", "error", err)
 		}
 	}
 }
 
-func (w *WebSocketController) writePump(client *database.Client) {
+// writePump also sends the pings that keep the read deadline moving. A
+// failed write means the socket is gone, so it cancels the connection context.
+// It stops as well once the read pump cancelled the context.
+func (w *WebSocketController) writePump(ctx context.Context, cancel context.CancelFunc, client *database.Client) {
+	ticker := time.NewTicker(w.limits.PingInterval)
 	defer func() {
+		ticker.Stop()
+		cancel()
 		client.Conn.Close()
 	}()
 
 	for {
-		message, ok := <-client.Send
-		if !ok {
-			client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+		select {
+		case <-ctx.Done():
 			return
-		}
+		case message, ok := <-client.Send:
+			client.Conn.SetWriteDeadline(time.Now().Add(w.limits.WriteWait))
+			if !ok {
+				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
 
-		writer, err := client.Conn.NextWriter(websocket.TextMessage)
-		if err != nil {
-			return
-		}
+			writer, err := client.Conn.NextWriter(websocket.TextMessage)
+			if err != nil {
+				return
+			}
 
-		writer.Write(message)
+			writer.Write(message)
 
-		n := len(client.Send)
-		for i := 0; i < n; i++ {
-			writer.Write([]byte{'\n'})
-			writer.Write(<-client.Send)
-		}
+			n := len(client.Send)
+			for i := 0; i < n; i++ {
+				writer.Write([]byte{'\n'})
+				writer.Write(<-client.Send)
+			}
 
-		if err := writer.Close(); err != nil {
-			return
+			if err := writer.Close(); err != nil {
+				return
+			}
+		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(w.limits.WriteWait))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,37 @@
+package controller_test
+
+import (
+	"runtime"
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+// The read and write pump of a connection both end once its socket is gone,
+// whether or not the client was in a room
+func TestConnectionGoroutinesStop(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	baseline := runtime.NumGoroutine()
+
+	idle := s.Dial("")
+	joined, matched := s.Dial(""), s.Dial("")
+	joined.Send(dto.Message{Type: dto.MessageTypeJoin})
+	joined.Expect(dto.MessageTypeReady)
+	matched.Send(dto.Message{Type: dto.MessageTypeJoin})
+	matched.Expect(dto.MessageTypeReady)
+	testutil.WaitFor(t, "three connections", func() bool {
+		return s.Service.Signaling.LocalClientCount() == 3
+	})
+
+	for _, client := range []*testutil.Client{idle, joined, matched} {
+		client.Close()
+	}
+
+	testutil.WaitFor(t, "the server to drop the connections", func() bool {
+		return s.Service.Signaling.LocalClientCount() == 0
+	})
+	testutil.WaitFor(t, "the connection goroutines to stop", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+}
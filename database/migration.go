@@ -1,34 +1,459 @@
 package database
 
 import (
+	"context"
+	"embed"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationDirs holds the migrations of each GORM dialect. Both sets keep the
+// same versions so a schema is described the same way on either database.
+var migrationDirs = map[string]string{
+	"postgres": "migrations",
+	"sqlite":   "migrations/sqlite",
+}
+
+// migrationLockKey is the Postgres advisory lock held while migrating, so
+// replicas starting together apply each migration once
+const migrationLockKey = 7_263_310_042
+
+// noTransactionDirective on the first line of an up or down file runs it
+// outside a transaction, which statements like CREATE INDEX CONCURRENTLY
+// need. Such files must hold a single statement.
+const noTransactionDirective = "-- migrate:no-transaction"
+
+// Migration is one pair of NNNN_name.up.sql / NNNN_name.down.sql files
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration is a row of schema_migrations. A dirty row is a migration
+// that ran outside a transaction and failed halfway; it has to be fixed by
+// hand and cleared with force.
+type SchemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;not null"`
+	Dirty     bool      `gorm:"column:dirty;not null;default:false"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus describes one known migration and whether it is applied
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	Dirty     bool
+	AppliedAt *time.Time
+}
+
+// models are the tables the migrations create, checked by VerifySchema
+var models = []any{&User{}, &UserIdentity{}, &Session{}, &Setting{}, &OAuthState{}, &GuestChallenge{}, &UserBlock{}, &Report{}}
+
+type Migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []Migration
+}
+
+// NewMigrator loads the migrations written for the dialect of db
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	dir, ok := migrationDirs[dialect]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for database dialect %q", dialect)
+	}
+
+	migrations, err := loadMigrations(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// RunMigration applies every pending migration
 func RunMigration(db *gorm.DB) error {
-	log.Println("Running migrations...")
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up(context.Background())
+	return err
+}
+
+// Up applies all pending migrations in order and returns how many ran
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		done, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			slog.Info("Applying migration", "version", migration.Version, "name", migration.Name)
+			if err := m.apply(conn, migration); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	if err != nil {
+		return applied, err
+	}
+
+	slog.Info("Migrations completed successfully", "applied", applied)
+	return applied, nil
+}
+
+// Down reverts the given number of most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		done, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
 
-	if err := db.AutoMigrate(
-		&User{},
-	); err != nil {
-		return fmt.Errorf("migration failed: %w", err)
+			slog.Info("Reverting migration", "version", migration.Version, "name", migration.Name)
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration and its state in the database
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var rows []SchemaMigration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		return conn.Order("version").Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	log.Println("Migrations completed successfully")
+	applied := make(map[int64]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.Dirty = row.Dirty
+			status.AppliedAt = &row.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Force records the schema as being exactly at version without running any
+// SQL: migrations up to it are marked applied and clean, later ones are
+// forgotten. It is the way out of a dirty state after a manual fix.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *gorm.DB) error {
+		return conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("version > ?", version).Delete(&SchemaMigration{}).Error; err != nil {
+				return err
+			}
+
+			for _, migration := range m.migrations {
+				if migration.Version > version {
+					break
+				}
+				row := SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+				if err := tx.Save(&row).Error; err != nil {
+					return err
+				}
+			}
+			slog.Warn("Forced migration version", "version", version)
+			return nil
+		})
+	})
+}
+
+// VerifySchema checks that every table, column, index and foreign key of
+// the GORM models exists and that the tables have no unknown columns
+func (m *Migrator) VerifySchema(ctx context.Context) error {
+	db := m.db.WithContext(ctx)
+	migrator := db.Migrator()
+
+	var problems []string
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+
+		if !migrator.HasTable(model) {
+			problems = append(problems, "missing table "+table)
+			continue
+		}
+
+		columnTypes, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return err
+		}
+		columns := make(map[string]bool, len(columnTypes))
+		for _, column := range columnTypes {
+			columns[column.Name()] = true
+		}
+
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			if !columns[field.DBName] {
+				problems = append(problems, fmt.Sprintf("missing column %s.%s", table, field.DBName))
+			}
+			delete(columns, field.DBName)
+		}
+		for column := range columns {
+			problems = append(problems, fmt.Sprintf("column %s.%s is not in the model", table, column))
+		}
+
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, index.Name) {
+				problems = append(problems, fmt.Sprintf("missing index %s on %s", index.Name, table))
+			}
+		}
+
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if constraint := rel.ParseConstraint(); constraint != nil && constraint.Schema == stmt.Schema {
+				if !migrator.HasConstraint(model, constraint.Name) {
+					problems = append(problems, fmt.Sprintf("missing constraint %s on %s", constraint.Name, table))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("schema does not match the models: %s", strings.Join(problems, "; "))
+	}
 	return nil
 }
 
-func DropMigration(db *gorm.DB) error {
-	log.Println("Dropping all tables...")
+// withLock runs fn on a single connection holding the migration lock, with
+// schema_migrations created if needed. SQLite has no advisory locks; its
+// database belongs to one process, and concurrent writers wait on the file
+// lock instead.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		appliedAtType := "DATETIME"
+		if m.dialect == "postgres" {
+			appliedAtType = "TIMESTAMPTZ"
+
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+				return fmt.Errorf("failed to acquire migration lock: %w", err)
+			}
+			defer func() {
+				// The lock belongs to the session, so it has to be released before
+				// the connection goes back to the pool
+				if err := conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey).Error; err != nil {
+					slog.Error("Error releasing migration lock", "error", err)
+				}
+			}()
+		}
+
+		if err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    BIGINT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			dirty      BOOLEAN NOT NULL DEFAULT FALSE,
+			applied_at ` + appliedAtType + ` NOT NULL
+		)`).Error; err != nil {
+			return fmt.Errorf("failed to create schema_migrations: %w", err)
+		}
+
+		return fn(conn)
+	})
+}
+
+// appliedVersions refuses to continue while a migration is dirty
+func (m *Migrator) appliedVersions(conn *gorm.DB) (map[int64]struct{}, error) {
+	var rows []SchemaMigration
+	if err := conn.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	done := make(map[int64]struct{}, len(rows))
+	for _, row := range rows {
+		if row.Dirty {
+			return nil, fmt.Errorf("migration %d (%s) is dirty: fix the schema by hand, then run migrate force", row.Version, row.Name)
+		}
+		done[row.Version] = struct{}{}
+	}
+	return done, nil
+}
+
+func (m *Migrator) apply(conn *gorm.DB, migration Migration) error {
+	row := SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+
+	if !runsInTransaction(migration.Up) {
+		return m.runDirty(conn, migration, "up", func() error {
+			return conn.Model(&row).Updates(map[string]any{"dirty": false, "applied_at": time.Now()}).Error
+		})
+	}
+
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		return tx.Create(&row).Error
+	})
+}
+
+func (m *Migrator) revert(conn *gorm.DB, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %d (%s) has no down file", migration.Version, migration.Name)
+	}
 
-	if err := db.Migrator().DropTable(
-		&User{},
-	); err != nil {
-		return fmt.Errorf("failed to drop tables: %w", err)
+	if !runsInTransaction(migration.Down) {
+		return m.runDirty(conn, migration, "down", func() error {
+			return conn.Delete(&SchemaMigration{}, migration.Version).Error
+		})
 	}
 
-	log.Println("All tables dropped")
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		return tx.Delete(&SchemaMigration{}, migration.Version).Error
+	})
+}
+
+// runDirty marks the migration dirty, runs the statement without a
+// transaction and calls done only when it succeeded
+func (m *Migrator) runDirty(conn *gorm.DB, migration Migration, direction string, done func() error) error {
+	sql := migration.Up
+	if direction == "down" {
+		sql = migration.Down
+	}
+
+	dirty := SchemaMigration{Version: migration.Version, Name: migration.Name, Dirty: true, AppliedAt: time.Now()}
+	if err := conn.Save(&dirty).Error; err != nil {
+		return err
+	}
+	if err := conn.Exec(sql).Error; err != nil {
+		return fmt.Errorf("migration %d (%s) %s failed and is now dirty: %w", migration.Version, migration.Name, direction, err)
+	}
+	return done()
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
 	return nil
 }
+
+func runsInTransaction(sql string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(sql), noTransactionDirective)
+}
+
+// loadMigrations reads NNNN_name.up.sql and NNNN_name.down.sql files sorted
+// by version. Every version needs an up file; down files are optional.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.%s.sql", name, direction)
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration file %s has an invalid version", name)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: label}
+			byVersion[version] = migration
+		} else if migration.Name != label {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, label)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
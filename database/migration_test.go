@@ -0,0 +1,94 @@
+package database_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openSQLite opens an empty in-memory database. It is held by a single
+// connection, since every new connection to :memory: starts empty.
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestMigrationsBuildTheModelSchema(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("reading status: %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	if applied != len(statuses) {
+		t.Fatalf("applied %d migrations, want all %d", applied, len(statuses))
+	}
+	if err := migrator.VerifySchema(ctx); err != nil {
+		t.Fatalf("schema after migrating an empty database: %v", err)
+	}
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != 0 {
+		t.Fatalf("second up applied %d migrations (%v), want none", applied, err)
+	}
+
+	// Every down migration undoes its up migration
+	if reverted, err := migrator.Down(ctx, len(statuses)); err != nil || reverted != len(statuses) {
+		t.Fatalf("reverted %d migrations (%v), want %d", reverted, err, len(statuses))
+	}
+	if err := migrator.VerifySchema(ctx); err == nil {
+		t.Fatal("schema verified after reverting every migration")
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+	if err := migrator.VerifySchema(ctx); err != nil {
+		t.Fatalf("schema after migrating up again: %v", err)
+	}
+}
+
+func TestVerifySchemaReportsDrift(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := database.RunMigration(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	if err := db.Exec("ALTER TABLE users ADD COLUMN nickname TEXT").Error; err != nil {
+		t.Fatalf("changing the schema: %v", err)
+	}
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	err = migrator.VerifySchema(ctx)
+	if err == nil || !strings.Contains(err.Error(), "users.nickname") {
+		t.Fatalf("got %v, want the extra column reported", err)
+	}
+}
@@ -0,0 +1,15 @@
+-- IF NOT EXISTS lets databases created by the old AutoMigrate adopt this history
+CREATE TABLE IF NOT EXISTS users (
+    id         BIGSERIAL PRIMARY KEY,
+    username   TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    password   TEXT NOT NULL,
+    is_online  BOOLEAN DEFAULT FALSE,
+    is_guest   BOOLEAN DEFAULT FALSE,
+    role       VARCHAR(20) NOT NULL DEFAULT 'user',
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id           VARCHAR(36) PRIMARY KEY,
+    user_id      BIGINT NOT NULL,
+    user_agent   VARCHAR(512),
+    ip_address   VARCHAR(64),
+    created_at   TIMESTAMPTZ,
+    last_seen_at TIMESTAMPTZ NOT NULL,
+    revoked_at   TIMESTAMPTZ,
+    CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
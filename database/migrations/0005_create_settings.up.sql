@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS settings (
+    key        VARCHAR(100) PRIMARY KEY,
+    value      TEXT NOT NULL,
+    updated_by BIGINT,
+    updated_at TIMESTAMPTZ
+);
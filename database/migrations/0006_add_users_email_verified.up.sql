@@ -0,0 +1,7 @@
+-- Only emails a provider vouched for count as verified; sign-ups with a
+-- password never proved theirs. Accounts already linked to a provider with
+-- the same email keep being linkable.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET email_verified = TRUE
+WHERE EXISTS (SELECT 1 FROM user_identities WHERE user_identities.user_id = users.id AND user_identities.email = users.email);
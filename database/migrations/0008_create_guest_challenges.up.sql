@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS guest_challenges (
+    challenge  VARCHAR(64) PRIMARY KEY,
+    bits       INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_guest_challenges_expires_at ON guest_challenges (expires_at);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS users (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    username   TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    password   TEXT NOT NULL,
+    is_online  BOOLEAN DEFAULT FALSE,
+    is_guest   BOOLEAN DEFAULT FALSE,
+    role       VARCHAR(20) NOT NULL DEFAULT 'user',
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
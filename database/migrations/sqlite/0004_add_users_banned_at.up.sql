@@ -0,0 +1,2 @@
+-- SQLite has no ADD COLUMN IF NOT EXISTS; no SQLite database predates this history
+ALTER TABLE users ADD COLUMN banned_at DATETIME;
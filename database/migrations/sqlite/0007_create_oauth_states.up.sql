@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state         VARCHAR(64) PRIMARY KEY,
+    provider      VARCHAR(100) NOT NULL,
+    code_verifier TEXT NOT NULL,
+    nonce         TEXT NOT NULL,
+    expires_at    DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_states_expires_at ON oauth_states (expires_at);
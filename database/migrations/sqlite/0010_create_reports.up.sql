@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS reports (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    reporter_id BIGINT NOT NULL,
+    reported_id BIGINT NOT NULL,
+    room_id     VARCHAR(36) NOT NULL,
+    reason      TEXT NOT NULL,
+    blocked     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  DATETIME,
+    CONSTRAINT fk_reports_reporter FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_reports_reported FOREIGN KEY (reported_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_reporter_id ON reports (reporter_id);
+CREATE INDEX IF NOT EXISTS idx_reports_reported_id ON reports (reported_id);
@@ -1,6 +1,9 @@
 package database
 
 import (
+	"encoding/json"
+	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -9,42 +12,271 @@ import (
 
 // ==================== Database Models (PostgreSQL) ====================
 
+// Roles a user can have. Admins may use the /admin endpoints.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents a registered user in the system
 type User struct {
+	ID       int    `gorm:"column:id;primaryKey;autoIncrement;not null;<-:create" json:"id"`
+	Username string `gorm:"column:username;uniqueIndex;not null" json:"username"`
+	Email    string `gorm:"column:email;uniqueIndex;not null" json:"email"`
+	// EmailVerified is set when the email came from a provider's verified
+	// claim; only then may another provider's login be linked by it
+	EmailVerified bool       `gorm:"column:email_verified;not null;default:false" json:"email_verified"`
+	Password      string     `gorm:"column:password;not null" json:"-"`
+	IsOnline      bool       `gorm:"column:is_online;default:false" json:"is_online"`
+	IsGuest       bool       `gorm:"column:is_guest;default:false" json:"is_guest"`
+	Role          string     `gorm:"column:role;size:20;not null;default:user" json:"role"`
+	BannedAt      *time.Time `gorm:"column:banned_at" json:"banned_at"`
+	CreatedAt     time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+// UserIdentity links a user to an account at an external OIDC provider
+type UserIdentity struct {
 	ID        int       `gorm:"column:id;primaryKey;autoIncrement;not null;<-:create" json:"id"`
-	Username  string    `gorm:"column:username;uniqueIndex;not null" json:"username"`
-	Email     string    `gorm:"column:email;uniqueIndex;not null" json:"email"`
-	Password  string    `gorm:"column:password;not null" json:"-"`
-	IsOnline  bool      `gorm:"column:is_online;default:false" json:"is_online"`
+	UserID    int       `gorm:"column:user_id;index;not null" json:"user_id"`
+	Provider  string    `gorm:"column:provider;uniqueIndex:idx_identity_provider_subject;not null" json:"provider"`
+	Subject   string    `gorm:"column:subject;uniqueIndex:idx_identity_provider_subject;not null" json:"subject"`
+	Email     string    `gorm:"column:email" json:"email"`
 	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	User      *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// Session is one issued access token, so users can see and revoke where they are logged in
+type Session struct {
+	ID         string     `gorm:"column:id;primaryKey;size:36" json:"id"`
+	UserID     int        `gorm:"column:user_id;index;not null" json:"user_id"`
+	UserAgent  string     `gorm:"column:user_agent;size:512" json:"user_agent"`
+	IPAddress  string     `gorm:"column:ip_address;size:64" json:"ip_address"`
+	CreatedAt  time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	LastSeenAt time.Time  `gorm:"column:last_seen_at;not null" json:"last_seen_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	User       *User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// OAuthState is a pending OIDC authorization request, kept until the
+// provider redirects back. It is stored in the database, so the callback may
+// reach another replica than the one that started the login.
+type OAuthState struct {
+	State        string    `gorm:"column:state;primaryKey;size:64"`
+	Provider     string    `gorm:"column:provider;size:100;not null"`
+	CodeVerifier string    `gorm:"column:code_verifier;not null"`
+	Nonce        string    `gorm:"column:nonce;not null"`
+	ExpiresAt    time.Time `gorm:"column:expires_at;index;not null"`
+}
+
+func (OAuthState) TableName() string {
+	return "oauth_states"
+}
+
+// Setting is one runtime setting, stored as JSON so every replica reads the
+// same typed value. UpdatedBy is the admin who last changed it.
+type Setting struct {
+	Key       string    `gorm:"column:key;primaryKey;size:100" json:"key"`
+	Value     string    `gorm:"column:value;not null" json:"value"`
+	UpdatedBy *int      `gorm:"column:updated_by" json:"updated_by"`
 	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
 }
 
 // ==================== In-Memory Models (WebSocket/WebRTC) ====================
 
 // Client represents a connected WebSocket client
+// Clients connected to another replica have a nil Conn and Send; NodeID
+// tells which replica owns them.
+// The connection's own goroutines own the client. The room and match time
+// are also read and changed through a peer's or the bus's goroutine, so
+// they are only reached through methods.
 type Client struct {
 	ID       string
 	Conn     *websocket.Conn
-	RoomID   string
 	Send     chan []byte
 	Username string
+	UserID   int // 0 for unauthenticated connections
+	IsGuest  bool
+	// Blocked are the users this client blocked or was blocked by, loaded
+	// when it joins, so it is never matched with them
+	Blocked     []int
+	NodeID      string
+	ConnectedAt time.Time
+	JoinedAt    time.Time // when the client asked to be matched
+	// Protocol is the signaling protocol version settled by the first
+	// message, with the capabilities negotiated in hello
+	Protocol     int
+	Capabilities []string
+
+	sendMutex sync.Mutex
+	closed    bool
+
+	roomID string
+	// matchedAt is set on the local clients of a room once it is full and
+	// cleared when the call ends
+	matchedAt  time.Time
+	stateMutex sync.RWMutex
 }
 
-func NewClient(id string, conn *websocket.Conn, username string) *Client {
+// NewClient creates a local client whose send channel holds sendBuffer messages
+func NewClient(id string, conn *websocket.Conn, username string, sendBuffer int) *Client {
 	return &Client{
-		ID:       id,
-		Conn:     conn,
-		Send:     make(chan []byte, 256),
-		Username: username,
+		ID:          id,
+		Conn:        conn,
+		Send:        make(chan []byte, sendBuffer),
+		ConnectedAt: time.Now(),
+		Username:    username,
 	}
 }
 
+// GuestChallenge is a proof-of-work puzzle handed out before a guest token
+// is issued. It is stored in the database, so the solution may reach
+// another replica than the one that handed out the puzzle.
+type GuestChallenge struct {
+	Challenge string    `gorm:"column:challenge;primaryKey;size:64"`
+	Bits      int       `gorm:"column:bits;not null"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index;not null"`
+}
+
+func (GuestChallenge) TableName() string {
+	return "guest_challenges"
+}
+
+// UserBlock keeps two users from being matched again. It works both ways:
+// the blocked user is not matched with the blocker either.
+type UserBlock struct {
+	BlockerID int       `gorm:"column:blocker_id;primaryKey;autoIncrement:false"`
+	BlockedID int       `gorm:"column:blocked_id;primaryKey;autoIncrement:false;index"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	Blocker   *User     `gorm:"foreignKey:BlockerID;constraint:OnDelete:CASCADE"`
+	Blocked   *User     `gorm:"foreignKey:BlockedID;constraint:OnDelete:CASCADE"`
+}
+
+func (UserBlock) TableName() string {
+	return "user_blocks"
+}
+
+// Report is a complaint about the peer of a call, kept for the admins.
+// Guests can report and be reported like registered users.
+type Report struct {
+	ID         int       `gorm:"column:id;primaryKey;autoIncrement;not null;<-:create" json:"id"`
+	ReporterID int       `gorm:"column:reporter_id;index;not null" json:"reporter_id"`
+	ReportedID int       `gorm:"column:reported_id;index;not null" json:"reported_id"`
+	RoomID     string    `gorm:"column:room_id;size:36;not null" json:"room_id"`
+	Reason     string    `gorm:"column:reason;not null" json:"reason"`
+	Blocked    bool      `gorm:"column:blocked;not null;default:false" json:"blocked"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	Reporter   *User     `gorm:"foreignKey:ReporterID;constraint:OnDelete:CASCADE" json:"-"`
+	Reported   *User     `gorm:"foreignKey:ReportedID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Report) TableName() string {
+	return "reports"
+}
+
+// Logger tags records with the connection's client, user and current room,
+// so everything logged about one WebSocket can be correlated
+func (c *Client) Logger() *slog.Logger {
+	return slog.With("client_id", c.ID, "user_id", c.UserID, "room_id", c.RoomID())
+}
+
+// RoomID is the room the client was matched into, empty outside of one
+func (c *Client) RoomID() string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.roomID
+}
+
+func (c *Client) SetRoomID(roomID string) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.roomID = roomID
+}
+
+func (c *Client) SetMatchedAt(matchedAt time.Time) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.matchedAt = matchedAt
+}
+
+// TakeMatchedAt returns when the client's call started and clears it, so
+// the call is only recorded once. It is zero when there is no call.
+func (c *Client) TakeMatchedAt() time.Time {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	matchedAt := c.matchedAt
+	c.matchedAt = time.Time{}
+	return matchedAt
+}
+
+// IsLocal reports whether the client's socket belongs to this replica
+func (c *Client) IsLocal() bool {
+	return c.Send != nil
+}
+
+// Enqueue queues data for the write pump. It returns false when the send
+// channel has already been closed or its buffer is full.
+func (c *Client) Enqueue(data []byte) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseSend closes the send channel once; later calls are no-ops
+func (c *Client) CloseSend() {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	// Clients of other replicas have no channel to close
+	if !c.closed && c.Send != nil {
+		c.closed = true
+		close(c.Send)
+	}
+}
+
+// Room states as shown to moderators. Waiting and negotiating are derived
+// from the participants; connected is recorded once an answer was relayed.
+const (
+	RoomStateWaiting     = "waiting"
+	RoomStateNegotiating = "negotiating"
+	RoomStateConnected   = "connected"
+)
+
+// Negotiation states of a room's offer/answer exchange. Offers are accepted
+// when stable or answered; answers only while an offer is pending.
+const (
+	NegotiationStable       = "stable"        // nothing offered yet
+	NegotiationOfferPending = "offer-pending" // an offer was relayed and not answered yet
+	NegotiationAnswered     = "answered"      // the last offer was answered
+)
+
+// Negotiation tracks the offer/answer exchange of a room. The zero value is
+// a room where nothing was offered yet.
+type Negotiation struct {
+	State string `json:"state,omitempty"`
+	// Offerer sent the pending offer
+	Offerer string `json:"offerer,omitempty"`
+	// Queued is a later offer of the offerer, relayed once the pending one is answered
+	Queued json.RawMessage `json:"queued,omitempty"`
+}
+
 // Room represents a chat/signaling room
 type Room struct {
-	ID      string
-	Clients map[string]*Client
-	Mutex   sync.RWMutex
+	ID          string
+	Clients     map[string]*Client
+	State       string // last recorded state, see GetState
+	Negotiation Negotiation
+	Mutex       sync.RWMutex
 }
 
 func NewRoom(id string) *Room {
@@ -63,7 +295,7 @@ func (r *Room) AddClient(client *Client) bool {
 	}
 
 	r.Clients[client.ID] = client
-	client.RoomID = r.ID
+	client.SetRoomID(r.ID)
 	return true
 }
 
@@ -72,7 +304,7 @@ func (r *Room) RemoveClient(clientID string) {
 	defer r.Mutex.Unlock()
 
 	if client, exists := r.Clients[clientID]; exists {
-		close(client.Send)
+		client.CloseSend()
 		delete(r.Clients, clientID)
 	}
 }
@@ -89,12 +321,73 @@ func (r *Room) GetOtherClient(clientID string) *Client {
 	return nil
 }
 
+// HasAnyUser reports whether one of the participants is among userIDs
+func (r *Room) HasAnyUser(userIDs []int) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	for _, client := range r.Clients {
+		if client.UserID != 0 && slices.Contains(userIDs, client.UserID) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClients returns a snapshot of the room's participants
+func (r *Room) GetClients() []*Client {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	clients := make([]*Client, 0, len(r.Clients))
+	for _, client := range r.Clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Initiator is the participant that waited longest, ties going to the lower
+// ID. Every replica picks the same one from the stored join times.
+func (r *Room) Initiator() *Client {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	var initiator *Client
+	for _, client := range r.Clients {
+		if initiator == nil || client.JoinedAt.Before(initiator.JoinedAt) ||
+			(client.JoinedAt.Equal(initiator.JoinedAt) && client.ID < initiator.ID) {
+			initiator = client
+		}
+	}
+	return initiator
+}
+
 func (r *Room) IsFull() bool {
 	r.Mutex.RLock()
 	defer r.Mutex.RUnlock()
 	return len(r.Clients) >= 2
 }
 
+// GetState reports waiting until the room is full, then negotiating until a
+// connected state has been recorded
+func (r *Room) GetState() string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return RoomStateOf(len(r.Clients), r.State)
+}
+
+// RoomStateOf is GetState for a room with the given number of clients and
+// recorded state, for stores that count rooms without building them
+func RoomStateOf(clients int, recorded string) string {
+	if clients < 2 {
+		return RoomStateWaiting
+	}
+	if recorded == RoomStateConnected {
+		return RoomStateConnected
+	}
+	return RoomStateNegotiating
+}
+
 func (r *Room) IsEmpty() bool {
 	r.Mutex.RLock()
 	defer r.Mutex.RUnlock()
@@ -0,0 +1,42 @@
+package dto
+
+import "time"
+
+// AdminRoom is a live room as seen by moderators. State is "waiting",
+// "negotiating" or "connected".
+type AdminRoom struct {
+	ID           string             `json:"id"`
+	State        string             `json:"state"`
+	Participants []AdminParticipant `json:"participants"`
+}
+
+// AdminReport is a report about the peer of a call. Usernames are empty
+// once the user was deleted.
+type AdminReport struct {
+	ID               int       `json:"id"`
+	ReporterID       int       `json:"reporter_id"`
+	ReporterUsername string    `json:"reporter_username"`
+	ReportedID       int       `json:"reported_id"`
+	ReportedUsername string    `json:"reported_username"`
+	ReportedIsGuest  bool      `json:"reported_is_guest"`
+	RoomID           string    `json:"room_id"`
+	Reason           string    `json:"reason"`
+	Blocked          bool      `json:"blocked"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type AdminParticipant struct {
+	ClientID             string    `json:"client_id"`
+	UserID               int       `json:"user_id"`
+	Username             string    `json:"username"`
+	IsGuest              bool      `json:"is_guest"`
+	NodeID               string    `json:"node_id"`
+	ConnectedAt          time.Time `json:"connected_at"`
+	ConnectionAgeSeconds int64     `json:"connection_age_seconds"`
+}
+
+// AdminActionRequest is the optional body of close and kick requests; the
+// reason is shown to the affected clients
+type AdminActionRequest struct {
+	Reason string `json:"reason" binding:"max=200"`
+}
@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 // RegisterRequest is the DTO for user registration
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
@@ -21,8 +23,50 @@ type AuthResponse struct {
 
 // UserProfile is the public user data (no password)
 type UserProfile struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	IsOnline bool   `json:"is_online"`
+	ID       int        `json:"id"`
+	Username string     `json:"username"`
+	Email    string     `json:"email"`
+	IsOnline bool       `json:"is_online"`
+	IsGuest  bool       `json:"is_guest"`
+	Role     string     `json:"role"`
+	BannedAt *time.Time `json:"banned_at,omitempty"`
+}
+
+// OAuthCallbackRequest is the query string sent back by an OIDC provider
+type OAuthCallbackRequest struct {
+	Code             string `form:"code"`
+	State            string `form:"state"`
+	Error            string `form:"error"`
+	ErrorDescription string `form:"error_description"`
+}
+
+// GuestRequest is the DTO for requesting a guest token. Challenge and Nonce
+// are only required when proof-of-work is enabled.
+type GuestRequest struct {
+	Challenge string `json:"challenge"`
+	Nonce     string `json:"nonce"`
+}
+
+// GuestChallengeResponse is a proof-of-work puzzle: find a nonce so that
+// sha256(challenge + ":" + nonce) starts with Bits zero bits
+type GuestChallengeResponse struct {
+	Challenge string `json:"challenge"`
+	Bits      int    `json:"bits"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// SessionMeta describes the client a session is created for
+type SessionMeta struct {
+	UserAgent string
+	IPAddress string
+}
+
+// SessionResponse is one active login shown to the user
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
 }
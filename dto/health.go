@@ -0,0 +1,36 @@
+package dto
+
+import "time"
+
+// ComponentStatus is the result of checking one dependency. Status is "up",
+// "down" or "disabled" when the component is not configured.
+type ComponentStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the readiness of this replica. Status is "ready",
+// "not_ready" or "draining".
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+}
+
+func (r *HealthReport) IsReady() bool {
+	return r.Status == "ready"
+}
+
+// HealthDetails extends the readiness report with build and runtime data for admins
+type HealthDetails struct {
+	HealthReport
+	Version       string    `json:"version"`
+	Commit        string    `json:"commit"`
+	GoVersion     string    `json:"go_version"`
+	NodeID        string    `json:"node_id"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Connections   int       `json:"connections"`
+	RoomCount     int       `json:"room_count"`
+}
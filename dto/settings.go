@@ -0,0 +1,40 @@
+package dto
+
+// Settings can be changed at runtime through PUT /admin/settings and apply
+// to every replica without a restart. Each top level field is stored as one
+// row of the settings table.
+type Settings struct {
+	// MatchTimeoutSeconds ends the wait of a client nobody was matched with, 0 waits forever
+	MatchTimeoutSeconds int          `json:"match_timeout_seconds"`
+	GuestRateLimit      int          `json:"guest_rate_limit"` // guest tokens per IP per hour
+	GuestPoWBits        int          `json:"guest_pow_bits"`   // 0 disables the proof-of-work
+	Features            FeatureFlags `json:"features"`
+	// CrisisKeywords are sent to clients when they are matched; calls are
+	// peer to peer, so clients check their chat against the list themselves
+	CrisisKeywords []string `json:"crisis_keywords"`
+}
+
+type FeatureFlags struct {
+	Registration  bool `json:"registration"`
+	GuestAccounts bool `json:"guest_accounts"`
+}
+
+// SettingsUpdateRequest changes only the fields that are present
+type SettingsUpdateRequest struct {
+	MatchTimeoutSeconds *int                `json:"match_timeout_seconds" binding:"omitempty,min=0,max=3600"`
+	GuestRateLimit      *int                `json:"guest_rate_limit" binding:"omitempty,min=1,max=100000"`
+	GuestPoWBits        *int                `json:"guest_pow_bits" binding:"omitempty,min=0,max=32"`
+	Features            *FeatureFlagsUpdate `json:"features"`
+	// CrisisKeywords replaces the whole list, an empty list clears it
+	CrisisKeywords []string `json:"crisis_keywords" binding:"omitempty,max=1000,dive,min=1,max=100"`
+}
+
+type FeatureFlagsUpdate struct {
+	Registration  *bool `json:"registration"`
+	GuestAccounts *bool `json:"guest_accounts"`
+}
+
+// ReadyPayload is sent with the ready message once a client is in a room
+type ReadyPayload struct {
+	CrisisKeywords []string `json:"crisis_keywords,omitempty"`
+}
@@ -1,8 +1,10 @@
 package dto
 
-// Message is the DTO for WebSocket signaling messages
+// Message is the DTO for WebSocket signaling messages. ID is optional and
+// chosen by the client; errors about the message echo it as ref.
 type Message struct {
 	Type     string      `json:"type"`
+	ID       string      `json:"id,omitempty"`
 	From     string      `json:"from,omitempty"`
 	To       string      `json:"to,omitempty"`
 	RoomID   string      `json:"roomId,omitempty"`
@@ -16,20 +18,120 @@ type SDPMessage struct {
 	SDP  string `json:"sdp"`
 }
 
-// ICECandidateMessage represents an ICE candidate
+// ICECandidateMessage represents an ICE candidate. An empty candidate
+// signals the end of candidates.
 type ICECandidateMessage struct {
-	Candidate     string `json:"candidate"`
-	SDPMid        string `json:"sdpMid"`
-	SDPMLineIndex int    `json:"sdpMLineIndex"`
+	Candidate        string `json:"candidate"`
+	SDPMid           string `json:"sdpMid"`
+	SDPMLineIndex    int    `json:"sdpMLineIndex"`
+	UsernameFragment string `json:"usernameFragment,omitempty"`
+}
+
+// ErrorPayload tells a client why the server did not act on its message
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Ref     string `json:"ref,omitempty"` // id of the message the error is about
+}
+
+// HelloPayload opens a connection: the protocol versions and optional
+// capabilities the client supports
+type HelloPayload struct {
+	Versions     []int    `json:"versions"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// HelloReplyPayload is the server's answer to hello: the version it picked,
+// the capabilities both sides support and the limits messages must keep to
+type HelloReplyPayload struct {
+	Version      int             `json:"version"`
+	Capabilities []string        `json:"capabilities"`
+	Limits       SignalingLimits `json:"limits"`
+}
+
+// SignalingLimits are the sizes, in bytes, above which messages are rejected
+type SignalingLimits struct {
+	MaxMessageSize   int64 `json:"max_message_size"`
+	MaxSDPSize       int   `json:"max_sdp_size"`
+	MaxCandidateSize int   `json:"max_candidate_size"`
+}
+
+// JoinPayload tells each peer its part in the perfect negotiation pattern:
+// the initiator makes the first offer and is impolite, so its offer wins a
+// collision, while the polite peer answers instead
+type JoinPayload struct {
+	Initiator bool `json:"initiator"`
+	Polite    bool `json:"polite"`
+}
+
+// ReportPayload reports the peer of the current call to the moderators.
+// With Block set the two are never matched again and the call ends.
+type ReportPayload struct {
+	Reason string `json:"reason"`
+	Block  bool   `json:"block,omitempty"`
+}
+
+// ServerShutdownPayload tells clients the server is going away and when to reconnect
+type ServerShutdownPayload struct {
+	Reconnect  bool `json:"reconnect"`
+	RetryAfter int  `json:"retry_after"` // seconds to wait before reconnecting
+	CloseIn    int  `json:"close_in"`    // seconds until this connection is closed
+}
+
+// AdminNoticePayload tells a client why a moderator ended its room or connection
+type AdminNoticePayload struct {
+	Reason string `json:"reason,omitempty"`
 }
 
 // MessageType constants for signaling
 const (
+	MessageTypeHello     = "hello"
 	MessageTypeOffer     = "offer"
 	MessageTypeAnswer    = "answer"
 	MessageTypeCandidate = "candidate"
 	MessageTypeJoin      = "join"
 	MessageTypeLeave     = "leave"
+	MessageTypeReport    = "report"
 	MessageTypeReady     = "ready"
 	MessageTypeError     = "error"
+
+	MessageTypeServerShutdown = "server-shutdown"
+	MessageTypeRoomClosed     = "room-closed"
+	MessageTypeKicked         = "kicked"
+	MessageTypeMatchTimeout   = "match-timeout"
+)
+
+// Error codes sent in ErrorPayload
+const (
+	ErrorCodeInvalidMessage   = "invalid-message"
+	ErrorCodeUnknownType      = "unknown-type"
+	ErrorCodeInvalidPayload   = "invalid-payload"
+	ErrorCodePayloadTooLarge  = "payload-too-large"
+	ErrorCodeInvalidSDP       = "invalid-sdp"
+	ErrorCodeInvalidCandidate = "invalid-candidate"
+	ErrorCodeNoPeer           = "no-peer"
+	ErrorCodeGlare            = "glare"
+	ErrorCodeOutOfOrder       = "out-of-order"
+	ErrorCodeServerDraining   = "server-draining"
+	ErrorCodeMatchFailed      = "match-failed"
+	ErrorCodeSignInRequired   = "sign-in-required"
+	ErrorCodeReportFailed     = "report-failed"
+
+	ErrorCodeUpgradeRequired    = "upgrade-required"
+	ErrorCodeUnsupportedVersion = "unsupported-version"
+)
+
+// Signaling protocol versions. Version 1 is the protocol from before hello
+// existed and is assumed for clients that do not send one.
+const (
+	ProtocolVersion1      = 1
+	ProtocolVersion2      = 2
+	LatestProtocolVersion = ProtocolVersion2
+)
+
+// Capabilities a client may ask for in hello
+const (
+	CapabilityChat   = "chat"
+	CapabilityResume = "resume"
+	CapabilitySFU    = "sfu"
 )
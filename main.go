@@ -1,12 +1,11 @@
 package main
 
 import (
-	"projectwebcurhat/config"
-	"projectwebcurhat/config/server"
+	"os"
+
+	"projectwebcurhat/cli"
 )
 
 func main() {
-	config.Load()
-	server.Run()
+	os.Exit(cli.Run(os.Args[1:]))
 }
-
@@ -0,0 +1,14 @@
+package metrics
+
+import "time"
+
+// Noop discards every event, used when METRICS_ENABLED=false
+type Noop struct{}
+
+func (Noop) ClientMatched(time.Duration) {}
+func (Noop) CallEnded(time.Duration)     {}
+func (Noop) MessageRelayed(string)       {}
+func (Noop) MessageDropped(string)       {}
+func (Noop) MessageRejected(string)      {}
+func (Noop) UpgradeFailed(string)        {}
+func (Noop) AuthAttempt(string, bool)    {}
@@ -0,0 +1,254 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const namespace = "webcurhat"
+
+// Prometheus implements contract.Metrics on its own registry, next to the Go
+// runtime and process collectors
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	matchLatency  prometheus.Histogram
+	callDuration  prometheus.Histogram
+	relayed       *prometheus.CounterVec
+	dropped       *prometheus.CounterVec
+	rejected      *prometheus.CounterVec
+	upgradeFailed *prometheus.CounterVec
+	auth          *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	dbDuration    *prometheus.HistogramVec
+}
+
+func NewPrometheus() *Prometheus {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory := promauto.With(registry)
+
+	return &Prometheus{
+		registry: registry,
+		matchLatency: factory.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "match_latency_seconds",
+			Help:      "Time a client waited in the queue before being matched.",
+			Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+		}),
+		callDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "call_duration_seconds",
+			Help:      "Time from match until the first participant left.",
+			Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+		}),
+		relayed: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_relayed_total",
+			Help:      "Signaling messages relayed to a peer, by message type.",
+		}, []string{"type"}),
+		dropped: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_dropped_total",
+			Help:      "Outbound messages that could not be delivered, by reason.",
+		}, []string{"reason"}),
+		rejected: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_rejected_total",
+			Help:      "Inbound signaling messages answered with an error, by error code.",
+		}, []string{"code"}),
+		upgradeFailed: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "websocket_upgrade_failures_total",
+			Help:      "Rejected or failed WebSocket upgrades, by reason.",
+		}, []string{"reason"}),
+		auth: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_attempts_total",
+			Help:      "Authentication attempts by method and result.",
+		}, []string{"method", "result"}),
+		httpDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dbDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Database query latency by operation and table.",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}, []string{"operation", "table", "result"}),
+	}
+}
+
+func (p *Prometheus) ClientMatched(wait time.Duration) {
+	p.matchLatency.Observe(wait.Seconds())
+}
+
+func (p *Prometheus) CallEnded(duration time.Duration) {
+	p.callDuration.Observe(duration.Seconds())
+}
+
+func (p *Prometheus) MessageRelayed(msgType string) {
+	p.relayed.WithLabelValues(msgType).Inc()
+}
+
+func (p *Prometheus) MessageDropped(reason string) {
+	p.dropped.WithLabelValues(reason).Inc()
+}
+
+func (p *Prometheus) MessageRejected(code string) {
+	p.rejected.WithLabelValues(code).Inc()
+}
+
+func (p *Prometheus) UpgradeFailed(reason string) {
+	p.upgradeFailed.WithLabelValues(reason).Inc()
+}
+
+func (p *Prometheus) AuthAttempt(method string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	p.auth.WithLabelValues(method, result).Inc()
+}
+
+// Handler serves the registry in the Prometheus text format
+func (p *Prometheus) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware records the latency of every request. Routes are labelled with
+// their pattern (/auth/sessions/:id) to keep the label set bounded.
+func (p *Prometheus) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		p.httpDuration.WithLabelValues(ctx.Request.Method, route, strconv.Itoa(ctx.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveService exposes connection and room gauges, read from the services
+// on every scrape
+func (p *Prometheus) ObserveService(serv *contract.Service) {
+	p.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_connections",
+			Help:      "WebSocket clients connected to this replica.",
+		}, func() float64 {
+			return float64(serv.Signaling.LocalClientCount())
+		}),
+		&roomCollector{room: serv.Room},
+	)
+}
+
+// InstrumentDB times every gorm operation and exports the connection pool stats
+func (p *Prometheus) InstrumentDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if err := p.registry.Register(collectors.NewDBStatsCollector(sqlDB, namespace)); err != nil {
+		return err
+	}
+	return registerGormCallbacks(db, p.dbDuration)
+}
+
+var (
+	roomsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rooms"),
+		"Rooms by state: waiting for a partner, negotiating the call or connected.",
+		[]string{"state"}, nil,
+	)
+	waitingQueueDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "waiting_queue_length"),
+		"Clients waiting to be matched.",
+		nil, nil,
+	)
+)
+
+// roomCollector reads room counts once per scrape, which with the redis
+// store is one pipeline for the states and one call for the queue
+type roomCollector struct {
+	room contract.RoomService
+}
+
+func (c *roomCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomsDesc
+	ch <- waitingQueueDesc
+}
+
+func (c *roomCollector) Collect(ch chan<- prometheus.Metric) {
+	// Scrapes carry no context; the room store bounds its own calls
+	ctx := context.Background()
+	counts := c.room.CountRoomsByState(ctx)
+	for _, state := range []string{database.RoomStateWaiting, database.RoomStateNegotiating, database.RoomStateConnected} {
+		ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(counts[state]), state)
+	}
+	ch <- prometheus.MustNewConstMetric(waitingQueueDesc, prometheus.GaugeValue, float64(c.room.GetWaitingCount(ctx)))
+}
+
+const gormStartKey = "metrics:start"
+
+func registerGormCallbacks(db *gorm.DB, duration *prometheus.HistogramVec) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(gormStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			value, ok := tx.InstanceGet(gormStartKey)
+			if !ok {
+				return
+			}
+			start, ok := value.(time.Time)
+			if !ok {
+				return
+			}
+
+			result := "ok"
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				result = "error"
+			}
+			duration.WithLabelValues(operation, tx.Statement.Table, result).Observe(time.Since(start).Seconds())
+		}
+	}
+
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("metrics:before_create", before),
+		callback.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		callback.Query().Before("gorm:query").Register("metrics:before_query", before),
+		callback.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		callback.Update().Before("gorm:update").Register("metrics:before_update", before),
+		callback.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		callback.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		callback.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		callback.Row().Before("gorm:row").Register("metrics:before_row", before),
+		callback.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		callback.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		callback.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	)
+}
@@ -0,0 +1,70 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+func scrape(t *testing.T, s *testutil.Server, authorization string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("scraping: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the scrape: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsNeedTheToken(t *testing.T) {
+	s := testutil.NewServer(t, map[string]string{
+		"metrics.enabled": "true",
+		"metrics.token":   "scrape-secret",
+	})
+
+	for _, authorization := range []string{"", "Bearer wrong", "scrape-secret"} {
+		if status, _ := scrape(t, s, authorization); status != http.StatusUnauthorized {
+			t.Fatalf("scrape with Authorization %q answered %d, want 401", authorization, status)
+		}
+	}
+	if status, _ := scrape(t, s, "Bearer scrape-secret"); status != http.StatusOK {
+		t.Fatalf("scrape with the token answered %d", status)
+	}
+}
+
+func TestRoomsAreCountedByState(t *testing.T) {
+	s := testutil.NewServer(t, map[string]string{"metrics.enabled": "true"})
+
+	client := s.Dial("")
+	client.Send(dto.Message{Type: dto.MessageTypeJoin})
+	client.Expect(dto.MessageTypeReady)
+
+	_, body := scrape(t, s, "")
+	for _, want := range []string{
+		`webcurhat_rooms{state="waiting"} 1`,
+		`webcurhat_rooms{state="negotiating"} 0`,
+		`webcurhat_rooms{state="connected"} 0`,
+		`webcurhat_waiting_queue_length 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics lack %s:\n%s", want, body)
+		}
+	}
+}
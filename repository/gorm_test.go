@@ -0,0 +1,103 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"projectwebcurhat/config"
+	dbConfig "projectwebcurhat/config/database"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/repository"
+)
+
+// postgresURLEnv names a Postgres database the suite may wipe. The Postgres
+// run is skipped without it.
+const postgresURLEnv = "TEST_POSTGRES_URL"
+
+// TestGormRepositories runs the repository suite on every SQL dialect, on a
+// schema built by the migrations like in production
+func TestGormRepositories(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testRepositories(t, func(t *testing.T) *contract.Repository {
+			return openRepository(t, "sqlite::memory:")
+		})
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv(postgresURLEnv)
+		if url == "" {
+			t.Skip(postgresURLEnv + " is not set")
+		}
+		testRepositories(t, func(t *testing.T) *contract.Repository {
+			return openRepository(t, url)
+		})
+	})
+}
+
+// openRepository connects to url, migrates it and empties every table
+func openRepository(t *testing.T, url string) *contract.Repository {
+	t.Helper()
+
+	if err := config.Load(config.Sources{Flags: map[string]string{"database.url": url, "log.level": "warn"}}); err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+	db, sqlDB, err := dbConfig.ConnectDB()
+	if err != nil {
+		t.Fatalf("connecting to %s: %v", config.Get().Database.Dialect(), err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := database.RunMigration(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	// Children first, for the foreign keys
+	for _, table := range []string{"sessions", "user_identities", "user_blocks", "reports", "settings", "oauth_states", "guest_challenges", "users"} {
+		if err := db.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatalf("emptying %s: %v", table, err)
+		}
+	}
+
+	repo, err := repository.New(db, nil, "memory")
+	if err != nil {
+		t.Fatalf("creating repositories: %v", err)
+	}
+	return repo
+}
+
+func TestGormRepositoriesStopWhenCancelled(t *testing.T) {
+	repo := openRepository(t, "sqlite::memory:")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"CreateUser": func() error {
+			_, err := repo.User.CreateUser(ctx, &database.User{Username: "alice", Email: "alice@example.test", Password: "hash"})
+			return err
+		},
+		"GetUserByEmail": func() error {
+			_, err := repo.User.GetUserByEmail(ctx, "alice@example.test")
+			return err
+		},
+		"GetSession": func() error {
+			_, err := repo.Session.GetSession(ctx, "session")
+			return err
+		},
+		"ListSettings": func() error {
+			_, err := repo.Settings.ListSettings(ctx)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want %v", name, err, context.Canceled)
+		}
+	}
+
+	// Nothing was written
+	if _, err := repo.User.GetUserByEmail(context.Background(), "alice@example.test"); err == nil {
+		t.Fatal("user was created with a cancelled context")
+	}
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+)
+
+// guestChallengeRepository keeps proof-of-work challenges in the database,
+// which every replica shares
+type guestChallengeRepository struct {
+	db *gorm.DB
+}
+
+func NewGuestChallengeRepository(db *gorm.DB) *guestChallengeRepository {
+	return &guestChallengeRepository{db: db}
+}
+
+func (r *guestChallengeRepository) SaveChallenge(ctx context.Context, challenge *database.GuestChallenge) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Drop unsolved challenges so the table does not grow forever
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&database.GuestChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(challenge).Error
+	})
+}
+
+// TakeChallenge reads the challenge and deletes it. Only the caller whose
+// delete removed the row gets it, so a solution cannot be replayed on
+// another replica.
+func (r *guestChallengeRepository) TakeChallenge(ctx context.Context, challenge string) (*database.GuestChallenge, error) {
+	db := primary(r.db.WithContext(ctx))
+
+	var pending database.GuestChallenge
+	err := db.Where("challenge = ?", challenge).First(&pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := db.Where("challenge = ?", challenge).Delete(&database.GuestChallenge{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(pending.ExpiresAt) {
+		return nil, nil
+	}
+	return &pending, nil
+}
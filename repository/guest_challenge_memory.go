@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+)
+
+// memoryGuestChallengeRepository keeps challenges in memory, for tests and
+// local runs with a single replica
+type memoryGuestChallengeRepository struct {
+	challenges map[string]*database.GuestChallenge
+	mutex      sync.Mutex
+}
+
+func NewMemoryGuestChallengeRepository() *memoryGuestChallengeRepository {
+	return &memoryGuestChallengeRepository{
+		challenges: make(map[string]*database.GuestChallenge),
+	}
+}
+
+func (r *memoryGuestChallengeRepository) SaveChallenge(ctx context.Context, challenge *database.GuestChallenge) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for key, c := range r.challenges {
+		if now.After(c.ExpiresAt) {
+			delete(r.challenges, key)
+		}
+	}
+
+	r.challenges[challenge.Challenge] = challenge
+	return nil
+}
+
+// TakeChallenge returns the challenge and removes it, so a solution cannot be replayed
+func (r *memoryGuestChallengeRepository) TakeChallenge(ctx context.Context, challenge string) (*database.GuestChallenge, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c, exists := r.challenges[challenge]
+	if !exists {
+		return nil, nil
+	}
+	delete(r.challenges, challenge)
+
+	if time.Now().After(c.ExpiresAt) {
+		return nil, nil
+	}
+	return c, nil
+}
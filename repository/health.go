@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type healthRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewHealthRepository(db *gorm.DB, rdb *redis.Client) *healthRepository {
+	return &healthRepository{db: db, rdb: rdb}
+}
+
+func (r *healthRepository) PingDatabase(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (r *healthRepository) PingRedis(ctx context.Context) error {
+	if r.rdb == nil {
+		return errors.New("redis is not configured")
+	}
+	return r.rdb.Ping(ctx).Err()
+}
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+// memoryHealthRepository belongs to the in-memory repositories, whose
+// "database" is always up
+type memoryHealthRepository struct{}
+
+func NewMemoryHealthRepository() memoryHealthRepository {
+	return memoryHealthRepository{}
+}
+
+func (memoryHealthRepository) PingDatabase(ctx context.Context) error {
+	return nil
+}
+
+func (memoryHealthRepository) PingRedis(ctx context.Context) error {
+	return errors.New("redis is not configured")
+}
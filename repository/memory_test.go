@@ -0,0 +1,53 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/repository"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) *contract.Repository {
+		return repository.NewMemory()
+	})
+}
+
+func TestMemoryRoomRepository(t *testing.T) {
+	testRoomRepository(t, func(t *testing.T) roomStore {
+		// One process holds every room, whichever replica asks
+		repo := repository.NewRoomRepository()
+		return func(string) contract.RoomRepository { return repo }
+	})
+}
+
+// Clients of another replica have no send channel, and their rooms are
+// still in use while a dead node is cleaned up
+func TestMemoryRemoveNodeClientsWithoutSocket(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRoomRepository()
+
+	local := newClient("local")
+	room := match(t, repo, local)
+	match(t, repo, &database.Client{ID: "remote", NodeID: "dead"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			room.GetClientCount()
+			room.AddClient(newClient("late"))
+			room.RemoveClient("late")
+		}
+	}()
+	if removed := repo.RemoveNodeClients(ctx, "dead"); removed != 1 {
+		t.Fatalf("removed %d clients, want 1", removed)
+	}
+	<-done
+
+	if !local.Enqueue([]byte("{}")) {
+		t.Fatal("the local client's send channel was closed")
+	}
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type moderationRepository struct {
+	db *gorm.DB
+}
+
+func NewModerationRepository(db *gorm.DB) *moderationRepository {
+	return &moderationRepository{db: db}
+}
+
+func (r *moderationRepository) BlockUser(ctx context.Context, blockerID, blockedID int) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&database.UserBlock{BlockerID: blockerID, BlockedID: blockedID}).Error
+}
+
+// BlockedUserIDs reads from the primary, so a block takes effect on the
+// very next match
+func (r *moderationRepository) BlockedUserIDs(ctx context.Context, userID int) ([]int, error) {
+	var blocks []database.UserBlock
+	err := primary(r.db.WithContext(ctx)).
+		Where("blocker_id = ? OR blocked_id = ?", userID, userID).
+		Find(&blocks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(blocks))
+	for _, block := range blocks {
+		if block.BlockerID == userID {
+			ids = append(ids, block.BlockedID)
+		} else {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *moderationRepository) CreateReport(ctx context.Context, report *database.Report) error {
+	return r.db.WithContext(ctx).Create(report).Error
+}
+
+func (r *moderationRepository) ListReports(ctx context.Context, limit int) ([]database.Report, error) {
+	var reports []database.Report
+	err := r.db.WithContext(ctx).Order("created_at DESC, id DESC").Limit(limit).Find(&reports).Error
+	return reports, err
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+)
+
+// memoryModerationRepository keeps blocks and reports in memory, for tests
+// and local runs with a single replica
+type memoryModerationRepository struct {
+	blocks  []database.UserBlock
+	reports []database.Report
+	mutex   sync.RWMutex
+}
+
+func NewMemoryModerationRepository() *memoryModerationRepository {
+	return &memoryModerationRepository{}
+}
+
+func (r *memoryModerationRepository) BlockUser(ctx context.Context, blockerID, blockedID int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !slices.ContainsFunc(r.blocks, func(block database.UserBlock) bool {
+		return block.BlockerID == blockerID && block.BlockedID == blockedID
+	}) {
+		r.blocks = append(r.blocks, database.UserBlock{BlockerID: blockerID, BlockedID: blockedID, CreatedAt: time.Now()})
+	}
+	return nil
+}
+
+func (r *memoryModerationRepository) BlockedUserIDs(ctx context.Context, userID int) ([]int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	ids := make([]int, 0)
+	for _, block := range r.blocks {
+		switch userID {
+		case block.BlockerID:
+			ids = append(ids, block.BlockedID)
+		case block.BlockedID:
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *memoryModerationRepository) CreateReport(ctx context.Context, report *database.Report) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report.ID = len(r.reports) + 1
+	report.CreatedAt = time.Now()
+	r.reports = append(r.reports, *report)
+	return nil
+}
+
+func (r *memoryModerationRepository) ListReports(ctx context.Context, limit int) ([]database.Report, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	reports := make([]database.Report, 0, min(limit, len(r.reports)))
+	for i := len(r.reports) - 1; i >= 0 && len(reports) < limit; i-- {
+		reports = append(reports, r.reports[i])
+	}
+	return reports, nil
+}
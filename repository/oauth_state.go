@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+)
+
+// oauthStateRepository keeps login states in the database, which every
+// replica shares
+type oauthStateRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthStateRepository(db *gorm.DB) *oauthStateRepository {
+	return &oauthStateRepository{db: db}
+}
+
+func (r *oauthStateRepository) SaveState(ctx context.Context, state *database.OAuthState) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Drop abandoned login attempts so the table does not grow forever
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&database.OAuthState{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(state).Error
+	})
+}
+
+// TakeState reads the state and deletes it. Only the caller whose delete
+// removed the row gets it, so two replicas racing for a state cannot both win.
+func (r *oauthStateRepository) TakeState(ctx context.Context, state string) (*database.OAuthState, error) {
+	db := primary(r.db.WithContext(ctx))
+
+	var pending database.OAuthState
+	err := db.Where("state = ?", state).First(&pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := db.Where("state = ?", state).Delete(&database.OAuthState{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(pending.ExpiresAt) {
+		return nil, nil
+	}
+	return &pending, nil
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+)
+
+// memoryOAuthStateRepository keeps login states in memory, for tests and
+// local runs with a single replica
+type memoryOAuthStateRepository struct {
+	states map[string]*database.OAuthState
+	mutex  sync.Mutex
+}
+
+func NewMemoryOAuthStateRepository() *memoryOAuthStateRepository {
+	return &memoryOAuthStateRepository{
+		states: make(map[string]*database.OAuthState),
+	}
+}
+
+func (r *memoryOAuthStateRepository) SaveState(ctx context.Context, state *database.OAuthState) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Drop abandoned login attempts so the map does not grow forever
+	now := time.Now()
+	for key, s := range r.states {
+		if now.After(s.ExpiresAt) {
+			delete(r.states, key)
+		}
+	}
+
+	r.states[state.State] = state
+	return nil
+}
+
+// TakeState returns the pending state and removes it, so every state can be used only once
+func (r *memoryOAuthStateRepository) TakeState(ctx context.Context, state string) (*database.OAuthState, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, exists := r.states[state]
+	if !exists {
+		return nil, nil
+	}
+	delete(r.states, state)
+
+	if time.Now().After(s.ExpiresAt) {
+		return nil, nil
+	}
+	return s, nil
+}
@@ -1,14 +1,57 @@
 package repository
 
 import (
+	"fmt"
+
+	"projectwebcurhat/config"
 	"projectwebcurhat/contract"
 
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
-func New(db *gorm.DB) *contract.Repository {
+// New wires the repositories. roomStore is matching.room_store: rooms live
+// in Redis with "redis", which lets several replicas match users with each
+// other, and in memory otherwise. rdb may be set for the bus alone.
+func New(db *gorm.DB, rdb *redis.Client, roomStore string) (*contract.Repository, error) {
+	var room contract.RoomRepository
+	switch roomStore {
+	case "redis":
+		if rdb == nil {
+			return nil, fmt.Errorf("redis room store requires a redis connection")
+		}
+		room = NewRedisRoomRepository(rdb, config.Get().Server.NodeID)
+	default:
+		room = NewRoomRepository()
+	}
+
+	return &contract.Repository{
+		Room:       room,
+		User:       NewUserRepository(db, config.Get().Database.QueryTimeout),
+		OAuthState: NewOAuthStateRepository(db),
+		Guest:      NewGuestChallengeRepository(db),
+		Moderation: NewModerationRepository(db),
+		Session:    NewSessionRepository(db),
+		Settings:   NewSettingsRepository(db),
+		Health:     NewHealthRepository(db, rdb),
+	}, nil
+}
+
+// NewMemory wires repositories that keep everything in process memory. The
+// integration harness in testutil uses them, so the whole server runs
+// without Postgres or Redis.
+func NewMemory() *contract.Repository {
+	user, session := NewMemoryUserRepository(), NewMemorySessionRepository()
+	user.sessions = session
+
 	return &contract.Repository{
-		Room: NewRoomRepository(),
-		User: NewUserRepository(db),
+		Room:       NewRoomRepository(),
+		User:       user,
+		OAuthState: NewMemoryOAuthStateRepository(),
+		Guest:      NewMemoryGuestChallengeRepository(),
+		Moderation: NewMemoryModerationRepository(),
+		Session:    session,
+		Settings:   NewMemorySettingsRepository(),
+		Health:     NewMemoryHealthRepository(),
 	}
 }
@@ -0,0 +1,39 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/repository"
+)
+
+func TestNewPicksTheConfiguredRoomStore(t *testing.T) {
+	if err := config.Load(config.Sources{}); err != nil {
+		t.Fatalf("loading the default configuration: %v", err)
+	}
+	server, rdb := newRedis(t)
+
+	// Redis connected for the bus only keeps rooms in memory
+	repo, err := repository.New(nil, rdb, "memory")
+	if err != nil {
+		t.Fatalf("creating repositories: %v", err)
+	}
+	match(t, repo.Room, newClient("memory"))
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("memory room store wrote to redis: %v", keys)
+	}
+
+	repo, err = repository.New(nil, rdb, "redis")
+	if err != nil {
+		t.Fatalf("creating repositories: %v", err)
+	}
+	match(t, repo.Room, newClient("redis"))
+	if count := repo.Room.GetRoomCount(context.Background()); count != 1 || len(server.Keys()) == 0 {
+		t.Fatalf("redis room store has %d rooms and keys %v", count, server.Keys())
+	}
+
+	if _, err := repository.New(nil, nil, "redis"); err == nil {
+		t.Fatal("redis room store without a redis connection")
+	}
+}
@@ -1,14 +1,20 @@
 package repository
 
 import (
+	"context"
 	"projectwebcurhat/database"
+	"slices"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 type roomRepository struct {
-	rooms       map[string]*database.Room
-	waitingRoom *database.Room
-	mutex       sync.RWMutex
+	rooms map[string]*database.Room
+	// waiting rooms in the order they were created. There is more than one
+	// only when the waiting clients have blocked each other.
+	waiting []*database.Room
+	mutex   sync.RWMutex
 }
 
 func NewRoomRepository() *roomRepository {
@@ -17,49 +23,144 @@ func NewRoomRepository() *roomRepository {
 	}
 }
 
-func (r *roomRepository) CreateRoom(id string) *database.Room {
-	room := database.NewRoom(id)
+func (r *roomRepository) MatchClient(ctx context.Context, client *database.Client) (*database.Room, error) {
 	r.mutex.Lock()
-	r.rooms[id] = room
-	r.mutex.Unlock()
-	return room
+	defer r.mutex.Unlock()
+
+	for i, room := range r.waiting {
+		if room.HasAnyUser(client.Blocked) || !room.AddClient(client) {
+			continue
+		}
+		if room.IsFull() {
+			r.waiting = slices.Delete(r.waiting, i, i+1)
+		}
+		return room, nil
+	}
+
+	room := database.NewRoom(uuid.New().String())
+	room.AddClient(client)
+	r.rooms[room.ID] = room
+	r.waiting = append(r.waiting, room)
+	return room, nil
 }
 
-func (r *roomRepository) GetRoom(roomID string) *database.Room {
+func (r *roomRepository) GetRoom(ctx context.Context, roomID string) *database.Room {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 	return r.rooms[roomID]
 }
 
-func (r *roomRepository) DeleteRoom(roomID string) {
+func (r *roomRepository) ListRooms(ctx context.Context) []*database.Room {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rooms := make([]*database.Room, 0, len(r.rooms))
+	for _, room := range r.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (r *roomRepository) SetRoomState(ctx context.Context, roomID, state string) {
+	r.mutex.RLock()
+	room := r.rooms[roomID]
+	r.mutex.RUnlock()
+
+	if room != nil {
+		room.Mutex.Lock()
+		room.State = state
+		room.Mutex.Unlock()
+	}
+}
+
+func (r *roomRepository) UpdateNegotiation(ctx context.Context, roomID string, update func(negotiation *database.Negotiation) error) error {
+	r.mutex.RLock()
+	room := r.rooms[roomID]
+	r.mutex.RUnlock()
+
+	if room == nil {
+		return nil
+	}
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	negotiation := room.Negotiation
+	if err := update(&negotiation); err != nil {
+		return err
+	}
+	room.Negotiation = negotiation
+	return nil
+}
+
+func (r *roomRepository) RemoveClient(ctx context.Context, roomID, clientID string) int {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	delete(r.rooms, roomID)
-	if r.waitingRoom != nil && r.waitingRoom.ID == roomID {
-		r.waitingRoom = nil
+
+	room := r.rooms[roomID]
+	if room == nil {
+		return 0
+	}
+
+	room.RemoveClient(clientID)
+	remaining := room.GetClientCount()
+	if remaining == 0 {
+		r.deleteRoomLocked(roomID)
 	}
+	return remaining
 }
 
-func (r *roomRepository) GetRoomCount() int {
+func (r *roomRepository) DeleteRoom(ctx context.Context, roomID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.deleteRoomLocked(roomID)
+}
+
+func (r *roomRepository) deleteRoomLocked(roomID string) {
+	delete(r.rooms, roomID)
+	r.waiting = slices.DeleteFunc(r.waiting, func(room *database.Room) bool { return room.ID == roomID })
+}
+
+func (r *roomRepository) GetRoomCount(ctx context.Context) int {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 	return len(r.rooms)
 }
 
-func (r *roomRepository) GetWaitingRoom() *database.Room {
+func (r *roomRepository) GetWaitingCount(ctx context.Context) int {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
-	return r.waitingRoom
+
+	return len(r.waiting)
 }
 
-func (r *roomRepository) SetWaitingRoom(room *database.Room) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	r.waitingRoom = room
+func (r *roomRepository) CountRoomsByState(ctx context.Context) map[string]int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, room := range r.rooms {
+		counts[room.GetState()]++
+	}
+	return counts
 }
 
-func (r *roomRepository) StoreRoom(room *database.Room) {
+func (r *roomRepository) RemoveNodeClients(ctx context.Context, nodeID string) int {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	r.rooms[room.ID] = room
+
+	removed := 0
+	for roomID, room := range r.rooms {
+		// A snapshot, since the room's clients change under its own lock
+		for _, client := range room.GetClients() {
+			if client.NodeID == nodeID {
+				room.RemoveClient(client.ID)
+				removed++
+			}
+		}
+		if room.IsEmpty() {
+			r.deleteRoomLocked(roomID)
+		}
+	}
+	return removed
 }
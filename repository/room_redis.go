@@ -0,0 +1,496 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// All keys share the {webcurhat} hash tag so the Lua scripts stay valid on Redis Cluster
+const (
+	redisKeyPrefix   = "{webcurhat}:"
+	redisRoomsKey    = redisKeyPrefix + "rooms"
+	redisWaitingKey  = redisKeyPrefix + "waiting"
+	redisRoomKeyBase = redisKeyPrefix + "room:"
+	redisRoomSize    = 2
+	redisOpTimeout   = 2 * time.Second
+	// redisTxRetries bounds optimistic transactions that keep losing to other replicas
+	redisTxRetries = 5
+)
+
+// matchScript pops waiting rooms until it finds one with space, skipping rooms
+// that were emptied in the meantime. Rooms with a blocked user are put back
+// in their place for others. When none is left it creates a new room and
+// queues it. Runs atomically, so two replicas can never fill the same seat.
+//
+// KEYS: waiting list, rooms set
+// ARGV: room key prefix, new room ID, client ID, member JSON, room size,
+// JSON array of blocked user IDs
+var matchScript = redis.NewScript(`
+local size = tonumber(ARGV[5])
+local blocked = {}
+for _, userID in ipairs(cjson.decode(ARGV[6])) do
+	blocked[userID] = true
+end
+
+local function hasBlocked(key)
+	for _, raw in ipairs(redis.call('HVALS', key)) do
+		local userID = cjson.decode(raw).user_id
+		if userID and blocked[userID] then
+			return true
+		end
+	end
+	return false
+end
+
+local skipped = {}
+local function requeue()
+	for i = #skipped, 1, -1 do
+		redis.call('LPUSH', KEYS[1], skipped[i])
+	end
+end
+
+while true do
+	local roomID = redis.call('LPOP', KEYS[1])
+	if not roomID then
+		break
+	end
+	local key = ARGV[1] .. roomID
+	local count = redis.call('HLEN', key)
+	if count > 0 and count < size then
+		if hasBlocked(key) then
+			table.insert(skipped, roomID)
+		else
+			redis.call('HSET', key, ARGV[3], ARGV[4])
+			if count + 1 < size then
+				redis.call('LPUSH', KEYS[1], roomID)
+			end
+			requeue()
+			return roomID
+		end
+	end
+end
+requeue()
+redis.call('HSET', ARGV[1] .. ARGV[2], ARGV[3], ARGV[4])
+redis.call('SADD', KEYS[2], ARGV[2])
+redis.call('RPUSH', KEYS[1], ARGV[2])
+return ARGV[2]
+`)
+
+// removeScript drops one member and cleans up the room when it becomes empty.
+//
+// KEYS: room hash, waiting list, rooms set, room state, room negotiation
+// ARGV: client ID, room ID
+var removeScript = redis.NewScript(`
+redis.call('HDEL', KEYS[1], ARGV[1])
+local count = redis.call('HLEN', KEYS[1])
+if count == 0 then
+	redis.call('DEL', KEYS[1], KEYS[4], KEYS[5])
+	redis.call('LREM', KEYS[2], 0, ARGV[2])
+	redis.call('SREM', KEYS[3], ARGV[2])
+end
+return count
+`)
+
+// redisRoomMember is the part of a client that other replicas need to know
+type redisRoomMember struct {
+	ClientID    string    `json:"client_id"`
+	Username    string    `json:"username"`
+	UserID      int       `json:"user_id,omitempty"`
+	IsGuest     bool      `json:"is_guest,omitempty"`
+	NodeID      string    `json:"node_id"`
+	ConnectedAt time.Time `json:"connected_at"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// redisRoomRepository keeps room membership and the waiting queue in Redis so
+// every replica sees the same rooms. Clients connected to this replica are
+// also tracked locally, because their sockets cannot be shared.
+type redisRoomRepository struct {
+	rdb    *redis.Client
+	nodeID string
+	local  map[string]*database.Client
+	mutex  sync.RWMutex
+}
+
+func NewRedisRoomRepository(rdb *redis.Client, nodeID string) *redisRoomRepository {
+	return &redisRoomRepository{
+		rdb:    rdb,
+		nodeID: nodeID,
+		local:  make(map[string]*database.Client),
+	}
+}
+
+func (r *redisRoomRepository) MatchClient(ctx context.Context, client *database.Client) (*database.Room, error) {
+	member, err := json.Marshal(redisRoomMember{
+		ClientID:    client.ID,
+		Username:    client.Username,
+		UserID:      client.UserID,
+		IsGuest:     client.IsGuest,
+		NodeID:      r.nodeID,
+		ConnectedAt: client.ConnectedAt,
+		JoinedAt:    client.JoinedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding room member: %w", err)
+	}
+	// An empty array, not null, for the script
+	blocked, err := json.Marshal(append([]int{}, client.Blocked...))
+	if err != nil {
+		return nil, fmt.Errorf("encoding blocked users: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	roomID, err := matchScript.Run(ctx, r.rdb,
+		[]string{redisWaitingKey, redisRoomsKey},
+		redisRoomKeyBase, uuid.New().String(), client.ID, member, redisRoomSize, blocked,
+	).Text()
+	if err != nil {
+		return nil, fmt.Errorf("matching client in redis: %w", err)
+	}
+
+	client.SetRoomID(roomID)
+	r.mutex.Lock()
+	r.local[client.ID] = client
+	r.mutex.Unlock()
+
+	room, err := r.loadRoom(ctx, roomID)
+	// Emptied in between, e.g. by a replica that took this one for dead
+	if err == nil && room == nil {
+		err = fmt.Errorf("room %s was removed right after matching", roomID)
+	}
+	if err != nil {
+		r.abandonMatch(ctx, roomID, client)
+		return nil, err
+	}
+	return room, nil
+}
+
+// abandonMatch takes the client back out of a room it was matched into but
+// will not be told about, so nobody waits for it there. The client stays
+// open, since the caller still reports the failure to it.
+func (r *redisRoomRepository) abandonMatch(ctx context.Context, roomID string, client *database.Client) {
+	// ctx may be what failed the match
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisOpTimeout)
+	defer cancel()
+
+	if err := removeScript.Run(ctx, r.rdb, removeKeys(roomID), client.ID, roomID).Err(); err != nil {
+		slog.Error("Error undoing a failed match in redis", "client_id", client.ID, "room_id", roomID, "error", err)
+	}
+
+	client.SetRoomID("")
+	r.mutex.Lock()
+	delete(r.local, client.ID)
+	r.mutex.Unlock()
+}
+
+func (r *redisRoomRepository) GetRoom(ctx context.Context, roomID string) *database.Room {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	room, err := r.loadRoom(ctx, roomID)
+	if err != nil {
+		slog.Error("Error loading room from redis", "room_id", roomID, "error", err)
+	}
+	return room
+}
+
+// ListRooms loads every room in one pipeline. Rooms that disappear between
+// listing and loading are skipped.
+func (r *redisRoomRepository) ListRooms(ctx context.Context) []*database.Room {
+	ctx, cancel := context.WithTimeout(ctx, 4*redisOpTimeout)
+	defer cancel()
+
+	roomIDs, err := r.rdb.SMembers(ctx, redisRoomsKey).Result()
+	if err != nil {
+		slog.Error("Error listing rooms in redis", "error", err)
+		return nil
+	}
+
+	pipe := r.rdb.Pipeline()
+	members := make([]*redis.MapStringStringCmd, len(roomIDs))
+	states := make([]*redis.StringCmd, len(roomIDs))
+	for i, roomID := range roomIDs {
+		members[i] = pipe.HGetAll(ctx, redisRoomKeyBase+roomID)
+		states[i] = pipe.Get(ctx, roomStateKey(roomID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		slog.Error("Error loading rooms from redis", "error", err)
+		return nil
+	}
+
+	rooms := make([]*database.Room, 0, len(roomIDs))
+	for i, roomID := range roomIDs {
+		if room := r.buildRoom(roomID, members[i].Val(), states[i].Val()); room != nil {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+func (r *redisRoomRepository) SetRoomState(ctx context.Context, roomID, state string) {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	if err := r.rdb.Set(ctx, roomStateKey(roomID), state, 0).Err(); err != nil {
+		slog.Error("Error saving room state in redis", "room_id", roomID, "error", err)
+	}
+}
+
+// UpdateNegotiation reads and writes the state in an optimistic transaction,
+// which is retried when another replica touched the room in between. The
+// room hash is watched as well, so the state is not written back for a room
+// that was emptied meanwhile.
+func (r *redisRoomRepository) UpdateNegotiation(ctx context.Context, roomID string, update func(negotiation *database.Negotiation) error) error {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	roomKey, key := redisRoomKeyBase+roomID, negotiationKey(roomID)
+	transaction := func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, roomKey).Result()
+		if err != nil || exists == 0 {
+			return err
+		}
+
+		var negotiation database.Negotiation
+		raw, err := tx.Get(ctx, key).Bytes()
+		if err == nil {
+			err = json.Unmarshal(raw, &negotiation)
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		if err := update(&negotiation); err != nil {
+			return err
+		}
+		data, err := json.Marshal(negotiation)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			return nil
+		})
+		return err
+	}
+
+	for range redisTxRetries {
+		err := r.rdb.Watch(ctx, transaction, roomKey, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
+
+func (r *redisRoomRepository) RemoveClient(ctx context.Context, roomID, clientID string) int {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	remaining, err := removeScript.Run(ctx, r.rdb, removeKeys(roomID), clientID, roomID).Int()
+	if err != nil {
+		slog.Error("Error removing client from room in redis", "client_id", clientID, "room_id", roomID, "error", err)
+	}
+
+	// Same contract as Room.RemoveClient: the client's send channel is closed
+	r.mutex.Lock()
+	if client, exists := r.local[clientID]; exists {
+		client.CloseSend()
+		delete(r.local, clientID)
+	}
+	r.mutex.Unlock()
+
+	return remaining
+}
+
+func (r *redisRoomRepository) DeleteRoom(ctx context.Context, roomID string) {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	pipe := r.rdb.TxPipeline()
+	pipe.Del(ctx, redisRoomKeyBase+roomID, roomStateKey(roomID), negotiationKey(roomID))
+	pipe.LRem(ctx, redisWaitingKey, 0, roomID)
+	pipe.SRem(ctx, redisRoomsKey, roomID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Error deleting room in redis", "room_id", roomID, "error", err)
+	}
+
+	r.mutex.Lock()
+	for id, client := range r.local {
+		if client.RoomID() == roomID {
+			delete(r.local, id)
+		}
+	}
+	r.mutex.Unlock()
+}
+
+func (r *redisRoomRepository) GetRoomCount(ctx context.Context) int {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	count, err := r.rdb.SCard(ctx, redisRoomsKey).Result()
+	if err != nil {
+		slog.Error("Error counting rooms in redis", "error", err)
+		return 0
+	}
+	return int(count)
+}
+
+func (r *redisRoomRepository) GetWaitingCount(ctx context.Context) int {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+
+	count, err := r.rdb.LLen(ctx, redisWaitingKey).Result()
+	if err != nil {
+		slog.Error("Error counting waiting rooms in redis", "error", err)
+		return 0
+	}
+	return int(count)
+}
+
+// CountRoomsByState reads the member count and recorded state of every room
+// in one pipeline, without loading the members themselves
+func (r *redisRoomRepository) CountRoomsByState(ctx context.Context) map[string]int {
+	ctx, cancel := context.WithTimeout(ctx, 4*redisOpTimeout)
+	defer cancel()
+
+	counts := make(map[string]int)
+	roomIDs, err := r.rdb.SMembers(ctx, redisRoomsKey).Result()
+	if err != nil {
+		slog.Error("Error listing rooms in redis", "error", err)
+		return counts
+	}
+
+	pipe := r.rdb.Pipeline()
+	sizes := make([]*redis.IntCmd, len(roomIDs))
+	states := make([]*redis.StringCmd, len(roomIDs))
+	for i, roomID := range roomIDs {
+		sizes[i] = pipe.HLen(ctx, redisRoomKeyBase+roomID)
+		states[i] = pipe.Get(ctx, roomStateKey(roomID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		slog.Error("Error counting rooms in redis", "error", err)
+		return counts
+	}
+
+	for i := range roomIDs {
+		// A room emptied between the two reads is gone, not waiting
+		if size := sizes[i].Val(); size > 0 {
+			counts[database.RoomStateOf(int(size), states[i].Val())]++
+		}
+	}
+	return counts
+}
+
+// RemoveNodeClients scans all rooms for members of a dead replica. Every
+// surviving replica may run this at the same time; removal is idempotent.
+func (r *redisRoomRepository) RemoveNodeClients(ctx context.Context, nodeID string) int {
+	ctx, cancel := context.WithTimeout(ctx, 4*redisOpTimeout)
+	defer cancel()
+
+	roomIDs, err := r.rdb.SMembers(ctx, redisRoomsKey).Result()
+	if err != nil {
+		slog.Error("Error listing rooms in redis", "error", err)
+		return 0
+	}
+
+	removed := 0
+	for _, roomID := range roomIDs {
+		members, err := r.rdb.HGetAll(ctx, redisRoomKeyBase+roomID).Result()
+		if err != nil {
+			slog.Error("Error loading room from redis", "room_id", roomID, "error", err)
+			continue
+		}
+
+		for clientID, raw := range members {
+			var member redisRoomMember
+			if err := json.Unmarshal([]byte(raw), &member); err != nil || member.NodeID != nodeID {
+				continue
+			}
+			if err := removeScript.Run(ctx, r.rdb, removeKeys(roomID), clientID, roomID).Err(); err != nil {
+				slog.Error("Error removing client from room in redis", "client_id", clientID, "room_id", roomID, "error", err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// loadRoom rebuilds a Room snapshot from Redis. It is nil when the room does not exist.
+func (r *redisRoomRepository) loadRoom(ctx context.Context, roomID string) (*database.Room, error) {
+	pipe := r.rdb.Pipeline()
+	members := pipe.HGetAll(ctx, redisRoomKeyBase+roomID)
+	state := pipe.Get(ctx, roomStateKey(roomID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("loading room %s from redis: %w", roomID, err)
+	}
+
+	return r.buildRoom(roomID, members.Val(), state.Val()), nil
+}
+
+// buildRoom turns the stored member hash into a Room. Local members are the
+// live clients; members on other replicas are placeholders without a connection.
+func (r *redisRoomRepository) buildRoom(roomID string, members map[string]string, state string) *database.Room {
+	if len(members) == 0 {
+		return nil
+	}
+
+	room := database.NewRoom(roomID)
+	room.State = state
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for clientID, raw := range members {
+		if client, exists := r.local[clientID]; exists {
+			room.Clients[clientID] = client
+			continue
+		}
+
+		var member redisRoomMember
+		if err := json.Unmarshal([]byte(raw), &member); err != nil {
+			slog.Error("Error decoding room member", "client_id", clientID, "room_id", roomID, "error", err)
+			continue
+		}
+		client := &database.Client{
+			ID:          member.ClientID,
+			Username:    member.Username,
+			UserID:      member.UserID,
+			IsGuest:     member.IsGuest,
+			NodeID:      member.NodeID,
+			ConnectedAt: member.ConnectedAt,
+			JoinedAt:    member.JoinedAt,
+		}
+		client.SetRoomID(roomID)
+		room.Clients[clientID] = client
+	}
+
+	return room
+}
+
+func roomStateKey(roomID string) string {
+	return redisRoomKeyBase + roomID + ":state"
+}
+
+func negotiationKey(roomID string) string {
+	return redisRoomKeyBase + roomID + ":negotiation"
+}
+
+// removeKeys lists the KEYS expected by removeScript.
+func removeKeys(roomID string) []string {
+	return []string{redisRoomKeyBase + roomID, redisWaitingKey, redisRoomsKey, roomStateKey(roomID), negotiationKey(roomID)}
+}
@@ -0,0 +1,198 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedis starts an in-process Redis that runs the Lua scripts like the real one
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return server, rdb
+}
+
+func TestRedisRoomRepository(t *testing.T) {
+	testRoomRepository(t, func(t *testing.T) roomStore {
+		_, rdb := newRedis(t)
+		return func(nodeID string) contract.RoomRepository {
+			return repository.NewRedisRoomRepository(rdb, nodeID)
+		}
+	})
+}
+
+// Replicas see the members of the other replicas as clients without a connection
+func TestRedisRoomAcrossReplicas(t *testing.T) {
+	_, rdb := newRedis(t)
+	first, second := repository.NewRedisRoomRepository(rdb, "first"), repository.NewRedisRoomRepository(rdb, "second")
+
+	waiting := match(t, first, newClient("waiting"))
+	room := match(t, second, newClient("joining"))
+	if room.ID != waiting.ID || !room.IsFull() {
+		t.Fatalf("replicas did not share the waiting room")
+	}
+
+	peer := room.GetOtherClient("joining")
+	if peer == nil || peer.IsLocal() || peer.NodeID != "first" || peer.RoomID() != room.ID {
+		t.Fatalf("peer on the other replica is %+v, want a placeholder of node first", peer)
+	}
+	if initiator := room.Initiator(); initiator == nil || initiator.ID != "waiting" {
+		t.Fatalf("initiator %v, want the client that waited", initiator)
+	}
+}
+
+// removeScript leaves no keys behind once a room is empty
+func TestRedisRemovalCleansUp(t *testing.T) {
+	ctx := context.Background()
+	server, rdb := newRedis(t)
+	repo := repository.NewRedisRoomRepository(rdb, testNodeID)
+
+	room := match(t, repo, newClient("first"))
+	match(t, repo, newClient("second"))
+	repo.SetRoomState(ctx, room.ID, database.RoomStateConnected)
+	if err := repo.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+		negotiation.State = database.NegotiationAnswered
+		return nil
+	}); err != nil {
+		t.Fatalf("updating the negotiation: %v", err)
+	}
+	waiting := match(t, repo, newClient("third"))
+
+	repo.RemoveClient(ctx, room.ID, "first")
+	repo.RemoveClient(ctx, room.ID, "second")
+	repo.RemoveClient(ctx, waiting.ID, "third")
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("keys left after every room emptied: %v", keys)
+	}
+
+	// An emptied waiting room is skipped by the next match
+	if next := match(t, repo, newClient("fourth")); next.ID == waiting.ID || next.IsFull() {
+		t.Fatalf("matched into the emptied room %s", waiting.ID)
+	}
+}
+
+// An update whose state another replica changed meanwhile is run again on
+// the new state instead of overwriting it
+func TestRedisNegotiationRetries(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newRedis(t)
+	first, second := repository.NewRedisRoomRepository(rdb, "first"), repository.NewRedisRoomRepository(rdb, "second")
+	room := match(t, first, newClient("first"))
+	match(t, second, newClient("second"))
+
+	offer := func(offerer string) func(*database.Negotiation) {
+		return func(negotiation *database.Negotiation) {
+			if negotiation.State != database.NegotiationOfferPending {
+				negotiation.State, negotiation.Offerer = database.NegotiationOfferPending, offerer
+			}
+		}
+	}
+
+	read, committed := make(chan struct{}), make(chan struct{})
+	var seen []database.Negotiation
+	done := make(chan error, 1)
+	go func() {
+		done <- first.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+			seen = append(seen, *negotiation)
+			if len(seen) == 1 {
+				// Let the other replica commit between this read and the write
+				close(read)
+				<-committed
+			}
+			offer("first")(negotiation)
+			return nil
+		})
+	}()
+
+	<-read
+	if err := second.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+		offer("second")(negotiation)
+		return nil
+	}); err != nil {
+		t.Fatalf("concurrent update: %v", err)
+	}
+	close(committed)
+	if err := <-done; err != nil {
+		t.Fatalf("retried update: %v", err)
+	}
+
+	if len(seen) != 2 || seen[1].Offerer != "second" {
+		t.Fatalf("update saw %+v, want a second run on the other replica's offer", seen)
+	}
+	first.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+		if negotiation.Offerer != "second" {
+			t.Errorf("stored offerer %q, want second", negotiation.Offerer)
+		}
+		return nil
+	})
+}
+
+func TestRedisMatchFailure(t *testing.T) {
+	server, rdb := newRedis(t)
+	repo := repository.NewRedisRoomRepository(rdb, testNodeID)
+	server.Close()
+
+	room, err := repo.MatchClient(context.Background(), newClient("first"))
+	if err == nil || room != nil {
+		t.Fatalf("matched into %v without redis", room)
+	}
+}
+
+// failPipelines fails every pipeline, which loading a room uses, while
+// single commands such as the match script go through
+type failPipelines struct{}
+
+func (failPipelines) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (failPipelines) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (failPipelines) ProcessPipelineHook(redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(context.Context, []redis.Cmder) error { return errors.New("pipeline failed") }
+}
+
+// A client whose room cannot be loaded is taken out of it again, so
+// nobody is matched with a client that was told the match failed
+func TestRedisMatchUndoneWhenRoomCannotBeLoaded(t *testing.T) {
+	server, rdb := newRedis(t)
+	rdb.AddHook(failPipelines{})
+	repo := repository.NewRedisRoomRepository(rdb, testNodeID)
+
+	client := newClient("first")
+	if room, err := repo.MatchClient(context.Background(), client); err == nil {
+		t.Fatalf("matched into %v without loading the room", room)
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("failed match left %v", keys)
+	}
+	if client.RoomID() != "" {
+		t.Fatalf("client still names room %s", client.RoomID())
+	}
+	if !client.Enqueue([]byte("{}")) {
+		t.Fatal("client was closed, but should still hear about the failure")
+	}
+}
+
+func TestRedisMatchCancelled(t *testing.T) {
+	server, rdb := newRedis(t)
+	repo := repository.NewRedisRoomRepository(rdb, testNodeID)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.MatchClient(ctx, newClient("first")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("cancelled match wrote %v", keys)
+	}
+}
@@ -0,0 +1,242 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+)
+
+// roomStore opens the room repository of the replica with the given node
+// ID. All replicas of one store share its rooms.
+type roomStore func(nodeID string) contract.RoomRepository
+
+// testRoomRepository runs the same checks against every room store.
+// newStore must return an empty store for every subtest.
+func testRoomRepository(t *testing.T, newStore func(t *testing.T) roomStore) {
+	t.Run("matching", func(t *testing.T) { testMatching(t, newStore(t)(testNodeID)) })
+	t.Run("blocked users", func(t *testing.T) { testBlockedMatching(t, newStore(t)(testNodeID)) })
+	t.Run("removal", func(t *testing.T) { testRemoval(t, newStore(t)(testNodeID)) })
+	t.Run("negotiation", func(t *testing.T) { testNegotiation(t, newStore(t)(testNodeID)) })
+	t.Run("states", func(t *testing.T) { testCountRoomsByState(t, newStore(t)(testNodeID)) })
+	t.Run("node failure", func(t *testing.T) { testRemoveNodeClients(t, newStore(t)) })
+}
+
+const testNodeID = "node-1"
+
+func newClient(id string) *database.Client {
+	client := database.NewClient(id, nil, id, 4)
+	client.NodeID = testNodeID
+	client.JoinedAt = time.Now()
+	return client
+}
+
+func match(t *testing.T, repo contract.RoomRepository, client *database.Client) *database.Room {
+	t.Helper()
+
+	room, err := repo.MatchClient(context.Background(), client)
+	if err != nil {
+		t.Fatalf("matching %s: %v", client.ID, err)
+	}
+	if client.RoomID() != room.ID {
+		t.Fatalf("%s is in room %q, want %q", client.ID, client.RoomID(), room.ID)
+	}
+	return room
+}
+
+func testCountRoomsByState(t *testing.T, repo contract.RoomRepository) {
+	ctx := context.Background()
+	expect := func(when string, want map[string]int) {
+		t.Helper()
+		got := repo.CountRoomsByState(ctx)
+		for _, state := range []string{database.RoomStateWaiting, database.RoomStateNegotiating, database.RoomStateConnected} {
+			if got[state] != want[state] {
+				t.Fatalf("%s: %d rooms %s, want %d (all counts %v)", when, got[state], state, want[state], got)
+			}
+		}
+	}
+
+	expect("without rooms", nil)
+	connected := match(t, repo, newClient("first"))
+	match(t, repo, newClient("second"))
+	match(t, repo, newClient("third"))
+	expect("after a match and one waiting client", map[string]int{database.RoomStateWaiting: 1, database.RoomStateNegotiating: 1})
+
+	repo.SetRoomState(ctx, connected.ID, database.RoomStateConnected)
+	expect("after the answer", map[string]int{database.RoomStateWaiting: 1, database.RoomStateConnected: 1})
+
+	repo.RemoveClient(ctx, connected.ID, "first")
+	repo.RemoveClient(ctx, connected.ID, "second")
+	expect("after the call", map[string]int{database.RoomStateWaiting: 1})
+}
+
+func testMatching(t *testing.T, repo contract.RoomRepository) {
+	ctx := context.Background()
+
+	first := match(t, repo, newClient("first"))
+	if first.IsFull() || repo.GetWaitingCount(ctx) != 1 {
+		t.Fatalf("first client's room is full or not waiting")
+	}
+
+	second := match(t, repo, newClient("second"))
+	if second.ID != first.ID || !second.IsFull() {
+		t.Fatalf("second client got room %s (full %v), want %s", second.ID, second.IsFull(), first.ID)
+	}
+	if other := second.GetOtherClient("second"); other == nil || other.ID != "first" {
+		t.Fatalf("second client's peer is %v, want first", other)
+	}
+	if count := repo.GetWaitingCount(ctx); count != 0 {
+		t.Fatalf("%d rooms waiting after a match", count)
+	}
+
+	third := match(t, repo, newClient("third"))
+	if third.ID == first.ID || third.IsFull() {
+		t.Fatalf("third client was put in the full room")
+	}
+	if count := repo.GetRoomCount(ctx); count != 2 {
+		t.Fatalf("%d rooms, want 2", count)
+	}
+	if rooms := repo.ListRooms(ctx); len(rooms) != 2 {
+		t.Fatalf("listed %d rooms, want 2", len(rooms))
+	}
+
+	repo.SetRoomState(ctx, first.ID, database.RoomStateConnected)
+	if room := repo.GetRoom(ctx, first.ID); room == nil || room.State != database.RoomStateConnected {
+		t.Fatalf("room state was not recorded: %+v", room)
+	}
+}
+
+func testBlockedMatching(t *testing.T, repo contract.RoomRepository) {
+	ctx := context.Background()
+	user := func(id string, userID int, blocked ...int) *database.Client {
+		client := newClient(id)
+		client.UserID, client.Blocked = userID, blocked
+		return client
+	}
+
+	alice := match(t, repo, user("alice", 1))
+	bob := match(t, repo, user("bob", 2, 1))
+	if bob.ID == alice.ID || repo.GetWaitingCount(ctx) != 2 {
+		t.Fatalf("bob was matched with alice, whom he blocked")
+	}
+
+	// The skipped room keeps its place in the queue
+	if carol := match(t, repo, user("carol", 3)); carol.ID != alice.ID {
+		t.Fatalf("carol got room %s, want alice's %s", carol.ID, alice.ID)
+	}
+	if dave := match(t, repo, user("dave", 4)); dave.ID != bob.ID || !dave.IsFull() {
+		t.Fatalf("dave got room %s, want bob's %s", dave.ID, bob.ID)
+	}
+	if count := repo.GetWaitingCount(ctx); count != 0 {
+		t.Fatalf("%d rooms waiting after both matched", count)
+	}
+}
+
+func testRemoval(t *testing.T, repo contract.RoomRepository) {
+	ctx := context.Background()
+
+	first, second := newClient("first"), newClient("second")
+	room := match(t, repo, first)
+	match(t, repo, second)
+
+	if remaining := repo.RemoveClient(ctx, room.ID, "first"); remaining != 1 {
+		t.Fatalf("%d clients left, want 1", remaining)
+	}
+	if first.Enqueue([]byte("{}")) {
+		t.Fatal("the removed client's send channel is still open")
+	}
+	if left := repo.GetRoom(ctx, room.ID); left == nil || left.GetClientCount() != 1 {
+		t.Fatalf("room after the first left: %+v", left)
+	}
+
+	if remaining := repo.RemoveClient(ctx, room.ID, "second"); remaining != 0 {
+		t.Fatalf("%d clients left, want 0", remaining)
+	}
+	if repo.GetRoom(ctx, room.ID) != nil || repo.GetRoomCount(ctx) != 0 {
+		t.Fatal("empty room was not deleted")
+	}
+
+	// A deleted waiting room is no longer matched into
+	waiting := match(t, repo, newClient("third"))
+	repo.DeleteRoom(ctx, waiting.ID)
+	if repo.GetWaitingCount(ctx) != 0 {
+		t.Fatal("deleted room is still waiting")
+	}
+	if next := match(t, repo, newClient("fourth")); next.ID == waiting.ID {
+		t.Fatal("matched into a deleted room")
+	}
+}
+
+func testNegotiation(t *testing.T, repo contract.RoomRepository) {
+	ctx := context.Background()
+	room := match(t, repo, newClient("first"))
+	match(t, repo, newClient("second"))
+
+	offer := func(negotiation *database.Negotiation) error {
+		negotiation.State, negotiation.Offerer = database.NegotiationOfferPending, "first"
+		return nil
+	}
+	if err := repo.UpdateNegotiation(ctx, room.ID, offer); err != nil {
+		t.Fatalf("recording an offer: %v", err)
+	}
+
+	// A failed update changes nothing
+	rejected := errors.New("rejected")
+	err := repo.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+		if negotiation.State != database.NegotiationOfferPending || negotiation.Offerer != "first" {
+			t.Errorf("update sees %+v, want first's pending offer", negotiation)
+		}
+		negotiation.State = database.NegotiationAnswered
+		return rejected
+	})
+	if !errors.Is(err, rejected) {
+		t.Fatalf("got %v, want the update's error", err)
+	}
+	err = repo.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+		if negotiation.State != database.NegotiationOfferPending {
+			t.Errorf("failed update was saved: %+v", negotiation)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading the negotiation: %v", err)
+	}
+
+	// Rooms that are gone are left alone
+	called := false
+	err = repo.UpdateNegotiation(ctx, "missing", func(*database.Negotiation) error {
+		called = true
+		return nil
+	})
+	if err != nil || called {
+		t.Fatalf("update of a missing room ran (%v) or failed: %v", called, err)
+	}
+}
+
+func testRemoveNodeClients(t *testing.T, store roomStore) {
+	ctx := context.Background()
+	alive, dead := store("alive"), store("dead")
+
+	// Every client carries the node ID of the replica it is connected to
+	local, remote := newClient("local"), newClient("remote")
+	local.NodeID, remote.NodeID = "alive", "dead"
+	room := match(t, alive, local)
+	match(t, dead, remote)
+
+	lonely := newClient("lonely")
+	lonely.NodeID = "dead"
+	lonelyRoom := match(t, dead, lonely)
+
+	if removed := alive.RemoveNodeClients(ctx, "dead"); removed != 2 {
+		t.Fatalf("removed %d clients, want 2", removed)
+	}
+	if left := alive.GetRoom(ctx, room.ID); left == nil || left.GetClientCount() != 1 || left.GetOtherClient("local") != nil {
+		t.Fatalf("room after the node died: %+v", left)
+	}
+	if alive.GetRoom(ctx, lonelyRoom.ID) != nil {
+		t.Fatal("room of the dead node's waiting client is still there")
+	}
+}
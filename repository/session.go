@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+)
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *sessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) CreateSession(ctx context.Context, session *database.Session) (*database.Session, error) {
+	if err := r.db.WithContext(ctx).Create(session).Error; err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSession reads from the primary, so a revoked session stops working at once
+func (r *sessionRepository) GetSession(ctx context.Context, id string) (*database.Session, error) {
+	var session database.Session
+	if err := primary(r.db.WithContext(ctx)).Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActiveSessions may be served by a read replica
+func (r *sessionRepository) ListActiveSessions(ctx context.Context, userID int) ([]database.Session, error) {
+	var sessions []database.Session
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *sessionRepository) RevokeSession(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Model(&database.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *sessionRepository) RevokeUserSessions(ctx context.Context, userID int) error {
+	return r.db.WithContext(ctx).Model(&database.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *sessionRepository) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	return r.db.WithContext(ctx).Model(&database.Session{}).Where("id = ?", id).Update("last_seen_at", lastSeen).Error
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+)
+
+// memorySessionRepository keeps sessions in memory, with the semantics of
+// the GORM repository
+type memorySessionRepository struct {
+	sessions map[string]database.Session
+	mutex    sync.RWMutex
+}
+
+func NewMemorySessionRepository() *memorySessionRepository {
+	return &memorySessionRepository{
+		sessions: make(map[string]database.Session),
+	}
+}
+
+func (r *memorySessionRepository) CreateSession(ctx context.Context, session *database.Session) (*database.Session, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.sessions[session.ID]; ok {
+		return nil, gorm.ErrDuplicatedKey
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+
+	stored := *session
+	stored.User = nil
+	r.sessions[session.ID] = stored
+	return session, nil
+}
+
+func (r *memorySessionRepository) GetSession(ctx context.Context, id string) (*database.Session, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+func (r *memorySessionRepository) ListActiveSessions(ctx context.Context, userID int) ([]database.Session, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var sessions []database.Session
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (r *memorySessionRepository) RevokeSession(ctx context.Context, id string) error {
+	r.revoke(func(session *database.Session) bool { return session.ID == id })
+	return nil
+}
+
+func (r *memorySessionRepository) RevokeUserSessions(ctx context.Context, userID int) error {
+	r.revoke(func(session *database.Session) bool { return session.UserID == userID })
+	return nil
+}
+
+func (r *memorySessionRepository) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if session, ok := r.sessions[id]; ok {
+		session.LastSeenAt = lastSeen
+		r.sessions[id] = session
+	}
+	return nil
+}
+
+// activeSince reports whether the user has an unrevoked session seen at or
+// after since. A nil repository has no sessions.
+func (r *memorySessionRepository) activeSince(userID int, since time.Time) bool {
+	if r == nil {
+		return false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && !session.LastSeenAt.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteUsers drops every session of the users, like the cascading foreign key
+func (r *memorySessionRepository) deleteUsers(userIDs []int) {
+	if r == nil || len(userIDs) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for id, session := range r.sessions {
+		if slices.Contains(userIDs, session.UserID) {
+			delete(r.sessions, id)
+		}
+	}
+}
+
+// revoke marks matching sessions that are still active as revoked now
+func (r *memorySessionRepository) revoke(match func(session *database.Session) bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for id, session := range r.sessions {
+		if session.RevokedAt == nil && match(&session) {
+			session.RevokedAt = &now
+			r.sessions[id] = session
+		}
+	}
+}
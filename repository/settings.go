@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type settingsRepository struct {
+	db *gorm.DB
+}
+
+func NewSettingsRepository(db *gorm.DB) *settingsRepository {
+	return &settingsRepository{db: db}
+}
+
+func (r *settingsRepository) ListSettings(ctx context.Context) ([]database.Setting, error) {
+	var settings []database.Setting
+	if err := r.db.WithContext(ctx).Order("key").Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (r *settingsRepository) SaveSettings(ctx context.Context, settings []database.Setting) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range settings {
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&settings[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
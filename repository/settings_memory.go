@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+)
+
+type memorySettingsRepository struct {
+	settings map[string]database.Setting
+	mutex    sync.RWMutex
+}
+
+func NewMemorySettingsRepository() *memorySettingsRepository {
+	return &memorySettingsRepository{
+		settings: make(map[string]database.Setting),
+	}
+}
+
+func (r *memorySettingsRepository) ListSettings(ctx context.Context) ([]database.Setting, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	settings := make([]database.Setting, 0, len(r.settings))
+	for _, setting := range r.settings {
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+	return settings, nil
+}
+
+func (r *memorySettingsRepository) SaveSettings(ctx context.Context, settings []database.Setting) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for i := range settings {
+		settings[i].UpdatedAt = now
+		r.settings[settings[i].Key] = settings[i]
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package repository_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+)
+
+// testRepositories runs the same checks against every implementation of the
+// user, session, settings, OAuth state, guest challenge and moderation
+// repositories. newRepository must return empty
+// repositories for every subtest.
+func testRepositories(t *testing.T, newRepository func(t *testing.T) *contract.Repository) {
+	t.Run("users", func(t *testing.T) { testUserRepository(t, newRepository(t).User) })
+	t.Run("identities", func(t *testing.T) { testIdentities(t, newRepository(t).User) })
+	t.Run("sessions", func(t *testing.T) {
+		repo := newRepository(t)
+		testSessionRepository(t, repo.User, repo.Session)
+	})
+	t.Run("idle guests", func(t *testing.T) {
+		repo := newRepository(t)
+		testDeleteIdleGuests(t, repo.User, repo.Session)
+	})
+	t.Run("settings", func(t *testing.T) { testSettingsRepository(t, newRepository(t).Settings) })
+	t.Run("oauth states", func(t *testing.T) { testOAuthStateRepository(t, newRepository(t).OAuthState) })
+	t.Run("guest challenges", func(t *testing.T) { testGuestChallengeRepository(t, newRepository(t).Guest) })
+	t.Run("moderation", func(t *testing.T) {
+		repo := newRepository(t)
+		testModerationRepository(t, repo.User, repo.Moderation)
+	})
+}
+
+func createUser(t *testing.T, repo contract.UserRepository, username string) *database.User {
+	t.Helper()
+
+	user, err := repo.CreateUser(context.Background(), &database.User{
+		Username: username,
+		Email:    username + "@example.test",
+		Password: "hash",
+	})
+	if err != nil {
+		t.Fatalf("creating %s: %v", username, err)
+	}
+	return user
+}
+
+func testUserRepository(t *testing.T, repo contract.UserRepository) {
+	ctx := context.Background()
+
+	alice := createUser(t, repo, "alice")
+	if alice.ID == 0 {
+		t.Fatal("created user has no ID")
+	}
+	if alice.Role != database.RoleUser {
+		t.Fatalf("new user has role %q, want %q", alice.Role, database.RoleUser)
+	}
+	bob := createUser(t, repo, "bob")
+	if bob.ID == alice.ID {
+		t.Fatalf("both users got ID %d", bob.ID)
+	}
+
+	for _, duplicate := range []database.User{
+		{Username: "alice", Email: "other@example.test", Password: "hash"},
+		{Username: "other", Email: "alice@example.test", Password: "hash"},
+	} {
+		if _, err := repo.CreateUser(ctx, &duplicate); err == nil {
+			t.Fatalf("created %s <%s> although the name or email is taken", duplicate.Username, duplicate.Email)
+		}
+	}
+
+	for name, find := range map[string]func() (*database.User, error){
+		"id":       func() (*database.User, error) { return repo.GetUserByID(ctx, alice.ID) },
+		"profile":  func() (*database.User, error) { return repo.GetUserProfile(ctx, alice.ID) },
+		"email":    func() (*database.User, error) { return repo.GetUserByEmail(ctx, "alice@example.test") },
+		"username": func() (*database.User, error) { return repo.GetUserByUsername(ctx, "alice") },
+	} {
+		found, err := find()
+		if err != nil || found.ID != alice.ID {
+			t.Fatalf("by %s: got %+v, %v, want alice", name, found, err)
+		}
+	}
+	if _, err := repo.GetUserByEmail(ctx, "nobody@example.test"); err == nil {
+		t.Fatal("found a user for an unknown email")
+	}
+
+	alice.Username = "alice2"
+	if _, err := repo.UpdateUser(ctx, alice); err != nil {
+		t.Fatalf("updating alice: %v", err)
+	}
+	if found, err := repo.GetUserByUsername(ctx, "alice2"); err != nil || found.ID != alice.ID {
+		t.Fatalf("renamed user not found: %+v, %v", found, err)
+	}
+	bob.Email = "alice@example.test"
+	if _, err := repo.UpdateUser(ctx, bob); err == nil {
+		t.Fatal("bob took alice's email")
+	}
+
+	if err := repo.SetOnlineStatus(ctx, alice.ID, true); err != nil {
+		t.Fatalf("setting alice online: %v", err)
+	}
+	if found, _ := repo.GetUserByID(ctx, alice.ID); !found.IsOnline {
+		t.Fatal("alice is not online")
+	}
+}
+
+func testIdentities(t *testing.T, repo contract.UserRepository) {
+	ctx := context.Background()
+	alice := createUser(t, repo, "alice")
+
+	identity, err := repo.CreateIdentity(ctx, &database.UserIdentity{UserID: alice.ID, Provider: "google", Subject: "123"})
+	if err != nil {
+		t.Fatalf("linking alice: %v", err)
+	}
+	found, err := repo.GetIdentity(ctx, "google", "123")
+	if err != nil || found.ID != identity.ID || found.UserID != alice.ID {
+		t.Fatalf("got identity %+v, %v, want alice's", found, err)
+	}
+
+	if _, err := repo.CreateIdentity(ctx, &database.UserIdentity{UserID: alice.ID, Provider: "google", Subject: "123"}); err == nil {
+		t.Fatal("linked the same account twice")
+	}
+	if _, err := repo.GetIdentity(ctx, "github", "123"); err == nil {
+		t.Fatal("found an identity of another provider")
+	}
+}
+
+func testSessionRepository(t *testing.T, users contract.UserRepository, repo contract.SessionRepository) {
+	ctx := context.Background()
+	alice := createUser(t, users, "alice")
+
+	now := time.Now()
+	for i, id := range []string{"session-1", "session-2", "session-3"} {
+		session := &database.Session{ID: id, UserID: alice.ID, LastSeenAt: now.Add(time.Duration(i) * time.Minute)}
+		if _, err := repo.CreateSession(ctx, session); err != nil {
+			t.Fatalf("creating %s: %v", id, err)
+		}
+	}
+	if _, err := repo.CreateSession(ctx, &database.Session{ID: "session-1", UserID: alice.ID, LastSeenAt: now}); err == nil {
+		t.Fatal("created a session with a taken ID")
+	}
+
+	if err := repo.TouchSession(ctx, "session-1", now.Add(time.Hour)); err != nil {
+		t.Fatalf("touching session-1: %v", err)
+	}
+	if err := repo.RevokeSession(ctx, "session-2"); err != nil {
+		t.Fatalf("revoking session-2: %v", err)
+	}
+	if session, err := repo.GetSession(ctx, "session-2"); err != nil || session.RevokedAt == nil {
+		t.Fatalf("session-2 is %+v, %v, want it revoked", session, err)
+	}
+
+	// Most recently seen first, revoked ones left out
+	sessions, err := repo.ListActiveSessions(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("listing sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "session-1" || sessions[1].ID != "session-3" {
+		t.Fatalf("active sessions %v, want session-1 and session-3", sessionIDs(sessions))
+	}
+
+	if err := repo.RevokeUserSessions(ctx, alice.ID); err != nil {
+		t.Fatalf("revoking alice's sessions: %v", err)
+	}
+	if sessions, _ := repo.ListActiveSessions(ctx, alice.ID); len(sessions) != 0 {
+		t.Fatalf("sessions %v still active", sessionIDs(sessions))
+	}
+	if _, err := repo.GetSession(ctx, "session-4"); err == nil {
+		t.Fatal("found an unknown session")
+	}
+}
+
+func testDeleteIdleGuests(t *testing.T, users contract.UserRepository, sessions contract.SessionRepository) {
+	ctx := context.Background()
+	now := time.Now()
+	idleSince := now.Add(time.Minute)
+
+	guest := func(name string, lastSeen time.Time) *database.User {
+		user, err := users.CreateUser(ctx, &database.User{Username: name, Email: name + "@guest.invalid", IsGuest: true})
+		if err != nil {
+			t.Fatalf("creating %s: %v", name, err)
+		}
+		if !lastSeen.IsZero() {
+			session := &database.Session{ID: name + "-session", UserID: user.ID, LastSeenAt: lastSeen}
+			if _, err := sessions.CreateSession(ctx, session); err != nil {
+				t.Fatalf("creating the session of %s: %v", name, err)
+			}
+		}
+		return user
+	}
+	idle := guest("idle", time.Time{})
+	stale := guest("stale", now)
+	active := guest("active", now.Add(time.Hour))
+	registered := createUser(t, users, "registered")
+
+	revoked := guest("revoked", now.Add(time.Hour))
+	if err := sessions.RevokeSession(ctx, "revoked-session"); err != nil {
+		t.Fatalf("revoking: %v", err)
+	}
+
+	deleted, err := users.DeleteIdleGuests(ctx, idleSince)
+	if err != nil {
+		t.Fatalf("deleting idle guests: %v", err)
+	}
+	if deleted != 3 {
+		t.Fatalf("deleted %d guests, want idle, stale and revoked", deleted)
+	}
+	for _, user := range []*database.User{idle, stale, revoked} {
+		if _, err := users.GetUserByID(ctx, user.ID); err == nil {
+			t.Fatalf("%s was kept", user.Username)
+		}
+	}
+	for _, user := range []*database.User{active, registered} {
+		if _, err := users.GetUserByID(ctx, user.ID); err != nil {
+			t.Fatalf("%s was deleted: %v", user.Username, err)
+		}
+	}
+	if _, err := sessions.GetSession(ctx, "stale-session"); err == nil {
+		t.Fatal("the session of a deleted guest was kept")
+	}
+}
+
+func testOAuthStateRepository(t *testing.T, repo contract.OAuthStateRepository) {
+	ctx := context.Background()
+
+	for _, state := range []*database.OAuthState{
+		{State: "expired", Provider: "google", CodeVerifier: "v", Nonce: "n", ExpiresAt: time.Now().Add(-time.Second)},
+		{State: "valid", Provider: "google", CodeVerifier: "verifier", Nonce: "nonce", ExpiresAt: time.Now().Add(time.Minute)},
+	} {
+		if err := repo.SaveState(ctx, state); err != nil {
+			t.Fatalf("saving %s: %v", state.State, err)
+		}
+	}
+
+	state, err := repo.TakeState(ctx, "valid")
+	if err != nil || state == nil {
+		t.Fatalf("valid state is %+v, %v", state, err)
+	}
+	if state.Provider != "google" || state.CodeVerifier != "verifier" || state.Nonce != "nonce" {
+		t.Fatalf("state came back as %+v", state)
+	}
+	for _, name := range []string{"valid", "expired", "unknown"} {
+		if state, err := repo.TakeState(ctx, name); err != nil || state != nil {
+			t.Fatalf("taking %s returned %+v, %v, want nothing", name, state, err)
+		}
+	}
+}
+
+func testGuestChallengeRepository(t *testing.T, repo contract.GuestChallengeRepository) {
+	ctx := context.Background()
+
+	for _, challenge := range []*database.GuestChallenge{
+		{Challenge: "expired", Bits: 8, ExpiresAt: time.Now().Add(-time.Second)},
+		{Challenge: "valid", Bits: 8, ExpiresAt: time.Now().Add(time.Minute)},
+	} {
+		if err := repo.SaveChallenge(ctx, challenge); err != nil {
+			t.Fatalf("saving %s: %v", challenge.Challenge, err)
+		}
+	}
+
+	challenge, err := repo.TakeChallenge(ctx, "valid")
+	if err != nil || challenge == nil || challenge.Bits != 8 {
+		t.Fatalf("valid challenge is %+v, %v", challenge, err)
+	}
+	for _, name := range []string{"valid", "expired", "unknown"} {
+		if challenge, err := repo.TakeChallenge(ctx, name); err != nil || challenge != nil {
+			t.Fatalf("taking %s returned %+v, %v, want nothing", name, challenge, err)
+		}
+	}
+}
+
+func testModerationRepository(t *testing.T, users contract.UserRepository, repo contract.ModerationRepository) {
+	ctx := context.Background()
+	alice, bob, carol := createUser(t, users, "alice"), createUser(t, users, "bob"), createUser(t, users, "carol")
+
+	for range 2 {
+		if err := repo.BlockUser(ctx, alice.ID, bob.ID); err != nil {
+			t.Fatalf("blocking bob: %v", err)
+		}
+	}
+	if err := repo.BlockUser(ctx, carol.ID, alice.ID); err != nil {
+		t.Fatalf("blocking alice: %v", err)
+	}
+
+	// Blocks count both ways
+	blocked, err := repo.BlockedUserIDs(ctx, alice.ID)
+	if err != nil || !slices.Equal(sortedIDs(blocked), []int{bob.ID, carol.ID}) {
+		t.Fatalf("alice's blocks are %v, %v, want bob and carol", blocked, err)
+	}
+	if blocked, err := repo.BlockedUserIDs(ctx, bob.ID); err != nil || !slices.Equal(blocked, []int{alice.ID}) {
+		t.Fatalf("bob's blocks are %v, %v, want alice", blocked, err)
+	}
+
+	for _, report := range []*database.Report{
+		{ReporterID: alice.ID, ReportedID: bob.ID, RoomID: "room-1", Reason: "rude", Blocked: true},
+		{ReporterID: carol.ID, ReportedID: alice.ID, RoomID: "room-2", Reason: "spam"},
+	} {
+		if err := repo.CreateReport(ctx, report); err != nil || report.ID == 0 {
+			t.Fatalf("creating report: %v (ID %d)", err, report.ID)
+		}
+	}
+	reports, err := repo.ListReports(ctx, 1)
+	if err != nil || len(reports) != 1 || reports[0].Reason != "spam" || reports[0].CreatedAt.IsZero() {
+		t.Fatalf("newest report is %+v, %v, want carol's", reports, err)
+	}
+	if reports, err := repo.ListReports(ctx, 10); err != nil || len(reports) != 2 || !reports[1].Blocked {
+		t.Fatalf("reports are %+v, %v, want both", reports, err)
+	}
+}
+
+func sortedIDs(ids []int) []int {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	return ids
+}
+
+func sessionIDs(sessions []database.Session) []string {
+	ids := make([]string, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+	return ids
+}
+
+func testSettingsRepository(t *testing.T, repo contract.SettingsRepository) {
+	ctx := context.Background()
+
+	if settings, err := repo.ListSettings(ctx); err != nil || len(settings) != 0 {
+		t.Fatalf("new repository has settings %+v, %v", settings, err)
+	}
+
+	if err := repo.SaveSettings(ctx, []database.Setting{
+		{Key: "match_timeout_seconds", Value: "30"},
+		{Key: "guest_rate_limit", Value: "5"},
+	}); err != nil {
+		t.Fatalf("saving settings: %v", err)
+	}
+	if err := repo.SaveSettings(ctx, []database.Setting{{Key: "match_timeout_seconds", Value: "60"}}); err != nil {
+		t.Fatalf("replacing a setting: %v", err)
+	}
+
+	// Sorted by key
+	settings, err := repo.ListSettings(ctx)
+	if err != nil {
+		t.Fatalf("listing settings: %v", err)
+	}
+	if len(settings) != 2 || settings[0].Key != "guest_rate_limit" || settings[1].Value != "60" {
+		t.Fatalf("settings %+v, want guest_rate_limit=5 and match_timeout_seconds=60", settings)
+	}
+}
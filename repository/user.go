@@ -1,57 +1,158 @@
 package repository
 
 import (
+	"context"
+	"time"
+
 	"projectwebcurhat/database"
 
+	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
+var tracer = otel.Tracer("projectwebcurhat/repository")
+
+// userRepository bounds every query by queryTimeout, on top of whatever
+// deadline the caller's context has
 type userRepository struct {
-	db *gorm.DB
+	db           *gorm.DB
+	queryTimeout time.Duration
 }
 
-func NewUserRepository(db *gorm.DB) *userRepository {
-	return &userRepository{db: db}
+func NewUserRepository(db *gorm.DB, queryTimeout time.Duration) *userRepository {
+	return &userRepository{db: db, queryTimeout: queryTimeout}
 }
 
-func (r *userRepository) CreateUser(user *database.User) (*database.User, error) {
-	if err := r.db.Create(user).Error; err != nil {
+func (r *userRepository) CreateUser(ctx context.Context, user *database.User) (*database.User, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.CreateUser")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	if err := r.db.WithContext(ctx).Create(user).Error; err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *userRepository) GetUserByEmail(email string) (*database.User, error) {
+func (r *userRepository) GetUserByEmail(ctx context.Context, email string) (*database.User, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.GetUserByEmail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
 	var user database.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := primary(r.db.WithContext(ctx)).Where("email = ?", email).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) GetUserByID(id int) (*database.User, error) {
+// GetUserByID reads from the primary, as callers often update the user next
+func (r *userRepository) GetUserByID(ctx context.Context, id int) (*database.User, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.GetUserByID")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
 	var user database.User
-	if err := r.db.Where("id = ?", id).First(&user).Error; err != nil {
+	if err := primary(r.db.WithContext(ctx)).Where("id = ?", id).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) GetUserByUsername(username string) (*database.User, error) {
+func (r *userRepository) GetUserByUsername(ctx context.Context, username string) (*database.User, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.GetUserByUsername")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
 	var user database.User
-	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := primary(r.db.WithContext(ctx)).Where("username = ?", username).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) UpdateUser(user *database.User) (*database.User, error) {
-	if err := r.db.Save(user).Error; err != nil {
+// GetUserProfile may be served by a read replica and lag slightly behind writes
+func (r *userRepository) GetUserProfile(ctx context.Context, id int) (*database.User, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.GetUserProfile")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	var user database.User
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) UpdateUser(ctx context.Context, user *database.User) (*database.User, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.UpdateUser")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	if err := r.db.WithContext(ctx).Save(user).Error; err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *userRepository) SetOnlineStatus(userID int, online bool) error {
-	return r.db.Model(&database.User{}).Where("id = ?", userID).Update("is_online", online).Error
+func (r *userRepository) SetOnlineStatus(ctx context.Context, userID int, online bool) error {
+	ctx, span := tracer.Start(ctx, "UserRepository.SetOnlineStatus")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	return r.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("is_online", online).Error
+}
+
+func (r *userRepository) DeleteIdleGuests(ctx context.Context, idleSince time.Time) (int64, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.DeleteIdleGuests")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	active := r.db.Model(&database.Session{}).Select("1").
+		Where("sessions.user_id = users.id AND sessions.revoked_at IS NULL AND sessions.last_seen_at >= ?", idleSince)
+	result := r.db.WithContext(ctx).
+		Where("is_guest = ? AND created_at < ? AND NOT EXISTS (?)", true, idleSince, active).
+		Delete(&database.User{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *userRepository) GetIdentity(ctx context.Context, provider, subject string) (*database.UserIdentity, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.GetIdentity")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	var identity database.UserIdentity
+	if err := primary(r.db.WithContext(ctx)).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *userRepository) CreateIdentity(ctx context.Context, identity *database.UserIdentity) (*database.UserIdentity, error) {
+	ctx, span := tracer.Start(ctx, "UserRepository.CreateIdentity")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+
+	if err := r.db.WithContext(ctx).Create(identity).Error; err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// primary pins a read to the primary database. With read replicas
+// configured, reads of users and sessions go to a replica otherwise, which is
+// only right where a row that is a moment old does no harm.
+func primary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write)
 }
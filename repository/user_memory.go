@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"projectwebcurhat/database"
+
+	"gorm.io/gorm"
+)
+
+// memoryUserRepository keeps users in memory, for tests and local runs
+// without a database. It returns the same errors as the GORM repository:
+// gorm.ErrRecordNotFound for missing rows and gorm.ErrDuplicatedKey when a
+// unique column is taken. Callers get copies, never the stored rows.
+type memoryUserRepository struct {
+	users      map[int]database.User
+	identities map[string]database.UserIdentity
+	nextUserID int
+	nextIdent  int
+	mutex      sync.RWMutex
+	// sessions stands in for the foreign key of the sessions table, so
+	// deleting a user drops its sessions too. Nil without one.
+	sessions *memorySessionRepository
+}
+
+func NewMemoryUserRepository() *memoryUserRepository {
+	return &memoryUserRepository{
+		users:      make(map[int]database.User),
+		identities: make(map[string]database.UserIdentity),
+	}
+}
+
+func (r *memoryUserRepository) CreateUser(ctx context.Context, user *database.User) (*database.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.taken(user) {
+		return nil, gorm.ErrDuplicatedKey
+	}
+
+	r.nextUserID++
+	user.ID = r.nextUserID
+	if user.Role == "" {
+		user.Role = database.RoleUser
+	}
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	r.users[user.ID] = *user
+	return user, nil
+}
+
+func (r *memoryUserRepository) GetUserByEmail(ctx context.Context, email string) (*database.User, error) {
+	return r.find(func(user *database.User) bool { return user.Email == email })
+}
+
+func (r *memoryUserRepository) GetUserByID(ctx context.Context, id int) (*database.User, error) {
+	return r.find(func(user *database.User) bool { return user.ID == id })
+}
+
+func (r *memoryUserRepository) GetUserProfile(ctx context.Context, id int) (*database.User, error) {
+	return r.GetUserByID(ctx, id)
+}
+
+func (r *memoryUserRepository) GetUserByUsername(ctx context.Context, username string) (*database.User, error) {
+	return r.find(func(user *database.User) bool { return user.Username == username })
+}
+
+// UpdateUser saves every field like gorm's Save, inserting unknown users
+func (r *memoryUserRepository) UpdateUser(ctx context.Context, user *database.User) (*database.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.taken(user) {
+		return nil, gorm.ErrDuplicatedKey
+	}
+	if user.ID == 0 {
+		r.nextUserID++
+		user.ID = r.nextUserID
+		user.CreatedAt = time.Now()
+	}
+	r.nextUserID = max(r.nextUserID, user.ID)
+	user.UpdatedAt = time.Now()
+
+	r.users[user.ID] = *user
+	return user, nil
+}
+
+func (r *memoryUserRepository) SetOnlineStatus(ctx context.Context, userID int, online bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		user.IsOnline = online
+		r.users[userID] = user
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) DeleteIdleGuests(ctx context.Context, idleSince time.Time) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var deleted []int
+	for id, user := range r.users {
+		if user.IsGuest && user.CreatedAt.Before(idleSince) && !r.sessions.activeSince(id, idleSince) {
+			delete(r.users, id)
+			deleted = append(deleted, id)
+		}
+	}
+	for key, identity := range r.identities {
+		if slices.Contains(deleted, identity.UserID) {
+			delete(r.identities, key)
+		}
+	}
+	r.sessions.deleteUsers(deleted)
+	return int64(len(deleted)), nil
+}
+
+func (r *memoryUserRepository) GetIdentity(ctx context.Context, provider, subject string) (*database.UserIdentity, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	identity, ok := r.identities[identityKey(provider, subject)]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &identity, nil
+}
+
+func (r *memoryUserRepository) CreateIdentity(ctx context.Context, identity *database.UserIdentity) (*database.UserIdentity, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := identityKey(identity.Provider, identity.Subject)
+	if _, ok := r.identities[key]; ok {
+		return nil, gorm.ErrDuplicatedKey
+	}
+	if _, ok := r.users[identity.UserID]; !ok {
+		return nil, gorm.ErrForeignKeyViolated
+	}
+
+	r.nextIdent++
+	identity.ID = r.nextIdent
+	identity.CreatedAt = time.Now()
+
+	stored := *identity
+	stored.User = nil
+	r.identities[key] = stored
+	return identity, nil
+}
+
+func (r *memoryUserRepository) find(match func(user *database.User) bool) (*database.User, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, user := range r.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// taken reports whether another user already has the username or email
+func (r *memoryUserRepository) taken(user *database.User) bool {
+	for id, other := range r.users {
+		if id != user.ID && (other.Username == user.Username || other.Email == user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func identityKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
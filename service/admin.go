@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+
+	"gorm.io/gorm"
+)
+
+// adminReportLimit is how many of the newest reports admins see
+const adminReportLimit = 100
+
+type adminService struct {
+	repo             *contract.Repository
+	roomService      contract.RoomService
+	signalingService contract.SignalingService
+}
+
+func NewAdminService(repo *contract.Repository, roomService contract.RoomService, signalingService contract.SignalingService) contract.AdminService {
+	return &adminService{repo: repo, roomService: roomService, signalingService: signalingService}
+}
+
+// ListRooms returns every live room on all replicas, oldest connection first
+func (s *adminService) ListRooms(ctx context.Context) []dto.AdminRoom {
+	rooms := s.roomService.ListRooms(ctx)
+
+	result := make([]dto.AdminRoom, 0, len(rooms))
+	for _, room := range rooms {
+		result = append(result, toAdminRoom(room))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return oldestConnection(result[i]).Before(oldestConnection(result[j]))
+	})
+	return result
+}
+
+func (s *adminService) GetRoom(ctx context.Context, roomID string) (*dto.AdminRoom, error) {
+	room := s.roomService.GetRoom(ctx, roomID)
+	if room == nil {
+		return nil, errs.NotFound("Room not found")
+	}
+
+	result := toAdminRoom(room)
+	return &result, nil
+}
+
+// CloseRoom tells every participant the room was closed, disconnects them
+// and removes whatever is left of the room
+func (s *adminService) CloseRoom(ctx context.Context, roomID, reason string) error {
+	room := s.roomService.GetRoom(ctx, roomID)
+	if room == nil {
+		return errs.NotFound("Room not found")
+	}
+
+	for _, client := range room.GetClients() {
+		if err := s.signalingService.KickClient(ctx, client.ID, dto.MessageTypeRoomClosed, reason); err != nil {
+			slog.ErrorContext(ctx, "Error requesting client disconnect", "client_id", client.ID, "room_id", roomID, "error", err)
+			return errs.InternalServerError("Failed to close room")
+		}
+	}
+	s.roomService.DeleteRoom(ctx, roomID)
+
+	slog.InfoContext(ctx, "Admin closed room", "audit", true, "room_id", roomID, "reason", reason)
+	return nil
+}
+
+// KickClient disconnects a client that is in a room. Clients that have not
+// joined a room are not tracked across replicas and cannot be kicked.
+func (s *adminService) KickClient(ctx context.Context, clientID, reason string) error {
+	client := s.findClient(ctx, clientID)
+	if client == nil {
+		return errs.NotFound("Client not found")
+	}
+
+	if err := s.signalingService.KickClient(ctx, client.ID, dto.MessageTypeKicked, reason); err != nil {
+		slog.ErrorContext(ctx, "Error requesting client disconnect", "client_id", clientID, "error", err)
+		return errs.InternalServerError("Failed to kick client")
+	}
+
+	slog.InfoContext(ctx, "Admin kicked client", "audit", true, "client_id", clientID, "room_id", client.RoomID(), "reason", reason)
+	return nil
+}
+
+func (s *adminService) CreateUser(ctx context.Context, payload *dto.RegisterRequest, role string) (*dto.UserProfile, error) {
+	if !validRole(role) {
+		return nil, errs.BadRequest("Role must be user or admin")
+	}
+
+	user, err := createUser(ctx, s.repo, payload, role)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Admin created user", "audit", true, "target_user_id", user.ID, "role", role)
+	return newUserProfile(user), nil
+}
+
+func (s *adminService) SetUserRole(ctx context.Context, login, role string) (*dto.UserProfile, error) {
+	if !validRole(role) {
+		return nil, errs.BadRequest("Role must be user or admin")
+	}
+
+	user, err := s.findUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	if _, err := s.repo.User.UpdateUser(ctx, user); err != nil {
+		return nil, errs.InternalServerError("Failed to update user")
+	}
+
+	slog.InfoContext(ctx, "Admin changed user role", "audit", true, "target_user_id", user.ID, "role", role)
+	return newUserProfile(user), nil
+}
+
+func (s *adminService) SetUserBanned(ctx context.Context, login string, banned bool) (*dto.UserProfile, error) {
+	user, err := s.findUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	user.BannedAt = nil
+	if banned {
+		now := time.Now()
+		user.BannedAt = &now
+	}
+	if _, err := s.repo.User.UpdateUser(ctx, user); err != nil {
+		return nil, errs.InternalServerError("Failed to update user")
+	}
+
+	if banned {
+		if err := s.repo.Session.RevokeUserSessions(ctx, user.ID); err != nil {
+			return nil, errs.InternalServerError("Failed to revoke sessions")
+		}
+	}
+
+	slog.InfoContext(ctx, "Admin changed user ban", "audit", true, "target_user_id", user.ID, "banned", banned)
+	return newUserProfile(user), nil
+}
+
+// ListReports returns the newest reports with the names of both users
+func (s *adminService) ListReports(ctx context.Context) ([]dto.AdminReport, error) {
+	reports, err := s.repo.Moderation.ListReports(ctx, adminReportLimit)
+	if err != nil {
+		return nil, errs.InternalServerError("Failed to list reports")
+	}
+
+	users := make(map[int]*database.User)
+	lookup := func(userID int) *database.User {
+		if user, ok := users[userID]; ok {
+			return user
+		}
+		user, err := s.repo.User.GetUserByID(ctx, userID)
+		if err != nil {
+			user = &database.User{}
+		}
+		users[userID] = user
+		return user
+	}
+
+	result := make([]dto.AdminReport, 0, len(reports))
+	for _, report := range reports {
+		reporter, reported := lookup(report.ReporterID), lookup(report.ReportedID)
+		result = append(result, dto.AdminReport{
+			ID:               report.ID,
+			ReporterID:       report.ReporterID,
+			ReporterUsername: reporter.Username,
+			ReportedID:       report.ReportedID,
+			ReportedUsername: reported.Username,
+			ReportedIsGuest:  reported.IsGuest,
+			RoomID:           report.RoomID,
+			Reason:           report.Reason,
+			Blocked:          report.Blocked,
+			CreatedAt:        report.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+func (s *adminService) IssueToken(ctx context.Context, login string, meta *dto.SessionMeta) (*dto.AuthResponse, error) {
+	user, err := s.findUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := newAuthResponse(ctx, s.repo, user, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Admin issued token", "audit", true, "target_user_id", user.ID)
+	return result, nil
+}
+
+// findUser looks the login up as an email when it contains an @ and as a
+// username otherwise
+func (s *adminService) findUser(ctx context.Context, login string) (*database.User, error) {
+	var (
+		user *database.User
+		err  error
+	)
+	if strings.Contains(login, "@") {
+		user, err = s.repo.User.GetUserByEmail(ctx, login)
+	} else {
+		user, err = s.repo.User.GetUserByUsername(ctx, login)
+	}
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errs.NotFound("User not found")
+		}
+		return nil, errs.InternalServerError("Failed to find user")
+	}
+	return user, nil
+}
+
+func validRole(role string) bool {
+	return role == database.RoleUser || role == database.RoleAdmin
+}
+
+func (s *adminService) findClient(ctx context.Context, clientID string) *database.Client {
+	for _, room := range s.roomService.ListRooms(ctx) {
+		for _, client := range room.GetClients() {
+			if client.ID == clientID {
+				return client
+			}
+		}
+	}
+	return nil
+}
+
+func toAdminRoom(room *database.Room) dto.AdminRoom {
+	clients := room.GetClients()
+
+	participants := make([]dto.AdminParticipant, 0, len(clients))
+	for _, client := range clients {
+		participants = append(participants, dto.AdminParticipant{
+			ClientID:             client.ID,
+			UserID:               client.UserID,
+			Username:             client.Username,
+			IsGuest:              client.IsGuest,
+			NodeID:               client.NodeID,
+			ConnectedAt:          client.ConnectedAt,
+			ConnectionAgeSeconds: int64(time.Since(client.ConnectedAt).Seconds()),
+		})
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].ConnectedAt.Before(participants[j].ConnectedAt)
+	})
+
+	return dto.AdminRoom{
+		ID:           room.ID,
+		State:        room.GetState(),
+		Participants: participants,
+	}
+}
+
+func oldestConnection(room dto.AdminRoom) time.Time {
+	if len(room.Participants) == 0 {
+		return time.Time{}
+	}
+	return room.Participants[0].ConnectedAt
+}
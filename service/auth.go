@@ -1,7 +1,9 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"projectwebcurhat/config/pkg/errs"
 	"projectwebcurhat/config/pkg/token"
@@ -9,21 +11,83 @@ import (
 	"projectwebcurhat/database"
 	"projectwebcurhat/dto"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type authService struct {
-	repo *contract.Repository
+	repo     *contract.Repository
+	settings contract.SettingsService
 }
 
-func NewAuthService(repo *contract.Repository) contract.AuthService {
-	return &authService{repo: repo}
+// NewAuthService deletes guests idle for guestIdleTTL once per
+// guestCleanupInterval, unless guestIdleTTL is 0
+func NewAuthService(repo *contract.Repository, settings contract.SettingsService, guestIdleTTL time.Duration) contract.AuthService {
+	s := &authService{repo: repo, settings: settings}
+	if guestIdleTTL > 0 {
+		go s.cleanupGuests(guestIdleTTL)
+	}
+	return s
+}
+
+func (s *authService) Register(ctx context.Context, payload *dto.RegisterRequest, meta *dto.SessionMeta) (_ *dto.AuthResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.Register")
+	defer func() { endSpan(span, err) }()
+
+	if !s.settings.Get().Features.Registration {
+		return nil, errs.Forbidden("Registration is disabled")
+	}
+
+	createdUser, err := createUser(ctx, s.repo, payload, database.RoleUser)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuthResponse(ctx, s.repo, createdUser, meta)
+}
+
+func (s *authService) Login(ctx context.Context, payload *dto.LoginRequest, meta *dto.SessionMeta) (_ *dto.AuthResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.Login")
+	defer func() { endSpan(span, err) }()
+
+	// Find user by email
+	user, err := s.repo.User.GetUserByEmail(ctx, payload.Email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errs.Unauthorized("Invalid email or password")
+		}
+		return nil, errs.InternalServerError("Failed to find user")
+	}
+
+	// Compare password
+	if err := comparePassword(ctx, user.Password, payload.Password); err != nil {
+		return nil, errs.Unauthorized("Invalid email or password")
+	}
+
+	return newAuthResponse(ctx, s.repo, user, meta)
+}
+
+func (s *authService) GetProfile(ctx context.Context, userID int) (_ *dto.UserProfile, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.GetProfile")
+	defer func() { endSpan(span, err) }()
+
+	user, err := s.repo.User.GetUserProfile(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errs.NotFound("User not found")
+		}
+		return nil, errs.InternalServerError("Failed to get user")
+	}
+
+	return newUserProfile(user), nil
 }
 
-func (s *authService) Register(payload *dto.RegisterRequest) (*dto.AuthResponse, error) {
+// createUser stores a new password account after making sure the email and
+// username are free
+func createUser(ctx context.Context, repo *contract.Repository, payload *dto.RegisterRequest, role string) (*database.User, error) {
 	// Check if email already exists
-	_, err := s.repo.User.GetUserByEmail(payload.Email)
+	_, err := repo.User.GetUserByEmail(ctx, payload.Email)
 	if err == nil {
 		return nil, errs.BadRequest("Email already registered")
 	}
@@ -32,7 +96,7 @@ func (s *authService) Register(payload *dto.RegisterRequest) (*dto.AuthResponse,
 	}
 
 	// Check if username already exists
-	_, err = s.repo.User.GetUserByUsername(payload.Username)
+	_, err = repo.User.GetUserByUsername(ctx, payload.Username)
 	if err == nil {
 		return nil, errs.BadRequest("Username already taken")
 	}
@@ -40,86 +104,94 @@ func (s *authService) Register(payload *dto.RegisterRequest) (*dto.AuthResponse,
 		return nil, errs.InternalServerError("Failed to check username")
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(ctx, payload.Password)
 	if err != nil {
 		return nil, errs.InternalServerError("Failed to hash password")
 	}
 
-	// Create user
 	user := &database.User{
 		Username: payload.Username,
 		Email:    payload.Email,
 		Password: string(hashedPassword),
+		Role:     role,
 	}
 
-	createdUser, err := s.repo.User.CreateUser(user)
+	createdUser, err := repo.User.CreateUser(ctx, user)
 	if err != nil {
 		return nil, errs.InternalServerError("Failed to create user")
 	}
+	return createdUser, nil
+}
 
-	// Generate JWT token
-	tokenString, err := token.GenerateToken(createdUser.ID, createdUser.Username, createdUser.Email)
-	if err != nil {
-		return nil, errs.InternalServerError("Failed to generate token")
+// newAuthResponse starts a new session for the user and issues a JWT bound
+// to it. Every way of logging in ends here, so bans are enforced here too.
+func newAuthResponse(ctx context.Context, repo *contract.Repository, user *database.User, meta *dto.SessionMeta) (*dto.AuthResponse, error) {
+	if user.BannedAt != nil {
+		return nil, errs.Forbidden("Account is banned")
 	}
 
-	return &dto.AuthResponse{
-		Token: tokenString,
-		User: dto.UserProfile{
-			ID:       createdUser.ID,
-			Username: createdUser.Username,
-			Email:    createdUser.Email,
-			IsOnline: createdUser.IsOnline,
-		},
-	}, nil
-}
-
-func (s *authService) Login(payload *dto.LoginRequest) (*dto.AuthResponse, error) {
-	// Find user by email
-	user, err := s.repo.User.GetUserByEmail(payload.Email)
+	now := time.Now()
+	session, err := repo.Session.CreateSession(ctx, &database.Session{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		UserAgent:  truncate(meta.UserAgent, 512),
+		IPAddress:  truncate(meta.IPAddress, 64),
+		LastSeenAt: now,
+	})
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errs.Unauthorized("Invalid email or password")
-		}
-		return nil, errs.InternalServerError("Failed to find user")
+		return nil, errs.InternalServerError("Failed to create session")
 	}
 
-	// Compare password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
-		return nil, errs.Unauthorized("Invalid email or password")
-	}
+	return newSessionAuthResponse(user, session.ID)
+}
 
-	// Generate JWT token
-	tokenString, err := token.GenerateToken(user.ID, user.Username, user.Email)
+// newSessionAuthResponse issues a JWT for an existing session
+func newSessionAuthResponse(user *database.User, sessionID string) (*dto.AuthResponse, error) {
+	tokenString, err := token.GenerateToken(user, sessionID)
 	if err != nil {
 		return nil, errs.InternalServerError("Failed to generate token")
 	}
 
 	return &dto.AuthResponse{
 		Token: tokenString,
-		User: dto.UserProfile{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-			IsOnline: user.IsOnline,
-		},
+		User:  *newUserProfile(user),
 	}, nil
 }
 
-func (s *authService) GetProfile(userID int) (*dto.UserProfile, error) {
-	user, err := s.repo.User.GetUserByID(userID)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errs.NotFound("User not found")
-		}
-		return nil, errs.InternalServerError("Failed to get user")
-	}
-
-	return &dto.UserProfile{
+// newUserProfile hides the placeholder email that guest accounts carry
+func newUserProfile(user *database.User) *dto.UserProfile {
+	profile := &dto.UserProfile{
 		ID:       user.ID,
 		Username: user.Username,
 		Email:    user.Email,
 		IsOnline: user.IsOnline,
-	}, nil
+		IsGuest:  user.IsGuest,
+		Role:     user.Role,
+		BannedAt: user.BannedAt,
+	}
+	if user.IsGuest {
+		profile.Email = ""
+	}
+	return profile
+}
+
+// hashPassword and comparePassword get their own spans because bcrypt is
+// deliberately slow and often dominates a login
+func hashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func comparePassword(ctx context.Context, hash, password string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+func truncate(value string, max int) string {
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
 }
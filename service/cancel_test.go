@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"projectwebcurhat/bus"
+	"projectwebcurhat/config"
+	dbConfig "projectwebcurhat/config/database"
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/config/pkg/token"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+	"projectwebcurhat/metrics"
+	"projectwebcurhat/repository"
+	"projectwebcurhat/service"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newSQLiteService wires the services on an in-memory SQLite database, whose
+// queries honour cancellation, unlike the in-memory repositories. Rooms are
+// kept in redis when rdb is given.
+func newSQLiteService(t *testing.T, rdb *redis.Client) *contract.Service {
+	t.Helper()
+
+	err := config.Load(config.Sources{Flags: map[string]string{
+		"database.url":  "sqlite::memory:",
+		"log.level":     "warn",
+		"jwt.algorithm": "HS256",
+		"jwt.secret":    "service-test-secret-never-used-elsewhere",
+	}})
+	if err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+	if err := token.Init(config.Get()); err != nil {
+		t.Fatalf("initializing token keys: %v", err)
+	}
+
+	db, sqlDB, err := dbConfig.ConnectDB()
+	if err != nil {
+		t.Fatalf("connecting to sqlite: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := database.RunMigration(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	roomStore := "memory"
+	if rdb != nil {
+		roomStore = "redis"
+	}
+	repo, err := repository.New(db, rdb, roomStore)
+	if err != nil {
+		t.Fatalf("creating repositories: %v", err)
+	}
+	messageBus := bus.NewMemoryBus()
+	t.Cleanup(func() { messageBus.Close() })
+	return service.New(repo, messageBus, metrics.Noop{})
+}
+
+func TestCancelledContextAbortsServiceCalls(t *testing.T) {
+	serv := newSQLiteService(t, nil)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	register := &dto.RegisterRequest{Username: "alice", Email: "alice@example.test", Password: "password123"}
+	if _, err := serv.Auth.Register(cancelled, register, &dto.SessionMeta{}); !isStatus(err, http.StatusInternalServerError) {
+		t.Fatalf("register with a cancelled context: got %v, want an internal error", err)
+	}
+
+	// Nothing was stored, so the same registration still goes through
+	if _, err := serv.Auth.Register(context.Background(), register, &dto.SessionMeta{}); err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+
+	login := &dto.LoginRequest{Email: "alice@example.test", Password: "password123"}
+	if _, err := serv.Auth.Login(cancelled, login, &dto.SessionMeta{}); !isStatus(err, http.StatusInternalServerError) {
+		t.Fatalf("login with a cancelled context: got %v, want an internal error", err)
+	}
+	if _, err := serv.Auth.Login(context.Background(), login, &dto.SessionMeta{}); err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+}
+
+func isStatus(err error, status int) bool {
+	var messageErr errs.MessageError
+	return errors.As(err, &messageErr) && messageErr.Status() == status
+}
+
+// A join whose connection is gone stops before a room is taken
+func TestCancelledJoinIsNotMatched(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	serv := newSQLiteService(t, rdb)
+
+	client := database.NewClient("client", nil, "client", 8)
+	if err := serv.Signaling.ConnectClient(context.Background(), client); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	serv.Signaling.HandleMessage(cancelled, client, []byte(`{"type":"join"}`))
+
+	var reply dto.Message
+	if err := json.Unmarshal(<-client.Send, &reply); err != nil || reply.Type != dto.MessageTypeError {
+		t.Fatalf("got %+v (%v), want an error", reply, err)
+	}
+	if client.RoomID() != "" || len(server.Keys()) != 0 {
+		t.Fatalf("cancelled join was matched into %q, keys %v", client.RoomID(), server.Keys())
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"projectwebcurhat/dto"
+)
+
+const heartbeatSubject = "webcurhat.node.heartbeat"
+
+// startClusterMonitor publishes this replica's heartbeat and watches the
+// heartbeats of the others. A replica that goes silent is treated as dead:
+// local clients paired with one of its clients receive a leave, and its
+// clients are removed from the shared room state.
+func (s *signalingService) startClusterMonitor(heartbeat, nodeTimeout time.Duration) {
+	_, err := s.bus.Subscribe(heartbeatSubject, func(data []byte) {
+		nodeID := string(data)
+		if nodeID == s.nodeID {
+			return
+		}
+
+		s.nodeMutex.Lock()
+		if _, known := s.nodes[nodeID]; !known {
+			slog.Info("Node joined the cluster", "node_id", nodeID)
+		}
+		s.nodes[nodeID] = time.Now()
+		s.nodeMutex.Unlock()
+	})
+	if err != nil {
+		slog.Warn("Failed to subscribe to node heartbeats, dead nodes will not be detected", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.bus.Publish(heartbeatSubject, []byte(s.nodeID)); err != nil {
+				slog.Error("Error publishing heartbeat", "error", err)
+			}
+
+			for _, nodeID := range s.expiredNodes(nodeTimeout) {
+				s.handleNodeFailure(context.Background(), nodeID)
+			}
+		}
+	}()
+}
+
+func (s *signalingService) expiredNodes(nodeTimeout time.Duration) []string {
+	s.nodeMutex.Lock()
+	defer s.nodeMutex.Unlock()
+
+	var expired []string
+	for nodeID, lastSeen := range s.nodes {
+		if time.Since(lastSeen) > nodeTimeout {
+			expired = append(expired, nodeID)
+			delete(s.nodes, nodeID)
+		}
+	}
+	return expired
+}
+
+func (s *signalingService) handleNodeFailure(ctx context.Context, nodeID string) {
+	slog.Warn("Node stopped sending heartbeats, ending its calls", "node_id", nodeID)
+
+	for _, client := range s.localClients() {
+		roomID := client.RoomID()
+		if roomID == "" {
+			continue
+		}
+
+		room := s.roomService.GetRoom(ctx, roomID)
+		if room == nil {
+			continue
+		}
+
+		peer := room.GetOtherClient(client.ID)
+		if peer != nil && peer.NodeID == nodeID {
+			s.sendToClient(client, &dto.Message{
+				Type: dto.MessageTypeLeave,
+				From: peer.ID,
+			})
+		}
+	}
+
+	s.roomService.RemoveNodeClients(ctx, nodeID)
+}
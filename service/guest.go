@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"math/rand/v2"
+	"time"
+
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const guestChallengeTTL = 2 * time.Minute
+
+// guestCleanupInterval is how often idle guests are looked for. Every
+// replica does it; deleting the same rows twice is harmless.
+const guestCleanupInterval = time.Hour
+
+// guestEmailDomain uses the reserved .invalid TLD so placeholder addresses can never receive mail
+const guestEmailDomain = "guest.invalid"
+
+var (
+	nicknameAnimals = []string{
+		"Kucing", "Kelinci", "Panda", "Rusa", "Burung", "Ikan", "Penyu", "Harimau",
+		"Gajah", "Koala", "Lumba", "Beruang", "Rubah", "Serigala", "Kupu", "Penguin",
+	}
+	nicknameColors = []string{
+		"Biru", "Merah", "Hijau", "Kuning", "Ungu", "Jingga", "Putih", "Hitam",
+		"Abu", "Cokelat", "Emas", "Perak", "Toska", "Nila",
+	}
+)
+
+func (s *authService) GuestChallenge(ctx context.Context) (*dto.GuestChallengeResponse, error) {
+	settings := s.settings.Get()
+	if !settings.Features.GuestAccounts {
+		return nil, errs.Forbidden("Guest accounts are disabled")
+	}
+	powBits := settings.GuestPoWBits
+	if powBits == 0 {
+		return nil, errs.NotFound("Proof-of-work is not enabled")
+	}
+
+	challenge, err := randomString()
+	if err != nil {
+		return nil, errs.InternalServerError("Failed to create challenge")
+	}
+
+	err = s.repo.Guest.SaveChallenge(ctx, &database.GuestChallenge{
+		Challenge: challenge,
+		Bits:      powBits,
+		ExpiresAt: time.Now().Add(guestChallengeTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save guest challenge", "error", err)
+		return nil, errs.InternalServerError("Failed to create challenge")
+	}
+
+	return &dto.GuestChallengeResponse{
+		Challenge: challenge,
+		Bits:      powBits,
+		ExpiresIn: int(guestChallengeTTL.Seconds()),
+	}, nil
+}
+
+func (s *authService) Guest(ctx context.Context, payload *dto.GuestRequest, meta *dto.SessionMeta) (_ *dto.AuthResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.Guest")
+	defer func() { endSpan(span, err) }()
+
+	settings := s.settings.Get()
+	if !settings.Features.GuestAccounts {
+		return nil, errs.Forbidden("Guest accounts are disabled")
+	}
+
+	if settings.GuestPoWBits > 0 {
+		challenge, err := s.repo.Guest.TakeChallenge(ctx, payload.Challenge)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read guest challenge", "error", err)
+			return nil, errs.InternalServerError("Failed to check challenge")
+		}
+		if challenge == nil {
+			return nil, errs.BadRequest("Invalid or expired challenge")
+		}
+		if !solvesChallenge(challenge, payload.Nonce) {
+			return nil, errs.BadRequest("Invalid proof-of-work")
+		}
+	}
+
+	// Nicknames are drawn at random, so retry a few times on the rare collision
+	for attempt := 0; attempt < 5; attempt++ {
+		nickname := randomNickname()
+
+		_, err := s.repo.User.GetUserByUsername(ctx, nickname)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errs.InternalServerError("Failed to check username")
+		}
+
+		user, err := s.repo.User.CreateUser(ctx, &database.User{
+			Username: nickname,
+			Email:    fmt.Sprintf("%s@%s", uuid.New().String(), guestEmailDomain),
+			IsGuest:  true,
+		})
+		if err != nil {
+			return nil, errs.InternalServerError("Failed to create guest")
+		}
+
+		return newAuthResponse(ctx, s.repo, user, meta)
+	}
+
+	return nil, errs.InternalServerError("Failed to pick a nickname")
+}
+
+// UpgradeGuest turns a guest into a full account in place, so the user ID and
+// everything attached to it is kept. The current session carries over too.
+func (s *authService) UpgradeGuest(ctx context.Context, userID int, sessionID string, payload *dto.RegisterRequest) (_ *dto.AuthResponse, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.UpgradeGuest")
+	defer func() { endSpan(span, err) }()
+
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errs.NotFound("User not found")
+		}
+		return nil, errs.InternalServerError("Failed to get user")
+	}
+	if !user.IsGuest {
+		return nil, errs.BadRequest("Account is already registered")
+	}
+	if !s.settings.Get().Features.Registration {
+		return nil, errs.Forbidden("Registration is disabled")
+	}
+
+	_, err = s.repo.User.GetUserByEmail(ctx, payload.Email)
+	if err == nil {
+		return nil, errs.BadRequest("Email already registered")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errs.InternalServerError("Failed to check email")
+	}
+
+	existing, err := s.repo.User.GetUserByUsername(ctx, payload.Username)
+	if err == nil && existing.ID != user.ID {
+		return nil, errs.BadRequest("Username already taken")
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errs.InternalServerError("Failed to check username")
+	}
+
+	hashedPassword, err := hashPassword(ctx, payload.Password)
+	if err != nil {
+		return nil, errs.InternalServerError("Failed to hash password")
+	}
+
+	user.Username = payload.Username
+	user.Email = payload.Email
+	user.Password = string(hashedPassword)
+	user.IsGuest = false
+
+	updatedUser, err := s.repo.User.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, errs.InternalServerError("Failed to upgrade account")
+	}
+
+	return newSessionAuthResponse(updatedUser, sessionID)
+}
+
+// cleanupGuests deletes guests that have not been seen for idleTTL, which
+// frees their nicknames and keeps abandoned rows from piling up
+func (s *authService) cleanupGuests(idleTTL time.Duration) {
+	ticker := time.NewTicker(guestCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := s.repo.User.DeleteIdleGuests(context.Background(), time.Now().Add(-idleTTL))
+		if err != nil {
+			slog.Error("Error deleting idle guests", "error", err)
+			continue
+		}
+		if deleted > 0 {
+			slog.Info("Deleted idle guests", "count", deleted)
+		}
+	}
+}
+
+// randomNickname builds a friendly name such as "Kucing Biru 4217". The
+// suffix leaves room for about two million names, so with idle guests
+// deleted a collision stays rare.
+func randomNickname() string {
+	return fmt.Sprintf("%s %s %d",
+		nicknameAnimals[rand.IntN(len(nicknameAnimals))],
+		nicknameColors[rand.IntN(len(nicknameColors))],
+		rand.IntN(9999)+1,
+	)
+}
+
+func solvesChallenge(challenge *database.GuestChallenge, nonce string) bool {
+	if nonce == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(challenge.Challenge + ":" + nonce))
+
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= challenge.Bits
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/dto"
+)
+
+type healthService struct {
+	repo      *contract.Repository
+	bus       contract.MessageBus
+	lifecycle contract.LifecycleService
+	signaling contract.SignalingService
+	room      contract.RoomService
+}
+
+func NewHealthService(repo *contract.Repository, bus contract.MessageBus, lifecycle contract.LifecycleService, signaling contract.SignalingService, room contract.RoomService) contract.HealthService {
+	return &healthService{
+		repo:      repo,
+		bus:       bus,
+		lifecycle: lifecycle,
+		signaling: signaling,
+		room:      room,
+	}
+}
+
+// Ready leaves out error messages, since the readiness probe is public
+func (s *healthService) Ready(ctx context.Context) *dto.HealthReport {
+	report := s.check(ctx)
+	for i := range report.Components {
+		report.Components[i].Error = ""
+	}
+	return report
+}
+
+func (s *healthService) Details(ctx context.Context) *dto.HealthDetails {
+	cfg := config.Get()
+	return &dto.HealthDetails{
+		HealthReport:  *s.check(ctx),
+		Version:       config.Version,
+		Commit:        config.BuildCommit(),
+		GoVersion:     runtime.Version(),
+		NodeID:        cfg.Server.NodeID,
+		StartedAt:     config.StartTime,
+		UptimeSeconds: int64(time.Since(config.StartTime).Seconds()),
+		Connections:   s.signaling.LocalClientCount(),
+		RoomCount:     s.room.GetRoomCount(ctx),
+	}
+}
+
+// check pings every configured dependency in parallel, bounded by
+// HEALTH_CHECK_TIMEOUT. A draining replica reports draining even when all
+// dependencies are up, so load balancers stop sending it new clients.
+func (s *healthService) check(ctx context.Context) *dto.HealthReport {
+	cfg := config.Get()
+	ctx, cancel := context.WithTimeout(ctx, cfg.Health.CheckTimeout)
+	defer cancel()
+
+	checks := []struct {
+		name    string
+		enabled bool
+		ping    func(context.Context) error
+	}{
+		{"database", true, s.repo.Health.PingDatabase},
+		{"redis", cfg.Matching.RoomStore == "redis" || cfg.Cluster.Bus == "redis", s.repo.Health.PingRedis},
+		{"bus", true, s.bus.Ping},
+	}
+
+	components := make([]dto.ComponentStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		if !check.enabled {
+			components[i] = dto.ComponentStatus{Name: check.name, Status: "disabled"}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check.ping(ctx)
+			component := dto.ComponentStatus{
+				Name:      check.name,
+				Status:    "up",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				component.Status = "down"
+				component.Error = err.Error()
+			}
+			components[i] = component
+		}()
+	}
+	wg.Wait()
+
+	status := "ready"
+	for _, component := range components {
+		if component.Status == "down" {
+			status = "not_ready"
+		}
+	}
+	if s.lifecycle.IsDraining() {
+		status = "draining"
+	}
+
+	return &dto.HealthReport{Status: status, Components: components}
+}
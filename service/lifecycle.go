@@ -0,0 +1,23 @@
+package service
+
+import (
+	"sync/atomic"
+
+	"projectwebcurhat/contract"
+)
+
+type lifecycleService struct {
+	draining atomic.Bool
+}
+
+func NewLifecycleService() contract.LifecycleService {
+	return &lifecycleService{}
+}
+
+func (s *lifecycleService) StartDraining() {
+	s.draining.Store(true)
+}
+
+func (s *lifecycleService) IsDraining() bool {
+	return s.draining.Load()
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"projectwebcurhat/dto"
+)
+
+// maxMessageIDLength bounds the id a client may attach, since it is echoed back
+const maxMessageIDLength = 64
+
+// maxReportReasonLength bounds the reason of a report, which admins read
+const maxReportReasonLength = 500
+
+// Bounds on hello, which is otherwise only limited by the message size
+const (
+	maxHelloEntries     = 16
+	maxCapabilityLength = 32
+)
+
+// messageError rejects a client message. The client is sent the code and
+// message in an error payload, and disconnected afterwards if asked for.
+type messageError struct {
+	code       string
+	message    string
+	disconnect bool
+}
+
+func (e *messageError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func reject(code, format string, args ...any) error {
+	return &messageError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// inboundMessage is everything a client may send. Sender, receiver and room
+// are filled in by the server, and the payload is decoded once the type is known.
+type inboundMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// legacyInboundMessage is what version 1 clients send. They put their own
+// sender, receiver, room and name next to the type, as in the messages they
+// receive, so those fields are accepted and ignored.
+type legacyInboundMessage struct {
+	inboundMessage
+	From     string `json:"from"`
+	To       string `json:"to"`
+	RoomID   string `json:"roomId"`
+	Username string `json:"username"`
+}
+
+// decodeMessage turns a client frame into a message with a typed payload.
+// Unknown fields, types and oversized or malformed payloads are rejected,
+// so nothing but a well-formed offer, answer or candidate reaches the peer.
+// The returned message carries the type and id as far as they were read,
+// for the error sent back. legacy accepts the extra fields of version 1.
+func (s *signalingService) decodeMessage(data []byte, legacy bool) (dto.Message, error) {
+	var in inboundMessage
+	var err error
+	if legacy {
+		var old legacyInboundMessage
+		err = decodeStrict(data, &old)
+		in = old.inboundMessage
+	} else {
+		err = decodeStrict(data, &in)
+	}
+	if err != nil {
+		return dto.Message{}, reject(dto.ErrorCodeInvalidMessage, "message must be a JSON object with type, id and payload: %v", err)
+	}
+	if len(in.ID) > maxMessageIDLength {
+		return dto.Message{Type: in.Type}, reject(dto.ErrorCodeInvalidMessage, "id is longer than %d bytes", maxMessageIDLength)
+	}
+
+	msg := dto.Message{Type: in.Type, ID: in.ID}
+	switch in.Type {
+	case dto.MessageTypeHello:
+		var hello dto.HelloPayload
+		if err := decodePayload(in.Payload, &hello); err != nil {
+			return msg, reject(dto.ErrorCodeInvalidPayload, "hello payload must be {versions, capabilities}: %v", err)
+		}
+		if err := checkHello(hello); err != nil {
+			return msg, err
+		}
+		msg.Payload = hello
+
+	case dto.MessageTypeReport:
+		var report dto.ReportPayload
+		if err := decodePayload(in.Payload, &report); err != nil {
+			return msg, reject(dto.ErrorCodeInvalidPayload, "report payload must be {reason, block}: %v", err)
+		}
+		if len(report.Reason) > maxReportReasonLength {
+			return msg, reject(dto.ErrorCodePayloadTooLarge, "reason is longer than %d bytes", maxReportReasonLength)
+		}
+		msg.Payload = report
+
+	case dto.MessageTypeJoin, dto.MessageTypeLeave:
+		if hasPayload(in.Payload) {
+			return msg, reject(dto.ErrorCodeInvalidPayload, "%s takes no payload", in.Type)
+		}
+
+	case dto.MessageTypeOffer, dto.MessageTypeAnswer:
+		var sdp dto.SDPMessage
+		if err := decodePayload(in.Payload, &sdp); err != nil {
+			return msg, reject(dto.ErrorCodeInvalidPayload, "%s payload must be {type, sdp}: %v", in.Type, err)
+		}
+		if err := s.checkSDP(in.Type, sdp); err != nil {
+			return msg, err
+		}
+		msg.Payload = sdp
+
+	case dto.MessageTypeCandidate:
+		var candidate dto.ICECandidateMessage
+		if err := decodePayload(in.Payload, &candidate); err != nil {
+			return msg, reject(dto.ErrorCodeInvalidPayload, "candidate payload must be {candidate, sdpMid, sdpMLineIndex}: %v", err)
+		}
+		if err := s.checkCandidate(candidate); err != nil {
+			return msg, err
+		}
+		msg.Payload = candidate
+
+	case "":
+		return msg, reject(dto.ErrorCodeInvalidMessage, "type is missing")
+
+	default:
+		return msg, reject(dto.ErrorCodeUnknownType, "unknown message type %q", in.Type)
+	}
+	return msg, nil
+}
+
+func checkHello(hello dto.HelloPayload) error {
+	if len(hello.Versions) == 0 || len(hello.Versions) > maxHelloEntries {
+		return reject(dto.ErrorCodeInvalidPayload, "hello must list between 1 and %d versions", maxHelloEntries)
+	}
+	if len(hello.Capabilities) > maxHelloEntries {
+		return reject(dto.ErrorCodeInvalidPayload, "hello lists more than %d capabilities", maxHelloEntries)
+	}
+	for _, capability := range hello.Capabilities {
+		if len(capability) > maxCapabilityLength {
+			return reject(dto.ErrorCodePayloadTooLarge, "capabilities are limited to %d bytes", maxCapabilityLength)
+		}
+	}
+	return nil
+}
+
+func (s *signalingService) checkSDP(msgType string, sdp dto.SDPMessage) error {
+	if sdp.Type != msgType {
+		return reject(dto.ErrorCodeInvalidPayload, "payload type %q does not match message type %q", sdp.Type, msgType)
+	}
+	if len(sdp.SDP) > s.limits.MaxSDPSize {
+		return reject(dto.ErrorCodePayloadTooLarge, "sdp is longer than %d bytes", s.limits.MaxSDPSize)
+	}
+	if err := parseSDP(sdp.SDP); err != nil {
+		return reject(dto.ErrorCodeInvalidSDP, "%v", err)
+	}
+	return nil
+}
+
+func (s *signalingService) checkCandidate(candidate dto.ICECandidateMessage) error {
+	limit := s.limits.MaxCandidateSize
+	if len(candidate.Candidate) > limit || len(candidate.SDPMid) > limit || len(candidate.UsernameFragment) > limit {
+		return reject(dto.ErrorCodePayloadTooLarge, "candidate fields are limited to %d bytes", limit)
+	}
+	if candidate.SDPMLineIndex < 0 {
+		return reject(dto.ErrorCodeInvalidCandidate, "sdpMLineIndex must not be negative")
+	}
+	if err := parseCandidate(candidate.Candidate); err != nil {
+		return reject(dto.ErrorCodeInvalidCandidate, "%v", err)
+	}
+	return nil
+}
+
+// parseSDP is a sanity check rather than a parser: the description has to
+// start with v=0, consist of <letter>=<value> lines and name an origin, a
+// session and at least one media section
+func parseSDP(sdp string) error {
+	lines := strings.Split(strings.TrimRight(sdp, "\r\n"), "\n")
+	if strings.TrimSuffix(lines[0], "\r") != "v=0" {
+		return errors.New("sdp must start with v=0")
+	}
+
+	seen := make(map[byte]bool)
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) < 2 || line[0] < 'a' || line[0] > 'z' || line[1] != '=' {
+			return fmt.Errorf("sdp line %d is not a <type>=<value> field", i+1)
+		}
+		seen[line[0]] = true
+	}
+
+	for _, required := range []struct {
+		field byte
+		name  string
+	}{{'o', "origin (o=)"}, {'s', "session name (s=)"}, {'m', "media description (m=)"}} {
+		if !seen[required.field] {
+			return fmt.Errorf("sdp has no %s line", required.name)
+		}
+	}
+	return nil
+}
+
+// parseCandidate checks the fixed part of a candidate attribute:
+// candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type>.
+// An empty candidate marks the end of candidates.
+func parseCandidate(candidate string) error {
+	if candidate == "" {
+		return nil
+	}
+	attribute, ok := strings.CutPrefix(candidate, "candidate:")
+	if !ok {
+		return errors.New(`candidate must start with "candidate:"`)
+	}
+
+	fields := strings.Fields(attribute)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return errors.New("candidate must be <foundation> <component> <transport> <priority> <address> <port> typ <type>")
+	}
+	for _, number := range []string{fields[1], fields[3], fields[5]} {
+		if _, err := strconv.ParseUint(number, 10, 32); err != nil {
+			return fmt.Errorf("candidate component, priority and port must be numbers, got %q", number)
+		}
+	}
+	return nil
+}
+
+// decodeStrict decodes exactly one JSON value and fails on unknown fields
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+func decodePayload(payload json.RawMessage, v any) error {
+	if !hasPayload(payload) {
+		return errors.New("payload is missing")
+	}
+	return decodeStrict(payload, v)
+}
+
+func hasPayload(payload json.RawMessage) bool {
+	return len(payload) > 0 && !bytes.Equal(payload, []byte("null"))
+}
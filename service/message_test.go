@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+const validSDP = "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n"
+
+// frame encodes a client message with an arbitrary payload
+func frame(t *testing.T, msgType string, payload any) string {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{"type": msgType, "payload": payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRejectedMessages(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+
+	tests := []struct {
+		name  string
+		frame string
+		code  string
+	}{
+		{"not JSON", "{", dto.ErrorCodeInvalidMessage},
+		{"unknown field", `{"type":"join","username":"User1"}`, dto.ErrorCodeInvalidMessage},
+		{"trailing data", `{"type":"join"} {}`, dto.ErrorCodeInvalidMessage},
+		{"missing type", `{"id":"1"}`, dto.ErrorCodeInvalidMessage},
+		{"long id", `{"type":"join","id":"` + strings.Repeat("x", 65) + `"}`, dto.ErrorCodeInvalidMessage},
+		{"unknown type", `{"type":"dance"}`, dto.ErrorCodeUnknownType},
+		{"join with payload", frame(t, dto.MessageTypeJoin, map[string]any{}), dto.ErrorCodeInvalidPayload},
+		{"offer without payload", `{"type":"offer"}`, dto.ErrorCodeInvalidPayload},
+		{"unknown payload field", frame(t, dto.MessageTypeOffer, map[string]any{"type": "offer", "sdp": validSDP, "extra": 1}), dto.ErrorCodeInvalidPayload},
+		{"mismatched sdp type", frame(t, dto.MessageTypeOffer, dto.SDPMessage{Type: "answer", SDP: validSDP}), dto.ErrorCodeInvalidPayload},
+		{"sdp over the limit", frame(t, dto.MessageTypeAnswer, dto.SDPMessage{Type: "answer", SDP: validSDP + strings.Repeat("a=x\r\n", 8000)}), dto.ErrorCodePayloadTooLarge},
+		{"sdp without version", frame(t, dto.MessageTypeOffer, dto.SDPMessage{Type: "offer", SDP: "o=- 1 1 IN IP4 127.0.0.1\r\n"}), dto.ErrorCodeInvalidSDP},
+		{"sdp with a bad line", frame(t, dto.MessageTypeOffer, dto.SDPMessage{Type: "offer", SDP: "v=0\r\nhello\r\n"}), dto.ErrorCodeInvalidSDP},
+		{"sdp without media", frame(t, dto.MessageTypeOffer, dto.SDPMessage{Type: "offer", SDP: "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\n"}), dto.ErrorCodeInvalidSDP},
+		{"candidate without prefix", frame(t, dto.MessageTypeCandidate, dto.ICECandidateMessage{Candidate: "1 1 udp 1 127.0.0.1 50000 typ host"}), dto.ErrorCodeInvalidCandidate},
+		{"candidate too short", frame(t, dto.MessageTypeCandidate, dto.ICECandidateMessage{Candidate: "candidate:1 1 udp 1 127.0.0.1"}), dto.ErrorCodeInvalidCandidate},
+		{"candidate with a bad port", frame(t, dto.MessageTypeCandidate, dto.ICECandidateMessage{Candidate: "candidate:1 1 udp 1 127.0.0.1 port typ host"}), dto.ErrorCodeInvalidCandidate},
+		{"negative sdpMLineIndex", frame(t, dto.MessageTypeCandidate, dto.ICECandidateMessage{SDPMLineIndex: -1}), dto.ErrorCodeInvalidCandidate},
+		{"candidate over the limit", frame(t, dto.MessageTypeCandidate, dto.ICECandidateMessage{Candidate: "candidate:" + strings.Repeat("1", 1024)}), dto.ErrorCodePayloadTooLarge},
+		{"report without payload", `{"type":"report"}`, dto.ErrorCodeInvalidPayload},
+		{"report reason over the limit", frame(t, dto.MessageTypeReport, dto.ReportPayload{Reason: strings.Repeat("x", 501)}), dto.ErrorCodePayloadTooLarge},
+		{"too many versions", frame(t, dto.MessageTypeHello, dto.HelloPayload{Versions: make([]int, 17)}), dto.ErrorCodeInvalidPayload},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := s.Dial("")
+			defer client.Close()
+			client.Hello()
+
+			client.SendRaw(test.frame)
+			var payload dto.ErrorPayload
+			testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &payload)
+			if payload.Code != test.code {
+				t.Fatalf("error code %q (%s), want %q", payload.Code, payload.Message, test.code)
+			}
+
+			// None of these end the connection
+			client.SendRaw(`{"type":"dance","id":"alive"}`)
+			testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &payload)
+			if payload.Ref != "alive" {
+				t.Fatalf("error refers to %q, want the message after the rejected one", payload.Ref)
+			}
+		})
+	}
+}
+
+func TestFrameOverTheLimitDisconnects(t *testing.T) {
+	s := testutil.NewServer(t, map[string]string{"websocket.max_message_size": "4096", "websocket.max_sdp_size": "2048", "websocket.max_candidate_size": "512"})
+	client := s.Dial("")
+	client.Hello()
+
+	client.SendRaw(frame(t, dto.MessageTypeOffer, dto.SDPMessage{Type: "offer", SDP: validSDP + strings.Repeat("a=x\r\n", 1000)}))
+	client.ExpectClosed()
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+)
+
+// negotiate is the server's half of perfect negotiation. It checks an offer
+// or answer against the room's negotiation state and returns the messages
+// to relay to the peer:
+//   - an offer starts a negotiation, unless one is pending. Then a further
+//     offer of the same peer waits for the answer, and on a collision the
+//     impolite peer's offer wins while the polite peer's is rejected.
+//   - an answer must answer the pending offer of the other peer. A waiting
+//     offer is relayed right after it, back to the answering peer.
+func (s *signalingService) negotiate(ctx context.Context, room *database.Room, client *database.Client, msg *dto.Message) ([]*dto.Message, error) {
+	polite := room.Initiator().ID != client.ID
+
+	var relay []*dto.Message
+	err := s.roomService.UpdateNegotiation(ctx, room.ID, func(negotiation *database.Negotiation) error {
+		// Runs again when another replica changed the state meanwhile
+		relay = nil
+
+		switch msg.Type {
+		case dto.MessageTypeOffer:
+			switch {
+			case negotiation.State != database.NegotiationOfferPending:
+				negotiation.State, negotiation.Offerer = database.NegotiationOfferPending, client.ID
+				relay = []*dto.Message{msg}
+			case negotiation.Offerer == client.ID:
+				queued, err := json.Marshal(msg)
+				if err != nil {
+					return err
+				}
+				negotiation.Queued = queued
+			case polite:
+				return reject(dto.ErrorCodeGlare, "The peer's offer takes precedence, answer it instead")
+			default:
+				// The polite peer rolls its offer back when it sees this one
+				negotiation.Offerer, negotiation.Queued = client.ID, nil
+				relay = []*dto.Message{msg}
+			}
+
+		case dto.MessageTypeAnswer:
+			if negotiation.State != database.NegotiationOfferPending || negotiation.Offerer == client.ID {
+				return reject(dto.ErrorCodeOutOfOrder, "There is no offer from the peer to answer")
+			}
+			relay = []*dto.Message{msg}
+			negotiation.State, negotiation.Offerer = database.NegotiationAnswered, ""
+
+			if negotiation.Queued != nil {
+				var queued dto.Message
+				if err := json.Unmarshal(negotiation.Queued, &queued); err != nil {
+					return err
+				}
+				relay = append(relay, &queued)
+				negotiation.State, negotiation.Offerer, negotiation.Queued = database.NegotiationOfferPending, queued.From, nil
+			}
+		}
+		return nil
+	})
+	return relay, err
+}
+
+// joinPayload tells recipient whether it is the initiator of the call
+func joinPayload(recipient, initiator *database.Client) dto.JoinPayload {
+	isInitiator := recipient.ID == initiator.ID
+	return dto.JoinPayload{Initiator: isInitiator, Polite: !isInitiator}
+}
@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+// negotiatingPair matches two version 2 clients and returns them as the
+// impolite initiator and the polite peer, as told in their join messages
+func negotiatingPair(t *testing.T, s *testutil.Server) (impolite, polite *testutil.Client) {
+	t.Helper()
+
+	first, second := s.Dial(""), s.Dial("")
+	first.Hello()
+	second.Hello()
+	first.Send(dto.Message{Type: dto.MessageTypeJoin})
+	first.Expect(dto.MessageTypeReady)
+	second.Send(dto.Message{Type: dto.MessageTypeJoin})
+	second.Expect(dto.MessageTypeReady)
+
+	var firstJoin, secondJoin dto.JoinPayload
+	testutil.DecodePayload(t, first.Expect(dto.MessageTypeJoin), &firstJoin)
+	testutil.DecodePayload(t, second.Expect(dto.MessageTypeJoin), &secondJoin)
+	if firstJoin.Polite == secondJoin.Polite {
+		t.Fatalf("join payloads %+v and %+v, want one polite peer", firstJoin, secondJoin)
+	}
+	if firstJoin.Polite {
+		return second, first
+	}
+	return first, second
+}
+
+// sdp is an offer or answer with the given id
+func sdp(id, sdpType string) dto.Message {
+	return dto.Message{Type: sdpType, ID: id, Payload: dto.SDPMessage{Type: sdpType, SDP: validSDP}}
+}
+
+// expectErrorCode receives an error and fails unless it has code and refers to ref
+func expectErrorCode(t *testing.T, client *testutil.Client, code, ref string) {
+	t.Helper()
+
+	var payload dto.ErrorPayload
+	testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &payload)
+	if payload.Code != code || payload.Ref != ref {
+		t.Fatalf("error %q for %q, want %q for %q", payload.Code, payload.Ref, code, ref)
+	}
+}
+
+func expectID(t *testing.T, client *testutil.Client, msgType, id string) {
+	t.Helper()
+
+	if msg := client.Expect(msgType); msg.ID != id {
+		t.Fatalf("got %s %q, want %q", msgType, msg.ID, id)
+	}
+}
+
+func TestGlarePolitePeerYields(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	impolite, polite := negotiatingPair(t, s)
+
+	impolite.Send(sdp("impolite-offer", dto.MessageTypeOffer))
+	expectID(t, polite, dto.MessageTypeOffer, "impolite-offer")
+
+	polite.Send(sdp("polite-offer", dto.MessageTypeOffer))
+	expectErrorCode(t, polite, dto.ErrorCodeGlare, "polite-offer")
+
+	polite.Send(sdp("polite-answer", dto.MessageTypeAnswer))
+	expectID(t, impolite, dto.MessageTypeAnswer, "polite-answer")
+}
+
+func TestGlareImpolitePeerWins(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	impolite, polite := negotiatingPair(t, s)
+
+	polite.Send(sdp("polite-offer", dto.MessageTypeOffer))
+	expectID(t, impolite, dto.MessageTypeOffer, "polite-offer")
+
+	// The impolite peer ignores the polite offer and sends its own
+	impolite.Send(sdp("impolite-offer", dto.MessageTypeOffer))
+	expectID(t, polite, dto.MessageTypeOffer, "impolite-offer")
+
+	// The answer to the rolled back offer has nothing to answer any more
+	impolite.Send(sdp("impolite-answer", dto.MessageTypeAnswer))
+	expectErrorCode(t, impolite, dto.ErrorCodeOutOfOrder, "impolite-answer")
+
+	polite.Send(sdp("polite-answer", dto.MessageTypeAnswer))
+	expectID(t, impolite, dto.MessageTypeAnswer, "polite-answer")
+}
+
+func TestSecondOfferWaitsForTheAnswer(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	impolite, polite := negotiatingPair(t, s)
+
+	impolite.Send(sdp("offer-1", dto.MessageTypeOffer))
+	expectID(t, polite, dto.MessageTypeOffer, "offer-1")
+	impolite.Send(sdp("offer-2", dto.MessageTypeOffer))
+
+	// The queued offer follows the answer that released it
+	polite.Send(sdp("answer-1", dto.MessageTypeAnswer))
+	expectID(t, impolite, dto.MessageTypeAnswer, "answer-1")
+	expectID(t, polite, dto.MessageTypeOffer, "offer-2")
+
+	polite.Send(sdp("answer-2", dto.MessageTypeAnswer))
+	expectID(t, impolite, dto.MessageTypeAnswer, "answer-2")
+}
+
+func TestStrayAnswersAreRejected(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	impolite, polite := negotiatingPair(t, s)
+
+	polite.Send(sdp("early", dto.MessageTypeAnswer))
+	expectErrorCode(t, polite, dto.ErrorCodeOutOfOrder, "early")
+
+	impolite.Send(sdp("offer", dto.MessageTypeOffer))
+	expectID(t, polite, dto.MessageTypeOffer, "offer")
+	impolite.Send(sdp("own", dto.MessageTypeAnswer))
+	expectErrorCode(t, impolite, dto.ErrorCodeOutOfOrder, "own")
+
+	polite.Send(sdp("answer", dto.MessageTypeAnswer))
+	expectID(t, impolite, dto.MessageTypeAnswer, "answer")
+	polite.Send(sdp("again", dto.MessageTypeAnswer))
+	expectErrorCode(t, polite, dto.ErrorCodeOutOfOrder, "again")
+}
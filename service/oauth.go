@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oauthStateTTL       = 10 * time.Minute
+	oauthRequestTimeout = 10 * time.Second
+)
+
+var usernameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// oidcClaims are the ID token claims used to find or create the local user
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcProvider lazily runs OIDC discovery so a provider that is down at
+// startup does not keep the server from booting
+type oidcProvider struct {
+	cfg      config.OAuthProvider
+	mutex    sync.Mutex
+	provider *oidc.Provider
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.cfg.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *oidcProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+type oauthService struct {
+	repo      *contract.Repository
+	providers map[string]*oidcProvider
+}
+
+func NewOAuthService(repo *contract.Repository, providers map[string]config.OAuthProvider) contract.OAuthService {
+	s := &oauthService{
+		repo:      repo,
+		providers: make(map[string]*oidcProvider, len(providers)),
+	}
+	for name, cfg := range providers {
+		s.providers[name] = &oidcProvider{cfg: cfg}
+	}
+	return s
+}
+
+func (s *oauthService) StartLogin(ctx context.Context, providerName string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "OAuthService.StartLogin")
+	defer func() { endSpan(span, err) }()
+
+	p, ok := s.providers[providerName]
+	if !ok {
+		return "", errs.NotFound("Unknown login provider")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oauthRequestTimeout)
+	defer cancel()
+
+	provider, err := p.discover(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "OIDC discovery failed", "provider", providerName, "error", err)
+		return "", errs.InternalServerError("Login provider is unavailable")
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", errs.InternalServerError("Failed to start login")
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", errs.InternalServerError("Failed to start login")
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	err = s.repo.OAuthState.SaveState(ctx, &database.OAuthState{
+		State:        state,
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save OAuth state", "provider", providerName, "error", err)
+		return "", errs.InternalServerError("Failed to start login")
+	}
+
+	return p.oauth2Config(provider).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	), nil
+}
+
+func (s *oauthService) HandleCallback(ctx context.Context, providerName string, payload *dto.OAuthCallbackRequest, meta *dto.SessionMeta) (_ *dto.AuthResponse, err error) {
+	ctx, span := tracer.Start(ctx, "OAuthService.HandleCallback")
+	defer func() { endSpan(span, err) }()
+
+	p, ok := s.providers[providerName]
+	if !ok {
+		return nil, errs.NotFound("Unknown login provider")
+	}
+
+	if payload.Error != "" {
+		return nil, errs.Unauthorized(fmt.Sprintf("Login was not completed: %s", payload.Error))
+	}
+	if payload.Code == "" || payload.State == "" {
+		return nil, errs.BadRequest("Missing code or state")
+	}
+
+	pending, err := s.repo.OAuthState.TakeState(ctx, payload.State)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read OAuth state", "provider", providerName, "error", err)
+		return nil, errs.InternalServerError("Failed to check login state")
+	}
+	if pending == nil || pending.Provider != providerName {
+		return nil, errs.Unauthorized("Invalid or expired login state")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oauthRequestTimeout)
+	defer cancel()
+
+	provider, err := p.discover(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "OIDC discovery failed", "provider", providerName, "error", err)
+		return nil, errs.InternalServerError("Login provider is unavailable")
+	}
+
+	oauthToken, err := p.oauth2Config(provider).Exchange(ctx, payload.Code, oauth2.VerifierOption(pending.CodeVerifier))
+	if err != nil {
+		slog.WarnContext(ctx, "OIDC code exchange failed", "provider", providerName, "error", err)
+		return nil, errs.Unauthorized("Failed to exchange authorization code")
+	}
+
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		return nil, errs.Unauthorized("Provider did not return an ID token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		slog.WarnContext(ctx, "OIDC ID token verification failed", "provider", providerName, "error", err)
+		return nil, errs.Unauthorized("Invalid ID token")
+	}
+	if idToken.Nonce != pending.Nonce {
+		return nil, errs.Unauthorized("Invalid ID token nonce")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errs.Unauthorized("Invalid ID token claims")
+	}
+
+	user, err := s.findOrCreateUser(ctx, providerName, idToken.Subject, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuthResponse(ctx, s.repo, user, meta)
+}
+
+// findOrCreateUser resolves the local user for an external identity. Known
+// identities log straight in, otherwise the identity is linked to the user
+// with the same email, or a new user is created. Linking needs the email to
+// be verified on both sides: whoever signed up with a password never proved
+// the address, and could otherwise take over the provider's account.
+func (s *oauthService) findOrCreateUser(ctx context.Context, providerName, subject string, claims *oidcClaims) (*database.User, error) {
+	identity, err := s.repo.User.GetIdentity(ctx, providerName, subject)
+	if err == nil {
+		user, err := s.repo.User.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, errs.InternalServerError("Failed to find user")
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errs.InternalServerError("Failed to check identity")
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errs.Forbidden("Provider account has no verified email")
+	}
+
+	user, err := s.repo.User.GetUserByEmail(ctx, claims.Email)
+	if err == nil && !user.EmailVerified {
+		return nil, errs.Forbidden("An account with this email already exists, sign in with its password")
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errs.InternalServerError("Failed to check email")
+		}
+
+		username, err := s.availableUsername(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+
+		// Social accounts have no password, so password login stays impossible until one is set
+		user, err = s.repo.User.CreateUser(ctx, &database.User{
+			Username:      username,
+			Email:         claims.Email,
+			EmailVerified: true,
+		})
+		if err != nil {
+			return nil, errs.InternalServerError("Failed to create user")
+		}
+	}
+
+	if _, err := s.repo.User.CreateIdentity(ctx, &database.UserIdentity{
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  subject,
+		Email:    claims.Email,
+	}); err != nil {
+		return nil, errs.InternalServerError("Failed to link identity")
+	}
+
+	return user, nil
+}
+
+// availableUsername derives a username from the provider profile and adds a
+// numeric suffix until it does not collide with an existing user
+func (s *oauthService) availableUsername(ctx context.Context, claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	base = usernameSanitizer.ReplaceAllString(base, "")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 1; i <= 100; i++ {
+		_, err := s.repo.User.GetUserByUsername(ctx, candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", errs.InternalServerError("Failed to check username")
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+
+	return "", errs.InternalServerError("Failed to pick a username")
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,273 @@
+package service_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const oauthClientID = "webcurhat-test"
+
+// mockIssuer is an OIDC provider with discovery, keys and a token endpoint.
+// The test plays the user at the authorization endpoint: authorize hands out
+// a code for the claims it is given, as if the user had signed in.
+type mockIssuer struct {
+	*httptest.Server
+	t     *testing.T
+	key   *rsa.PrivateKey
+	mutex sync.Mutex
+	codes map[string]pendingCode
+}
+
+type pendingCode struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{t: t, key: key, codes: make(map[string]pendingCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("POST /token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// authorize signs the user in at the provider and returns the code the
+// provider would redirect back with
+func (m *mockIssuer) authorize(authURL *url.URL, claims map[string]any) string {
+	query := authURL.Query()
+	if query.Get("client_id") != oauthClientID || query.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	token := map[string]any{
+		"iss":   m.URL,
+		"aud":   oauthClientID,
+		"nonce": query.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for key, value := range claims {
+		token[key] = value
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = pendingCode{challenge: query.Get("code_challenge"), claims: token}
+	return code
+}
+
+// token redeems a code once, checking the PKCE verifier against the challenge
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	pending, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != pending.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: m.key, KeyID: "test"},
+	}, nil)
+	if err != nil {
+		m.t.Error(err)
+		return
+	}
+	payload, _ := json.Marshal(pending.claims)
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		m.t.Error(err)
+		return
+	}
+	idToken, _ := signed.CompactSerialize()
+
+	writeJSON(w, map[string]any{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// newOAuthServer starts a server with the providers "mock" and "other",
+// both signing in at issuer
+func newOAuthServer(t *testing.T, issuer *mockIssuer) *testutil.Server {
+	t.Setenv("OAUTH_PROVIDERS", "mock,other")
+	for _, prefix := range []string{"OAUTH_MOCK_", "OAUTH_OTHER_"} {
+		t.Setenv(prefix+"ISSUER_URL", issuer.URL)
+		t.Setenv(prefix+"CLIENT_ID", oauthClientID)
+		t.Setenv(prefix+"CLIENT_SECRET", "secret")
+		t.Setenv(prefix+"REDIRECT_URL", "http://localhost/callback")
+	}
+	return testutil.NewServer(t, nil)
+}
+
+// oauthLogin runs a social login through provider for claims and returns the
+// callback's status, with the auth response when it succeeded
+func oauthLogin(t *testing.T, s *testutil.Server, issuer *mockIssuer, provider string, claims map[string]any) (int, *dto.AuthResponse) {
+	t.Helper()
+
+	client := *s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := client.Get(s.URL + "/auth/oauth/" + provider + "/start")
+	if err != nil {
+		t.Fatalf("starting login: %v", err)
+	}
+	resp.Body.Close()
+	authURL, err := resp.Location()
+	if resp.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("start answered %d, want a redirect: %v", resp.StatusCode, err)
+	}
+
+	code := issuer.authorize(authURL, claims)
+	return oauthCallback(t, s, provider, code, authURL.Query().Get("state"))
+}
+
+func oauthCallback(t *testing.T, s *testutil.Server, provider, code, state string) (int, *dto.AuthResponse) {
+	t.Helper()
+
+	query := url.Values{"code": {code}, "state": {state}}
+	resp, err := s.Client().Get(s.URL + "/auth/oauth/" + provider + "/callback?" + query.Encode())
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	var envelope struct {
+		Data dto.AuthResponse `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decoding the callback response: %v", err)
+	}
+	return resp.StatusCode, &envelope.Data
+}
+
+func TestOAuthLoginCreatesTheUserOnce(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newOAuthServer(t, issuer)
+	claims := map[string]any{"sub": "alice-1", "email": "alice@example.test", "email_verified": true, "preferred_username": "alice"}
+
+	status, first := oauthLogin(t, s, issuer, "mock", claims)
+	if status != http.StatusOK {
+		t.Fatalf("first login answered %d", status)
+	}
+	if first.Token == "" || first.User.Username != "alice" {
+		t.Fatalf("first login returned %+v", first)
+	}
+	user, err := s.Repository.User.GetUserByID(t.Context(), first.User.ID)
+	if err != nil || !user.EmailVerified {
+		t.Fatalf("created user is %+v, %v, want the email verified", user, err)
+	}
+
+	status, second := oauthLogin(t, s, issuer, "mock", claims)
+	if status != http.StatusOK || second.User.ID != first.User.ID {
+		t.Fatalf("second login answered %d with %+v, want user %d", status, second, first.User.ID)
+	}
+}
+
+func TestOAuthStateIsUsedOnce(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newOAuthServer(t, issuer)
+
+	client := *s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(s.URL + "/auth/oauth/mock/start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	authURL, _ := resp.Location()
+	state := authURL.Query().Get("state")
+
+	claims := map[string]any{"sub": "alice-1", "email": "alice@example.test", "email_verified": true}
+	if status, _ := oauthCallback(t, s, "other", issuer.authorize(authURL, claims), state); status != http.StatusUnauthorized {
+		t.Fatalf("state of mock accepted by other with %d", status)
+	}
+	// The state was taken by the failed attempt
+	if status, _ := oauthCallback(t, s, "mock", issuer.authorize(authURL, claims), state); status != http.StatusUnauthorized {
+		t.Fatalf("reused state answered %d, want 401", status)
+	}
+}
+
+func TestOAuthLinksOnlyVerifiedEmails(t *testing.T) {
+	issuer := newMockIssuer(t)
+	s := newOAuthServer(t, issuer)
+
+	// Alice signed in with mock, so her email is verified and other links to her
+	status, alice := oauthLogin(t, s, issuer, "mock", map[string]any{"sub": "alice-1", "email": "alice@example.test", "email_verified": true})
+	if status != http.StatusOK {
+		t.Fatalf("alice's login answered %d", status)
+	}
+	status, linked := oauthLogin(t, s, issuer, "other", map[string]any{"sub": "alice-2", "email": "alice@example.test", "email_verified": true})
+	if status != http.StatusOK || linked.User.ID != alice.User.ID {
+		t.Fatalf("other login answered %d with %+v, want alice's account", status, linked)
+	}
+
+	// Anyone could have registered bob's address with a password
+	s.Register("bob", "bob@example.test", "password123")
+	status, _ = oauthLogin(t, s, issuer, "mock", map[string]any{"sub": "bob-1", "email": "bob@example.test", "email_verified": true})
+	if status != http.StatusForbidden {
+		t.Fatalf("login for an unverified local account answered %d, want 403", status)
+	}
+	if _, err := s.Repository.User.GetIdentity(t.Context(), "mock", "bob-1"); err == nil {
+		t.Fatal("identity was linked to the unverified account")
+	}
+
+	status, _ = oauthLogin(t, s, issuer, "mock", map[string]any{"sub": "carol-1", "email": "carol@example.test", "email_verified": false})
+	if status != http.StatusForbidden {
+		t.Fatalf("login with an unverified provider email answered %d, want 403", status)
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+)
+
+// serverCapabilities are the optional features this server offers in hello.
+// Session resume and SFU calls are not implemented yet.
+var serverCapabilities = []string{dto.CapabilityChat}
+
+// messageHandler acts on one decoded message
+type messageHandler func(ctx context.Context, client *database.Client, msg *dto.Message)
+
+// protocol is one version of the signaling protocol. A new version gets its
+// own handlers and error format, so clients on an older version keep the
+// behaviour they were built against.
+type protocol struct {
+	version int
+	// handlers by message type; other types are unknown in this version
+	handlers map[string]messageHandler
+	// errorPayload builds the payload of error messages
+	errorPayload func(payload dto.ErrorPayload) any
+}
+
+func (s *signalingService) newProtocols() map[int]*protocol {
+	handleLeave := func(ctx context.Context, client *database.Client, _ *dto.Message) {
+		s.handleLeave(ctx, client)
+	}
+
+	// Version 1 is what clients spoke before hello; errors are plain text
+	v1 := &protocol{
+		version: dto.ProtocolVersion1,
+		handlers: map[string]messageHandler{
+			dto.MessageTypeJoin:      s.handleJoin,
+			dto.MessageTypeOffer:     s.relayMessage,
+			dto.MessageTypeAnswer:    s.relayMessage,
+			dto.MessageTypeCandidate: s.relayMessage,
+			dto.MessageTypeLeave:     handleLeave,
+		},
+		errorPayload: func(payload dto.ErrorPayload) any { return payload.Message },
+	}
+
+	// Version 2 starts with hello, has structured errors and lets clients report their peer
+	v2 := &protocol{
+		version:      dto.ProtocolVersion2,
+		handlers:     maps.Clone(v1.handlers),
+		errorPayload: func(payload dto.ErrorPayload) any { return payload },
+	}
+	v2.handlers[dto.MessageTypeReport] = s.handleReport
+
+	return map[int]*protocol{v1.version: v1, v2.version: v2}
+}
+
+// protocolOf returns the client's protocol. Until one is settled, that is
+// version 1: a client that has not sent hello may predate it, and only
+// understands plain text errors.
+func (s *signalingService) protocolOf(client *database.Client) *protocol {
+	if p, ok := s.protocols[client.Protocol]; ok {
+		return p
+	}
+	return s.protocols[dto.ProtocolVersion1]
+}
+
+// errorProtocol picks the format of the error about msg. A client that
+// sent a hello knows structured errors, even when the hello is refused.
+func (s *signalingService) errorProtocol(client *database.Client, msg *dto.Message) *protocol {
+	if client.Protocol == 0 && msg.Type == dto.MessageTypeHello {
+		return s.protocols[dto.LatestProtocolVersion]
+	}
+	return s.protocolOf(client)
+}
+
+// settleLegacy puts a client whose first message is not a hello on version
+// 1, which is what it was built for, or tells it to upgrade. Only a decoded
+// message counts: a frame that could not be read says nothing about the
+// version, so the client may still send a hello after it.
+func (s *signalingService) settleLegacy(client *database.Client, msg *dto.Message) error {
+	if client.Protocol != 0 || msg.Type == dto.MessageTypeHello {
+		return nil
+	}
+	client.Protocol = dto.ProtocolVersion1
+	return s.checkVersion(dto.ProtocolVersion1)
+}
+
+// dispatch hands msg to the handler of the client's protocol version
+func (s *signalingService) dispatch(ctx context.Context, client *database.Client, msg *dto.Message) error {
+	if msg.Type == dto.MessageTypeHello {
+		return s.handleHello(client, msg)
+	}
+
+	p := s.protocolOf(client)
+	handler, ok := p.handlers[msg.Type]
+	if !ok {
+		return reject(dto.ErrorCodeUnknownType, "%s is not part of protocol version %d", msg.Type, p.version)
+	}
+	handler(ctx, client, msg)
+	return nil
+}
+
+// handleHello settles the highest protocol version both sides speak and the
+// capabilities both support, and answers with them and the message limits
+func (s *signalingService) handleHello(client *database.Client, msg *dto.Message) error {
+	if client.Protocol != 0 {
+		return reject(dto.ErrorCodeInvalidMessage, "hello must be the first message")
+	}
+	hello := msg.Payload.(dto.HelloPayload)
+
+	version := 0
+	for _, v := range hello.Versions {
+		if _, ok := s.protocols[v]; ok && v >= s.limits.MinProtocolVersion {
+			version = max(version, v)
+		}
+	}
+	if version == 0 {
+		return s.checkVersion(slices.Max(hello.Versions))
+	}
+
+	capabilities := []string{}
+	for _, capability := range serverCapabilities {
+		if slices.Contains(hello.Capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+
+	client.Protocol = version
+	client.Capabilities = capabilities
+	s.sendToClient(client, &dto.Message{
+		Type: dto.MessageTypeHello,
+		From: "server",
+		Payload: dto.HelloReplyPayload{
+			Version:      version,
+			Capabilities: capabilities,
+			Limits: dto.SignalingLimits{
+				MaxMessageSize:   s.limits.MaxMessageSize,
+				MaxSDPSize:       s.limits.MaxSDPSize,
+				MaxCandidateSize: s.limits.MaxCandidateSize,
+			},
+		},
+	})
+	client.Logger().Debug("Protocol negotiated", "version", version, "capabilities", capabilities)
+	return nil
+}
+
+// checkVersion fails for a version the server does not speak. Either error
+// closes the connection, since nothing else the client sends would be understood.
+func (s *signalingService) checkVersion(version int) error {
+	switch {
+	case version < s.limits.MinProtocolVersion:
+		return &messageError{
+			code:       dto.ErrorCodeUpgradeRequired,
+			message:    "This app version is no longer supported, please update it",
+			disconnect: true,
+		}
+	case version > dto.LatestProtocolVersion:
+		return &messageError{
+			code:       dto.ErrorCodeUnsupportedVersion,
+			message:    fmt.Sprintf("Server speaks protocol versions %d to %d", s.limits.MinProtocolVersion, dto.LatestProtocolVersion),
+			disconnect: true,
+		}
+	}
+	return nil
+}
+
+// hasCapability reports whether the client may use an optional feature.
+// Version 1 clients predate capabilities and always had chat.
+func hasCapability(client *database.Client, capability string) bool {
+	if client.Protocol == dto.ProtocolVersion1 {
+		return capability == dto.CapabilityChat
+	}
+	return slices.Contains(client.Capabilities, capability)
+}
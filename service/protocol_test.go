@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+func TestUnreadableFirstFrameLeavesProtocolOpen(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	client := s.Dial("")
+
+	// The client may be a version 1 one, so the error is plain text
+	client.SendRaw("not json")
+	var message string
+	testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &message)
+	if message == "" {
+		t.Fatal("error has no message")
+	}
+
+	if hello := client.Hello(); hello.Version != dto.LatestProtocolVersion {
+		t.Fatalf("hello after an unreadable frame settled version %d, want %d", hello.Version, dto.LatestProtocolVersion)
+	}
+}
+
+func TestLegacyJoinFrame(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	client := s.Dial("")
+
+	// Version 1 clients send their name along with join
+	client.SendRaw(`{"type":"join","username":"User1"}`)
+	client.Expect(dto.MessageTypeReady)
+}
+
+func TestHelloRefusedWithStructuredError(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	client := s.Dial("")
+
+	// A client sending hello knows structured errors, even before it is settled
+	client.Send(dto.Message{Type: dto.MessageTypeHello, Payload: dto.HelloPayload{Versions: []int{dto.LatestProtocolVersion + 1}}})
+	var payload dto.ErrorPayload
+	testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &payload)
+	if payload.Code != dto.ErrorCodeUnsupportedVersion {
+		t.Fatalf("error code %q, want %q", payload.Code, dto.ErrorCodeUnsupportedVersion)
+	}
+	client.ExpectClosed()
+}
+
+func TestLegacyClientGetsPlainErrors(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	client := s.Dial("")
+
+	// A decoded message other than hello settles version 1
+	client.Send(dto.Message{Type: dto.MessageTypeJoin})
+	client.Expect(dto.MessageTypeReady)
+
+	client.SendRaw(`{"type":"dance"}`)
+	var message string
+	testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &message)
+	if message == "" {
+		t.Fatal("version 1 error has no message")
+	}
+
+	client.Send(dto.Message{Type: dto.MessageTypeHello, Payload: dto.HelloPayload{Versions: []int{dto.LatestProtocolVersion}}})
+	testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &message)
+}
+
+func TestLegacyClientBelowMinimumVersion(t *testing.T) {
+	s := testutil.NewServer(t, map[string]string{"websocket.min_protocol_version": "2"})
+	client := s.Dial("")
+
+	// The client is told in the version 1 format it understands
+	client.Send(dto.Message{Type: dto.MessageTypeJoin})
+	var message string
+	testutil.DecodePayload(t, client.Expect(dto.MessageTypeError), &message)
+	if message == "" {
+		t.Fatal("upgrade error has no message")
+	}
+	client.ExpectClosed()
+}
@@ -0,0 +1,97 @@
+package service_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+// joinAs connects with the access token, negotiates the latest protocol and
+// joins. It returns the client and the room it was put in.
+func joinAs(t *testing.T, s *testutil.Server, accessToken string) (*testutil.Client, string) {
+	t.Helper()
+
+	client := s.Dial(accessToken)
+	client.Hello()
+	client.Send(dto.Message{Type: dto.MessageTypeJoin})
+	return client, client.Expect(dto.MessageTypeReady).RoomID
+}
+
+func report(reason string, block bool) dto.Message {
+	return dto.Message{Type: dto.MessageTypeReport, ID: "report", Payload: dto.ReportPayload{Reason: reason, Block: block}}
+}
+
+func TestGuestBlocksPeer(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	var guestAuth dto.AuthResponse
+	s.PostJSON("/auth/guest", struct{}{}, http.StatusCreated, &guestAuth)
+	bobToken := s.Register("bob", "bob@example.test", "password123")
+
+	guest, guestRoom := joinAs(t, s, guestAuth.Token)
+	bob, bobRoom := joinAs(t, s, bobToken)
+	if bobRoom != guestRoom {
+		t.Fatalf("bob is in room %s, want the guest's %s", bobRoom, guestRoom)
+	}
+	guest.Expect(dto.MessageTypeJoin)
+	bob.Expect(dto.MessageTypeJoin)
+
+	// Blocking ends the call like leave, and is kept for the admins
+	guest.Send(report("rude", true))
+	bob.Expect(dto.MessageTypeLeave)
+	guest.ExpectClosed()
+	bob.Close()
+
+	reports, err := s.Service.Admin.ListReports(context.Background())
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("reports are %+v, %v, want the guest's", reports, err)
+	}
+	if got := reports[0]; got.ReporterID != guestAuth.User.ID || got.ReportedUsername != "bob" || !got.Blocked || got.Reason != "rude" {
+		t.Fatalf("report is %+v", got)
+	}
+
+	// The two are not matched again, but each is matched with others
+	guest, guestRoom = joinAs(t, s, guestAuth.Token)
+	if _, bobRoom := joinAs(t, s, bobToken); bobRoom == guestRoom {
+		t.Fatal("bob was matched with the guest who blocked him")
+	}
+
+	carolToken := s.Register("carol", "carol@example.test", "password123")
+	if _, carolRoom := joinAs(t, s, carolToken); carolRoom != guestRoom {
+		t.Fatalf("carol is in room %s, want the waiting guest's %s", carolRoom, guestRoom)
+	}
+	guest.Expect(dto.MessageTypeJoin)
+}
+
+func TestReportWithoutBlockKeepsTheCall(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	alice, _ := joinAs(t, s, s.Register("alice", "alice@example.test", "password123"))
+	bob, _ := joinAs(t, s, s.Register("bob", "bob@example.test", "password123"))
+	alice.Expect(dto.MessageTypeJoin)
+	bob.Expect(dto.MessageTypeJoin)
+
+	alice.Send(report("spam", false))
+	testutil.WaitFor(t, "the report to be saved", func() bool {
+		reports, _ := s.Service.Admin.ListReports(context.Background())
+		return len(reports) == 1
+	})
+
+	// The call goes on
+	alice.Send(dto.Message{Type: dto.MessageTypeLeave})
+	bob.Expect(dto.MessageTypeLeave)
+}
+
+func TestReportNeedsUsers(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+
+	alone, _ := joinAs(t, s, s.Register("alice", "alice@example.test", "password123"))
+	alone.Send(report("nobody", false))
+	expectErrorCode(t, alone, dto.ErrorCodeNoPeer, "report")
+
+	anonymous, _ := joinAs(t, s, "")
+	anonymous.Expect(dto.MessageTypeJoin)
+	anonymous.Send(report("rude", true))
+	expectErrorCode(t, anonymous, dto.ErrorCodeSignInRequired, "report")
+}
@@ -1,12 +1,11 @@
 package service
 
 import (
-	"log"
+	"context"
+	"log/slog"
 
 	"projectwebcurhat/contract"
 	"projectwebcurhat/database"
-
-	"github.com/google/uuid"
 )
 
 type roomService struct {
@@ -17,49 +16,93 @@ func NewRoomService(repo *contract.Repository) contract.RoomService {
 	return &roomService{repo: repo}
 }
 
-func (s *roomService) FindOrCreateRoom(client *database.Client) *database.Room {
-	waitingRoom := s.repo.Room.GetWaitingRoom()
-
-	if waitingRoom != nil && !waitingRoom.IsFull() {
-		if waitingRoom.AddClient(client) {
-			log.Printf("Client %s joined existing room %s", client.ID, waitingRoom.ID)
-			s.repo.Room.SetWaitingRoom(nil)
-			return waitingRoom
+func (s *roomService) FindOrCreateRoom(ctx context.Context, client *database.Client) (*database.Room, error) {
+	if client.UserID != 0 {
+		blocked, err := s.repo.Moderation.BlockedUserIDs(ctx, client.UserID)
+		if err != nil {
+			client.Logger().Error("Error reading blocked users", "error", err)
+			return nil, err
 		}
+		client.Blocked = blocked
+	}
+
+	room, err := s.repo.Room.MatchClient(ctx, client)
+	if err != nil {
+		client.Logger().Error("Error matching client", "error", err)
+		return nil, err
+	}
+
+	if room.IsFull() {
+		client.Logger().Info("Client joined existing room")
+	} else {
+		client.Logger().Info("Created new room")
 	}
+	return room, nil
+}
+
+func (s *roomService) GetRoom(ctx context.Context, roomID string) *database.Room {
+	return s.repo.Room.GetRoom(ctx, roomID)
+}
 
-	roomID := uuid.New().String()
-	room := s.repo.Room.CreateRoom(roomID)
-	room.AddClient(client)
-	s.repo.Room.SetWaitingRoom(room)
+func (s *roomService) ListRooms(ctx context.Context) []*database.Room {
+	return s.repo.Room.ListRooms(ctx)
+}
+
+func (s *roomService) SetRoomState(ctx context.Context, roomID, state string) {
+	s.repo.Room.SetRoomState(ctx, roomID, state)
+}
 
-	log.Printf("Created new room %s for client %s", roomID, client.ID)
-	return room
+func (s *roomService) UpdateNegotiation(ctx context.Context, roomID string, update func(negotiation *database.Negotiation) error) error {
+	return s.repo.Room.UpdateNegotiation(ctx, roomID, update)
 }
 
-func (s *roomService) GetRoom(roomID string) *database.Room {
-	return s.repo.Room.GetRoom(roomID)
+func (s *roomService) DeleteRoom(ctx context.Context, roomID string) {
+	s.repo.Room.DeleteRoom(ctx, roomID)
+	slog.Info("Room deleted", "room_id", roomID)
 }
 
-func (s *roomService) RemoveClientFromRoom(client *database.Client) {
-	if client.RoomID == "" {
+func (s *roomService) RemoveClientFromRoom(ctx context.Context, client *database.Client) {
+	roomID := client.RoomID()
+	if roomID == "" {
 		return
 	}
 
-	room := s.repo.Room.GetRoom(client.RoomID)
-	if room == nil {
-		return
+	remaining := s.repo.Room.RemoveClient(ctx, roomID, client.ID)
+	client.Logger().Info("Client removed from room")
+	client.SetRoomID("")
+
+	if remaining == 0 {
+		slog.Info("Room deleted (empty)", "room_id", roomID)
 	}
+}
 
-	room.RemoveClient(client.ID)
-	log.Printf("Client %s removed from room %s", client.ID, room.ID)
+func (s *roomService) RemoveNodeClients(ctx context.Context, nodeID string) {
+	if removed := s.repo.Room.RemoveNodeClients(ctx, nodeID); removed > 0 {
+		slog.Info("Removed clients of dead node from their rooms", "clients", removed, "node_id", nodeID)
+	}
+}
 
-	if room.IsEmpty() {
-		s.repo.Room.DeleteRoom(room.ID)
-		log.Printf("Room %s deleted (empty)", room.ID)
+func (s *roomService) Report(ctx context.Context, report *database.Report) error {
+	if report.Blocked {
+		if err := s.repo.Moderation.BlockUser(ctx, report.ReporterID, report.ReportedID); err != nil {
+			return err
+		}
 	}
+	if err := s.repo.Moderation.CreateReport(ctx, report); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Peer reported", "report_id", report.ID, "room_id", report.RoomID, "blocked", report.Blocked)
+	return nil
+}
+
+func (s *roomService) GetRoomCount(ctx context.Context) int {
+	return s.repo.Room.GetRoomCount(ctx)
+}
+
+func (s *roomService) GetWaitingCount(ctx context.Context) int {
+	return s.repo.Room.GetWaitingCount(ctx)
 }
 
-func (s *roomService) GetRoomCount() int {
-	return s.repo.Room.GetRoomCount()
+func (s *roomService) CountRoomsByState(ctx context.Context) map[string]int {
+	return s.repo.Room.CountRoomsByState(ctx)
 }
@@ -1,12 +1,40 @@
 package service
 
-import "projectwebcurhat/contract"
+import (
+	"projectwebcurhat/config"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/dto"
+)
 
-func New(repo *contract.Repository) *contract.Service {
+func New(repo *contract.Repository, bus contract.MessageBus, metrics contract.Metrics) *contract.Service {
+	cfg := config.Get()
+	settingsSvc := NewSettingsService(repo, bus, defaultSettings(cfg))
 	roomSvc := NewRoomService(repo)
+	signalingSvc := NewSignalingService(roomSvc, bus, metrics, settingsSvc, cfg.Server.NodeID, cfg.Cluster.NodeHeartbeat, cfg.Cluster.NodeTimeout, cfg.WebSocket)
+	lifecycleSvc := NewLifecycleService()
 	return &contract.Service{
 		Room:      roomSvc,
-		Signaling: NewSignalingService(roomSvc),
-		Auth:      NewAuthService(repo),
+		Signaling: signalingSvc,
+		Auth:      NewAuthService(repo, settingsSvc, cfg.Guest.IdleTTL),
+		OAuth:     NewOAuthService(repo, cfg.OAuth.Providers),
+		Session:   NewSessionService(repo),
+		Lifecycle: lifecycleSvc,
+		Health:    NewHealthService(repo, bus, lifecycleSvc, signalingSvc, roomSvc),
+		Admin:     NewAdminService(repo, roomSvc, signalingSvc),
+		Settings:  settingsSvc,
+		Metrics:   metrics,
+	}
+}
+
+// defaultSettings are used until a setting is changed at runtime
+func defaultSettings(cfg *config.AppConfig) dto.Settings {
+	return dto.Settings{
+		GuestRateLimit: cfg.Guest.RateLimit,
+		GuestPoWBits:   cfg.Guest.PoWBits,
+		Features: dto.FeatureFlags{
+			Registration:  true,
+			GuestAccounts: true,
+		},
+		CrisisKeywords: []string{},
 	}
 }
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/dto"
+
+	"gorm.io/gorm"
+)
+
+// lastSeenWriteInterval limits last-seen updates to one write per session per interval
+const lastSeenWriteInterval = time.Minute
+
+type sessionService struct {
+	repo *contract.Repository
+}
+
+func NewSessionService(repo *contract.Repository) contract.SessionService {
+	return &sessionService{repo: repo}
+}
+
+// ValidateSession rejects unknown and revoked sessions and records activity.
+// The session row is read on every call, but last_seen_at is only written
+// when the stored value is older than lastSeenWriteInterval.
+func (s *sessionService) ValidateSession(ctx context.Context, userID int, sessionID string) error {
+	if sessionID == "" {
+		return errs.Unauthorized("Session is required, please log in again")
+	}
+
+	session, err := s.repo.Session.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errs.Unauthorized("Session not found")
+		}
+		return errs.InternalServerError("Failed to check session")
+	}
+
+	if session.UserID != userID || session.RevokedAt != nil {
+		return errs.Unauthorized("Session has been revoked")
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= lastSeenWriteInterval {
+		if err := s.repo.Session.TouchSession(ctx, sessionID, now); err != nil {
+			return errs.InternalServerError("Failed to update session")
+		}
+	}
+
+	return nil
+}
+
+func (s *sessionService) ListSessions(ctx context.Context, userID int, currentSessionID string) ([]dto.SessionResponse, error) {
+	sessions, err := s.repo.Session.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, errs.InternalServerError("Failed to list sessions")
+	}
+
+	result := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, dto.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+
+	return result, nil
+}
+
+func (s *sessionService) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	session, err := s.repo.Session.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errs.NotFound("Session not found")
+		}
+		return errs.InternalServerError("Failed to get session")
+	}
+
+	// Other users' sessions are reported as missing rather than forbidden
+	if session.UserID != userID {
+		return errs.NotFound("Session not found")
+	}
+
+	if err := s.repo.Session.RevokeSession(ctx, sessionID); err != nil {
+		return errs.InternalServerError("Failed to revoke session")
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"projectwebcurhat/config/pkg/errs"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/database"
+	"projectwebcurhat/dto"
+)
+
+const (
+	settingsSubject = "webcurhat.settings.changed"
+	// settingsReloadInterval also picks up changes whose broadcast was lost
+	settingsReloadInterval = time.Minute
+)
+
+type settingsService struct {
+	repo     *contract.Repository
+	bus      contract.MessageBus
+	defaults dto.Settings
+	current  atomic.Pointer[dto.Settings]
+	// updateMutex keeps two updates on this replica from interleaving
+	updateMutex sync.Mutex
+}
+
+// NewSettingsService loads the settings table over the defaults. Every
+// replica reloads the table when another one broadcasts a change, and once
+// per settingsReloadInterval.
+func NewSettingsService(repo *contract.Repository, bus contract.MessageBus, defaults dto.Settings) contract.SettingsService {
+	s := &settingsService{repo: repo, bus: bus, defaults: defaults}
+	s.current.Store(&defaults)
+
+	if err := s.Reload(context.Background()); err != nil {
+		slog.Error("Failed to load runtime settings, using defaults", "error", err)
+	}
+
+	_, err := bus.Subscribe(settingsSubject, func(data []byte) {
+		if err := s.Reload(context.Background()); err != nil {
+			slog.Error("Error reloading runtime settings", "error", err)
+		}
+	})
+	if err != nil {
+		slog.Warn("Failed to subscribe to settings changes, changes on other replicas apply after the next periodic reload", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(settingsReloadInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.Reload(context.Background()); err != nil {
+				slog.Error("Error reloading runtime settings", "error", err)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *settingsService) Get() dto.Settings {
+	return *s.current.Load()
+}
+
+// Reload reads the settings table again. Rows that cannot be decoded are
+// skipped, so one bad value does not undo every other setting.
+func (s *settingsService) Reload(ctx context.Context) error {
+	rows, err := s.repo.Settings.ListSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := encodeSettings(s.defaults)
+	next := s.defaults
+	for _, row := range rows {
+		if _, ok := known[row.Key]; !ok {
+			slog.Warn("Ignoring unknown runtime setting", "key", row.Key)
+			continue
+		}
+
+		// Decode into a copy, so a row that fails halfway changes nothing.
+		// Unmarshal reuses slice arrays, which are shared with older snapshots.
+		decoded := next
+		decoded.CrisisKeywords = slices.Clone(next.CrisisKeywords)
+		doc := `{"` + row.Key + `":` + row.Value + `}`
+		if err := json.Unmarshal([]byte(doc), &decoded); err != nil {
+			slog.Warn("Ignoring invalid runtime setting", "key", row.Key, "error", err)
+			continue
+		}
+		next = decoded
+	}
+
+	previous := s.current.Swap(&next)
+	if changed := changedSettings(*previous, next); len(changed) > 0 {
+		slog.Info("Runtime settings reloaded", "changed", changed)
+	}
+	return nil
+}
+
+// Update applies the fields present in the payload, stores the ones that
+// changed and tells the other replicas to reload
+func (s *settingsService) Update(ctx context.Context, userID int, payload *dto.SettingsUpdateRequest) (*dto.Settings, error) {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	// Start from the table, so a change made on another replica a moment ago is kept
+	if err := s.Reload(ctx); err != nil {
+		slog.ErrorContext(ctx, "Error loading runtime settings", "error", err)
+		return nil, errs.InternalServerError("Failed to load settings")
+	}
+
+	previous := s.Get()
+	next := previous
+	if payload.MatchTimeoutSeconds != nil {
+		next.MatchTimeoutSeconds = *payload.MatchTimeoutSeconds
+	}
+	if payload.GuestRateLimit != nil {
+		next.GuestRateLimit = *payload.GuestRateLimit
+	}
+	if payload.GuestPoWBits != nil {
+		next.GuestPoWBits = *payload.GuestPoWBits
+	}
+	if payload.Features != nil {
+		if payload.Features.Registration != nil {
+			next.Features.Registration = *payload.Features.Registration
+		}
+		if payload.Features.GuestAccounts != nil {
+			next.Features.GuestAccounts = *payload.Features.GuestAccounts
+		}
+	}
+	if payload.CrisisKeywords != nil {
+		next.CrisisKeywords = normalizeKeywords(payload.CrisisKeywords)
+	}
+
+	changed := changedSettings(previous, next)
+	if len(changed) == 0 {
+		return &next, nil
+	}
+
+	values := encodeSettings(next)
+	rows := make([]database.Setting, 0, len(changed))
+	for _, key := range changed {
+		rows = append(rows, database.Setting{Key: key, Value: string(values[key]), UpdatedBy: &userID})
+	}
+	if err := s.repo.Settings.SaveSettings(ctx, rows); err != nil {
+		slog.ErrorContext(ctx, "Error saving runtime settings", "error", err)
+		return nil, errs.InternalServerError("Failed to save settings")
+	}
+	s.current.Store(&next)
+
+	if err := s.bus.Publish(settingsSubject, nil); err != nil {
+		slog.WarnContext(ctx, "Error broadcasting settings change, other replicas apply it after the next periodic reload", "error", err)
+	}
+
+	before := encodeSettings(previous)
+	oldValues := make(map[string]string, len(changed))
+	newValues := make(map[string]string, len(changed))
+	for _, key := range changed {
+		oldValues[key] = string(before[key])
+		newValues[key] = string(values[key])
+	}
+	slog.InfoContext(ctx, "Admin changed settings", "audit", true, "changed", changed, "old", oldValues, "new", newValues)
+
+	return &next, nil
+}
+
+// encodeSettings returns the JSON value of every top level field, keyed like the settings table
+func encodeSettings(settings dto.Settings) map[string]json.RawMessage {
+	data, _ := json.Marshal(settings)
+	values := make(map[string]json.RawMessage)
+	_ = json.Unmarshal(data, &values)
+	return values
+}
+
+// changedSettings returns the sorted keys whose values differ
+func changedSettings(previous, next dto.Settings) []string {
+	before := encodeSettings(previous)
+	after := encodeSettings(next)
+
+	var changed []string
+	for _, key := range slices.Sorted(maps.Keys(after)) {
+		if !bytes.Equal(before[key], after[key]) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// normalizeKeywords lower-cases, trims and de-duplicates the list
+func normalizeKeywords(keywords []string) []string {
+	normalized := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" {
+			normalized = append(normalized, keyword)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
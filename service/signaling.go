@@ -1,85 +1,229 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"projectwebcurhat/config"
 	"projectwebcurhat/contract"
 	"projectwebcurhat/database"
 	"projectwebcurhat/dto"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type signalingService struct {
 	roomService contract.RoomService
+	bus         contract.MessageBus
+	metrics     contract.Metrics
+	settings    contract.SettingsService
+	nodeID      string
+	limits      config.WebSocketConfig
+	protocols   map[int]*protocol
+
+	// clients connected to this replica and their bus subscriptions
+	clients map[string]*localClient
+	mutex   sync.RWMutex
+
+	// last heartbeat of every other replica
+	nodes     map[string]time.Time
+	nodeMutex sync.Mutex
+
+	draining atomic.Bool
 }
 
-func NewSignalingService(roomService contract.RoomService) contract.SignalingService {
-	return &signalingService{
+type localClient struct {
+	client       *database.Client
+	subscription contract.Subscription
+}
+
+func NewSignalingService(roomService contract.RoomService, bus contract.MessageBus, metrics contract.Metrics, settings contract.SettingsService, nodeID string, heartbeat, nodeTimeout time.Duration, limits config.WebSocketConfig) contract.SignalingService {
+	s := &signalingService{
 		roomService: roomService,
+		bus:         bus,
+		metrics:     metrics,
+		settings:    settings,
+		nodeID:      nodeID,
+		limits:      limits,
+		clients:     make(map[string]*localClient),
+		nodes:       make(map[string]time.Time),
 	}
+	s.protocols = s.newProtocols()
+	s.startClusterMonitor(heartbeat, nodeTimeout)
+	s.subscribeKicks()
+	return s
 }
 
-func (s *signalingService) HandleMessage(client *database.Client, data []byte) error {
-	var msg dto.Message
-	if err := json.Unmarshal(data, &msg); err != nil {
-		log.Printf("Error unmarshaling message: %v", err)
+// ConnectClient subscribes to the client's bus subject, so peers on any
+// replica can reach it
+func (s *signalingService) ConnectClient(ctx context.Context, client *database.Client) error {
+	sub, err := s.bus.Subscribe(clientSubject(client.ID), func(data []byte) {
+		if !client.Enqueue(data) {
+			client.Logger().Warn("Send channel full or closed, dropping relayed message")
+			s.metrics.MessageDropped("buffer_full")
+		}
+	})
+	if err != nil {
 		return err
 	}
 
-	msg.From = client.ID
+	s.mutex.Lock()
+	s.clients[client.ID] = &localClient{client: client, subscription: sub}
+	s.mutex.Unlock()
+	return nil
+}
+
+// HandleMessage runs every message in its own span. Messages have no request
+// to hang off, so spans carry the room ID and can be grouped by it instead.
+// ctx is the connection's context and is cancelled when the socket goes away.
+// Invalid messages are answered with an error and returned as one.
+// Handling is up to the protocol version the client settled on.
+func (s *signalingService) HandleMessage(ctx context.Context, client *database.Client, data []byte) error {
+	ctx, span := tracer.Start(ctx, "signaling.message",
+		trace.WithAttributes(attribute.String("webcurhat.client_id", client.ID)),
+	)
+	defer func() {
+		// Read at the end, so a join is linked to the room it was matched into
+		span.SetAttributes(attribute.String("webcurhat.room_id", client.RoomID()))
+		span.End()
+	}()
 
-	switch msg.Type {
-	case dto.MessageTypeJoin:
-		s.handleJoin(client, &msg)
-	case dto.MessageTypeOffer, dto.MessageTypeAnswer, dto.MessageTypeCandidate:
-		s.relayMessage(client, &msg)
-	case dto.MessageTypeLeave:
-		s.handleLeave(client)
-	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+	// Until a hello settles a later version, the client may be a version 1 one
+	msg, err := s.decodeMessage(data, client.Protocol <= dto.ProtocolVersion1)
+	if err == nil {
+		err = s.settleLegacy(client, &msg)
 	}
+	if err == nil {
+		span.SetName("signaling." + string(msg.Type))
+		span.SetAttributes(attribute.String("webcurhat.message_type", string(msg.Type)))
 
+		msg.From = client.ID
+		err = s.dispatch(ctx, client, &msg)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid message")
+		var rejected *messageError
+		if errors.As(err, &rejected) {
+			s.sendErrorAs(s.errorProtocol(client, &msg), client, msg.ID, rejected.code, rejected.message)
+			if rejected.disconnect {
+				s.DisconnectClient(ctx, client)
+				client.CloseSend()
+			}
+		}
+		return err
+	}
 	return nil
 }
 
-func (s *signalingService) handleJoin(client *database.Client, msg *dto.Message) {
-	if msg.Username != "" {
-		client.Username = msg.Username
+func (s *signalingService) handleJoin(ctx context.Context, client *database.Client, msg *dto.Message) {
+	if s.draining.Load() {
+		s.sendError(client, msg.ID, dto.ErrorCodeServerDraining, "Server is shutting down, please reconnect")
+		return
 	}
 
-	room := s.roomService.FindOrCreateRoom(client)
+	client.JoinedAt = time.Now()
+	room, err := s.roomService.FindOrCreateRoom(ctx, client)
+	if err != nil {
+		s.sendError(client, msg.ID, dto.ErrorCodeMatchFailed, "Could not find a partner right now, please try again")
+		return
+	}
+	settings := s.settings.Get()
 
 	readyMsg := dto.Message{
 		Type:   dto.MessageTypeReady,
 		RoomID: room.ID,
 		From:   "server",
 	}
+	// Keywords are checked in the chat, which clients opt into
+	if hasCapability(client, dto.CapabilityChat) {
+		readyMsg.Payload = dto.ReadyPayload{CrisisKeywords: settings.CrisisKeywords}
+	}
 	s.sendToClient(client, &readyMsg)
 
+	if !room.IsFull() && settings.MatchTimeoutSeconds > 0 {
+		timeout := time.Duration(settings.MatchTimeoutSeconds) * time.Second
+		// The timer outlives the message, but should keep its trace and log attributes
+		waitCtx := context.WithoutCancel(ctx)
+		time.AfterFunc(timeout, func() { s.expireWait(waitCtx, client, room.ID) })
+	}
+
 	if room.IsFull() {
 		otherClient := room.GetOtherClient(client.ID)
 		if otherClient != nil {
+			matchedAt := time.Now()
+			client.SetMatchedAt(matchedAt)
+			if otherClient.IsLocal() {
+				otherClient.SetMatchedAt(matchedAt)
+			}
+			if !otherClient.JoinedAt.IsZero() {
+				s.metrics.ClientMatched(matchedAt.Sub(otherClient.JoinedAt))
+			}
+
+			// Roles are settled here, so only one peer makes the first offer
+			initiator := room.Initiator()
 			peerJoinMsg := dto.Message{
 				Type:     dto.MessageTypeJoin,
 				From:     otherClient.ID,
 				Username: otherClient.Username,
 				RoomID:   room.ID,
+				Payload:  joinPayload(client, initiator),
 			}
 			s.sendToClient(client, &peerJoinMsg)
 
 			peerJoinMsg.From = client.ID
 			peerJoinMsg.Username = client.Username
+			peerJoinMsg.Payload = joinPayload(otherClient, initiator)
 			s.sendToClient(otherClient, &peerJoinMsg)
 
-			log.Printf("Room %s is ready with clients %s and %s", room.ID, client.ID, otherClient.ID)
+			client.Logger().Info("Room is ready", "peer_id", otherClient.ID, "initiator_id", initiator.ID)
 		}
 	}
 }
 
-func (s *signalingService) handleLeave(client *database.Client) {
-	room := s.roomService.GetRoom(client.RoomID)
+// expireWait disconnects a client that is still alone in the room it was
+// put in when the match timeout started. Clients are told, so they can
+// offer to try again. It runs on a timer, so it only closes the socket and
+// leaves the rest to the connection's goroutines.
+func (s *signalingService) expireWait(ctx context.Context, client *database.Client, roomID string) {
+	room := s.roomService.GetRoom(ctx, roomID)
+	if room == nil || room.IsFull() {
+		return
+	}
+	if !slices.ContainsFunc(room.GetClients(), func(c *database.Client) bool { return c.ID == client.ID }) {
+		return
+	}
+
+	s.mutex.RLock()
+	_, connected := s.clients[client.ID]
+	s.mutex.RUnlock()
+	if !connected {
+		return
+	}
+
+	s.sendToClient(client, &dto.Message{
+		Type:    dto.MessageTypeMatchTimeout,
+		From:    "server",
+		Payload: "No partner found, please try again",
+	})
+	client.Logger().Info("Nobody matched before the timeout, disconnecting")
+	client.CloseSend()
+}
+
+func (s *signalingService) handleLeave(ctx context.Context, client *database.Client) {
+	room := s.roomService.GetRoom(ctx, client.RoomID())
 	if room != nil {
 		otherClient := room.GetOtherClient(client.ID)
+		s.endCall(client, otherClient)
 		if otherClient != nil {
 			leaveMsg := dto.Message{
 				Type: dto.MessageTypeLeave,
@@ -89,41 +233,270 @@ func (s *signalingService) handleLeave(client *database.Client) {
 		}
 	}
 
-	s.roomService.RemoveClientFromRoom(client)
+	s.roomService.RemoveClientFromRoom(ctx, client)
+}
+
+// handleReport saves a report about the peer of the current call. Both
+// sides need a user, guest or registered, for the report to name. Blocking
+// also ends the call, as if the client had left.
+func (s *signalingService) handleReport(ctx context.Context, client *database.Client, msg *dto.Message) {
+	var peer *database.Client
+	room := s.roomService.GetRoom(ctx, client.RoomID())
+	if room != nil {
+		peer = room.GetOtherClient(client.ID)
+	}
+	if peer == nil {
+		s.sendError(client, msg.ID, dto.ErrorCodeNoPeer, "There is no peer to report")
+		return
+	}
+	if client.UserID == 0 || peer.UserID == 0 {
+		s.sendError(client, msg.ID, dto.ErrorCodeSignInRequired, "Reporting needs an account or a guest token on both sides")
+		return
+	}
+
+	payload := msg.Payload.(dto.ReportPayload)
+	err := s.roomService.Report(ctx, &database.Report{
+		ReporterID: client.UserID,
+		ReportedID: peer.UserID,
+		RoomID:     room.ID,
+		Reason:     payload.Reason,
+		Blocked:    payload.Block,
+	})
+	if err != nil {
+		client.Logger().Error("Error saving report", "error", err)
+		s.sendError(client, msg.ID, dto.ErrorCodeReportFailed, "Could not save the report, please try again")
+		return
+	}
+
+	if payload.Block {
+		s.handleLeave(ctx, client)
+	}
 }
 
-func (s *signalingService) relayMessage(client *database.Client, msg *dto.Message) {
-	room := s.roomService.GetRoom(client.RoomID)
+func (s *signalingService) relayMessage(ctx context.Context, client *database.Client, msg *dto.Message) {
+	room := s.roomService.GetRoom(ctx, client.RoomID())
 	if room == nil {
-		log.Printf("Room not found for client %s", client.ID)
+		client.Logger().Warn("Room not found for client")
+		s.sendError(client, msg.ID, dto.ErrorCodeNoPeer, "Join a room before sending "+msg.Type)
 		return
 	}
 
 	otherClient := room.GetOtherClient(client.ID)
 	if otherClient == nil {
-		log.Printf("No other client found in room %s", room.ID)
+		client.Logger().Warn("No other client found in room")
+		s.sendError(client, msg.ID, dto.ErrorCodeNoPeer, "Nobody to send "+msg.Type+" to yet")
 		return
 	}
 
 	msg.To = otherClient.ID
-	s.sendToClient(otherClient, msg)
+	relay := []*dto.Message{msg}
+	if msg.Type == dto.MessageTypeOffer || msg.Type == dto.MessageTypeAnswer {
+		var err error
+		relay, err = s.negotiate(ctx, room, client, msg)
+		var rejected *messageError
+		switch {
+		case errors.As(err, &rejected):
+			client.Logger().Warn("Negotiation message out of turn", "type", msg.Type, "error_code", rejected.code)
+			s.sendError(client, msg.ID, rejected.code, rejected.message)
+			return
+		case err != nil:
+			// Without the state the call can still go through, as before it was tracked
+			client.Logger().Error("Error updating negotiation state", "error", err)
+			relay = []*dto.Message{msg}
+		}
+	}
+
+	// A queued offer goes back to the client whose answer released it
+	for _, relayed := range relay {
+		recipient := otherClient
+		if relayed.To == client.ID {
+			recipient = client
+		}
+		s.sendToClient(recipient, relayed)
+		s.metrics.MessageRelayed(string(relayed.Type))
+	}
+
+	if msg.Type == dto.MessageTypeAnswer {
+		s.roomService.SetRoomState(ctx, room.ID, database.RoomStateConnected)
+	}
+}
+
+// endCall records the call duration once per call. Only the replica that
+// completed the match knows when it started, so a call whose waiting side
+// sits on another replica is recorded when the matching side leaves.
+func (s *signalingService) endCall(client, otherClient *database.Client) {
+	start := client.TakeMatchedAt()
+	if otherClient != nil && otherClient.IsLocal() {
+		if otherStart := otherClient.TakeMatchedAt(); start.IsZero() {
+			start = otherStart
+		}
+	}
+
+	if !start.IsZero() {
+		s.metrics.CallEnded(time.Since(start))
+	}
+}
+
+// sendError answers a message the server did not act on; ref is its id.
+// The payload takes the form of the client's protocol version.
+func (s *signalingService) sendError(client *database.Client, ref, code, message string) {
+	s.sendErrorAs(s.protocolOf(client), client, ref, code, message)
+}
+
+// sendErrorAs sends an error in the format of protocol p
+func (s *signalingService) sendErrorAs(p *protocol, client *database.Client, ref, code, message string) {
+	s.metrics.MessageRejected(code)
+	s.sendToClient(client, &dto.Message{
+		Type:    dto.MessageTypeError,
+		From:    "server",
+		Payload: p.errorPayload(dto.ErrorPayload{Code: code, Message: message, Ref: ref}),
+	})
 }
 
 func (s *signalingService) sendToClient(client *database.Client, msg *dto.Message) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+		slog.Error("Error marshaling message", "type", msg.Type, "error", err)
+		return
+	}
+
+	// Peers connected to another replica are reached through the bus
+	if !client.IsLocal() {
+		if err := s.bus.Publish(clientSubject(client.ID), data); err != nil {
+			client.Logger().Error("Error publishing message to client", "type", msg.Type, "error", err)
+			s.metrics.MessageDropped("publish_error")
+		}
+		return
+	}
+
+	if !client.Enqueue(data) {
+		client.Logger().Warn("Send channel full, closing connection")
+		s.metrics.MessageDropped("buffer_full")
+		client.CloseSend()
+	}
+}
+
+func (s *signalingService) DisconnectClient(ctx context.Context, client *database.Client) {
+	s.mutex.Lock()
+	local, exists := s.clients[client.ID]
+	delete(s.clients, client.ID)
+	s.mutex.Unlock()
+
+	// Already disconnected
+	if !exists {
 		return
 	}
 
-	select {
-	case client.Send <- data:
-	default:
-		log.Printf("Client %s send channel full, closing connection", client.ID)
-		close(client.Send)
+	if err := local.subscription.Unsubscribe(); err != nil {
+		client.Logger().Error("Error unsubscribing client", "error", err)
+	}
+
+	s.handleLeave(ctx, client)
+}
+
+// NotifyShutdown refuses new matches from now on and tells every local client
+// when its connection will be closed. Reconnect delays are jittered so the
+// clients do not all hit the remaining replicas at once.
+func (s *signalingService) NotifyShutdown(ctx context.Context, closeIn time.Duration) {
+	s.draining.Store(true)
+
+	for _, client := range s.localClients() {
+		s.sendToClient(client, &dto.Message{
+			Type: dto.MessageTypeServerShutdown,
+			From: "server",
+			Payload: dto.ServerShutdownPayload{
+				Reconnect:  true,
+				RetryAfter: 1 + rand.IntN(5),
+				CloseIn:    int(closeIn.Seconds()),
+			},
+		})
+	}
+}
+
+func (s *signalingService) LocalClientCount() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.clients)
+}
+
+// CloseAllClients closes every local socket with a close frame. Each
+// connection then ends its call on its own goroutine, so peers on other
+// replicas get a leave; this waits for that until ctx is done.
+func (s *signalingService) CloseAllClients(ctx context.Context) {
+	for _, client := range s.localClients() {
+		client.CloseSend()
+	}
+
+	for s.LocalClientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			slog.Warn("Connections still open after closing them", "connections", s.LocalClientCount())
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// kickRequest asks the replica holding a client to disconnect it
+type kickRequest struct {
+	ClientID   string `json:"client_id"`
+	NoticeType string `json:"notice_type"`
+	Reason     string `json:"reason"`
+}
+
+// KickClient is broadcast to every replica; only the one holding the
+// client acts on it
+func (s *signalingService) KickClient(ctx context.Context, clientID, noticeType, reason string) error {
+	data, err := json.Marshal(kickRequest{ClientID: clientID, NoticeType: noticeType, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return s.bus.Publish(kickSubject, data)
+}
+
+func (s *signalingService) subscribeKicks() {
+	_, err := s.bus.Subscribe(kickSubject, func(data []byte) {
+		var req kickRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			slog.Error("Error decoding kick request", "error", err)
+			return
+		}
+
+		s.mutex.RLock()
+		local, exists := s.clients[req.ClientID]
+		s.mutex.RUnlock()
+		if !exists {
+			return
+		}
+
+		client := local.client
+		s.sendToClient(client, &dto.Message{
+			Type:    req.NoticeType,
+			From:    "server",
+			Payload: dto.AdminNoticePayload{Reason: req.Reason},
+		})
+		client.Logger().Info("Client kicked", "notice", req.NoticeType, "reason", req.Reason)
+		// The read pump leaves the room once the write pump closed the socket
+		client.CloseSend()
+	})
+	if err != nil {
+		slog.Warn("Failed to subscribe to kick requests, moderators cannot disconnect clients", "error", err)
+	}
+}
+
+func (s *signalingService) localClients() []*database.Client {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	clients := make([]*database.Client, 0, len(s.clients))
+	for _, local := range s.clients {
+		clients = append(clients, local.client)
 	}
+	return clients
 }
 
-func (s *signalingService) DisconnectClient(client *database.Client) {
-	s.handleLeave(client)
+const kickSubject = "webcurhat.admin.kick"
+
+func clientSubject(clientID string) string {
+	return "webcurhat.client." + clientID
 }
@@ -0,0 +1,90 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"projectwebcurhat/dto"
+	"projectwebcurhat/testutil"
+)
+
+// matchPair connects two anonymous clients and has them matched into one
+// room. It returns the clients with their client IDs and the room ID.
+func matchPair(t *testing.T, s *testutil.Server) (first, second *testutil.Client, firstID, secondID, roomID string) {
+	t.Helper()
+
+	first, second = s.Dial(""), s.Dial("")
+	first.Send(dto.Message{Type: dto.MessageTypeJoin})
+	roomID = first.Expect(dto.MessageTypeReady).RoomID
+	second.Send(dto.Message{Type: dto.MessageTypeJoin})
+	second.Expect(dto.MessageTypeReady)
+
+	firstID = second.Expect(dto.MessageTypeJoin).From
+	secondID = first.Expect(dto.MessageTypeJoin).From
+	return first, second, firstID, secondID, roomID
+}
+
+func TestKickEndsTheCall(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	first, second, firstID, _, roomID := matchPair(t, s)
+
+	if err := s.Service.Signaling.KickClient(context.Background(), firstID, dto.MessageTypeKicked, "testing"); err != nil {
+		t.Fatalf("kicking: %v", err)
+	}
+
+	var notice dto.AdminNoticePayload
+	testutil.DecodePayload(t, first.Expect(dto.MessageTypeKicked), &notice)
+	if notice.Reason != "testing" {
+		t.Fatalf("kick reason %q, want testing", notice.Reason)
+	}
+	first.ExpectClosed()
+
+	if leave := second.Expect(dto.MessageTypeLeave); leave.From != firstID {
+		t.Fatalf("leave from %q, want the kicked client %q", leave.From, firstID)
+	}
+	testutil.WaitFor(t, "the kicked client to leave the room", func() bool {
+		room := s.Repository.Room.GetRoom(context.Background(), roomID)
+		return room != nil && len(room.GetClients()) == 1
+	})
+}
+
+func TestMatchTimeoutDisconnects(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	timeout := 1
+	if _, err := s.Service.Settings.Update(context.Background(), 0, &dto.SettingsUpdateRequest{MatchTimeoutSeconds: &timeout}); err != nil {
+		t.Fatalf("setting the match timeout: %v", err)
+	}
+
+	client := s.Dial("")
+	client.Send(dto.Message{Type: dto.MessageTypeJoin})
+	roomID := client.Expect(dto.MessageTypeReady).RoomID
+
+	client.Expect(dto.MessageTypeMatchTimeout)
+	client.ExpectClosed()
+	testutil.WaitFor(t, "the waiting room to be deleted", func() bool {
+		return s.Repository.Room.GetRoom(context.Background(), roomID) == nil
+	})
+}
+
+func TestCloseAllClientsEndsEveryCall(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	_, _, _, _, roomID := matchPair(t, s)
+	waiting := s.Dial("")
+	waiting.Send(dto.Message{Type: dto.MessageTypeJoin})
+	waiting.Expect(dto.MessageTypeReady)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.DefaultTimeout)
+	defer cancel()
+	s.Service.Signaling.CloseAllClients(ctx)
+
+	if count := s.Service.Signaling.LocalClientCount(); count != 0 {
+		t.Fatalf("%d clients still connected", count)
+	}
+	if room := s.Repository.Room.GetRoom(context.Background(), roomID); room != nil {
+		t.Fatalf("room %s still exists", roomID)
+	}
+	if count := s.Repository.Room.GetRoomCount(context.Background()); count != 0 {
+		t.Fatalf("%d rooms left", count)
+	}
+	waiting.ExpectClosed()
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"projectwebcurhat/config/pkg/errs"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("projectwebcurhat/service")
+
+// endSpan ends a service span and marks it failed on server errors. Client
+// errors such as a wrong password are expected outcomes and stay unset.
+func endSpan(span trace.Span, err error) {
+	defer span.End()
+
+	if err == nil {
+		return
+	}
+	var messageErr errs.MessageError
+	if errors.As(err, &messageErr) && messageErr.Status() < http.StatusInternalServerError {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,174 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"projectwebcurhat/dto"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultTimeout bounds how long Expect waits for a message
+const DefaultTimeout = 5 * time.Second
+
+// Client is one WebSocket connection to the server. Messages are read in the
+// background and handed out in arrival order by Expect.
+type Client struct {
+	// Timeout bounds how long Expect waits, DefaultTimeout unless changed
+	Timeout time.Duration
+
+	t        testing.TB
+	conn     *websocket.Conn
+	messages chan dto.Message
+	closing  chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Dial connects to /ws, anonymously when accessToken is empty. The
+// connection is closed when the test ends.
+func (s *Server) Dial(accessToken string) *Client {
+	s.t.Helper()
+
+	conn, resp, err := websocket.DefaultDialer.Dial(s.WebSocketURL(accessToken), nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		s.t.Fatalf("dialing /ws: %v (status %d)", err, status)
+	}
+
+	c := &Client{
+		Timeout:  DefaultTimeout,
+		t:        s.t,
+		conn:     conn,
+		messages: make(chan dto.Message, 64),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.read()
+	s.t.Cleanup(c.Close)
+	return c
+}
+
+func (c *Client) read() {
+	defer close(c.done)
+	defer close(c.messages)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		// Messages queued while a frame was written share it, one per line
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var msg dto.Message
+			if err := decoder.Decode(&msg); err != nil {
+				c.t.Errorf("server sent invalid JSON %q: %v", data, err)
+				return
+			}
+			select {
+			case c.messages <- msg:
+			case <-c.closing:
+				return
+			}
+		}
+	}
+}
+
+// Send writes msg as one JSON text frame
+func (c *Client) Send(msg dto.Message) {
+	c.t.Helper()
+
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("sending %s: %v", msg.Type, err)
+	}
+}
+
+// SendRaw writes data as one text frame, for messages Send cannot encode
+func (c *Client) SendRaw(data string) {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		c.t.Fatalf("sending %q: %v", data, err)
+	}
+}
+
+// Hello negotiates the latest protocol version with the given capabilities
+// and returns the server's reply
+func (c *Client) Hello(capabilities ...string) dto.HelloReplyPayload {
+	c.t.Helper()
+
+	c.Send(dto.Message{
+		Type:    dto.MessageTypeHello,
+		Payload: dto.HelloPayload{Versions: []int{dto.LatestProtocolVersion}, Capabilities: capabilities},
+	})
+	var reply dto.HelloReplyPayload
+	DecodePayload(c.t, c.Expect(dto.MessageTypeHello), &reply)
+	return reply
+}
+
+// Expect returns the next message and fails the test unless it has the
+// given type, so a sequence of Expect calls asserts the order of messages
+func (c *Client) Expect(msgType string) dto.Message {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("connection closed while waiting for %s", msgType)
+		}
+		if msg.Type != msgType {
+			c.t.Fatalf("got %s message (payload %v), want %s", msg.Type, msg.Payload, msgType)
+		}
+		return msg
+	case <-time.After(c.Timeout):
+		c.t.Fatalf("no %s message within %s", msgType, c.Timeout)
+		return dto.Message{}
+	}
+}
+
+// ExpectClosed fails the test unless the server closes the connection
+// without sending anything else first
+func (c *Client) ExpectClosed() {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.messages:
+		if ok {
+			c.t.Fatalf("got %s message, want the connection to be closed", msg.Type)
+		}
+	case <-time.After(c.Timeout):
+		c.t.Fatalf("connection still open after %s", c.Timeout)
+	}
+}
+
+// Close closes the connection and waits for the reader to stop
+func (c *Client) Close() {
+	c.once.Do(func() {
+		close(c.closing)
+		c.conn.Close()
+	})
+	<-c.done
+}
+
+// DecodePayload converts the payload of a received message, which arrives as
+// generic JSON, into v
+func DecodePayload(t testing.TB, msg dto.Message, v any) {
+	t.Helper()
+
+	data, err := json.Marshal(msg.Payload)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		t.Fatalf("decoding %s payload: %v", msg.Type, err)
+	}
+}
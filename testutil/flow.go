@@ -0,0 +1,129 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"projectwebcurhat/dto"
+)
+
+// Fake SDP and ICE payloads, just complete enough to pass the server's checks
+var (
+	fakeOffer     = dto.SDPMessage{Type: "offer", SDP: "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=testutil offer\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n"}
+	fakeAnswer    = dto.SDPMessage{Type: "answer", SDP: "v=0\r\no=- 2 1 IN IP4 127.0.0.1\r\ns=testutil answer\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n"}
+	fakeCandidate = dto.ICECandidateMessage{Candidate: "candidate:1 1 udp 2122260223 127.0.0.1 50000 typ host", SDPMid: "0"}
+)
+
+// RunCallFlow has two registered users, one signed in again through login,
+// go through a complete call: join, ready, offer, answer, candidate and
+// leave. Alice negotiates the latest protocol with hello while Bob speaks
+// version 1 without one. Each client's messages are checked for type and
+// order, and for the sender, receiver and room they carry. Afterwards the
+// room must be gone.
+func RunCallFlow(t testing.TB, s *Server) {
+	t.Helper()
+
+	aliceToken := s.Register("alice", "alice@example.test", "password123")
+	s.Register("bob", "bob@example.test", "password123")
+	bobToken := s.Login("bob@example.test", "password123")
+
+	alice := s.Dial(aliceToken)
+	bob := s.Dial(bobToken)
+
+	if hello := alice.Hello(dto.CapabilityChat); hello.Version != dto.LatestProtocolVersion {
+		t.Fatalf("alice got protocol version %d, want %d", hello.Version, dto.LatestProtocolVersion)
+	}
+
+	// Alice waits alone until Bob is matched with her
+	alice.Send(dto.Message{Type: dto.MessageTypeJoin})
+	aliceReady := alice.Expect(dto.MessageTypeReady)
+	if aliceReady.From != "server" || aliceReady.RoomID == "" {
+		t.Fatalf("alice's ready: from %q room %q, want a room from the server", aliceReady.From, aliceReady.RoomID)
+	}
+	roomID := aliceReady.RoomID
+
+	bob.Send(dto.Message{Type: dto.MessageTypeJoin})
+	if ready := bob.Expect(dto.MessageTypeReady); ready.RoomID != roomID {
+		t.Fatalf("bob is in room %q, want alice's room %q", ready.RoomID, roomID)
+	}
+
+	peerOfBob := bob.Expect(dto.MessageTypeJoin)
+	peerOfAlice := alice.Expect(dto.MessageTypeJoin)
+	if peerOfBob.Username != "alice" || peerOfAlice.Username != "bob" {
+		t.Fatalf("peers joined as %q and %q, want alice and bob", peerOfBob.Username, peerOfAlice.Username)
+	}
+	if peerOfBob.RoomID != roomID || peerOfAlice.RoomID != roomID {
+		t.Fatalf("join messages name rooms %q and %q, want %q", peerOfBob.RoomID, peerOfAlice.RoomID, roomID)
+	}
+	aliceID, bobID := peerOfBob.From, peerOfAlice.From
+
+	// Alice waited first, so she makes the offer and wins collisions
+	var aliceRole, bobRole dto.JoinPayload
+	DecodePayload(t, peerOfAlice, &aliceRole)
+	DecodePayload(t, peerOfBob, &bobRole)
+	if !aliceRole.Initiator || aliceRole.Polite || bobRole.Initiator || !bobRole.Polite {
+		t.Fatalf("roles alice %+v bob %+v, want alice the impolite initiator", aliceRole, bobRole)
+	}
+
+	// Alice is the caller: offer, answer, then a trickled candidate
+	alice.Send(dto.Message{Type: dto.MessageTypeOffer, Payload: fakeOffer})
+	offer := bob.Expect(dto.MessageTypeOffer)
+	expectRelayed(t, offer, aliceID, bobID)
+	var gotOffer dto.SDPMessage
+	DecodePayload(t, offer, &gotOffer)
+	if gotOffer != fakeOffer {
+		t.Fatalf("relayed offer %+v, want %+v", gotOffer, fakeOffer)
+	}
+
+	bob.Send(dto.Message{Type: dto.MessageTypeAnswer, Payload: fakeAnswer})
+	answer := alice.Expect(dto.MessageTypeAnswer)
+	expectRelayed(t, answer, bobID, aliceID)
+	var gotAnswer dto.SDPMessage
+	DecodePayload(t, answer, &gotAnswer)
+	if gotAnswer != fakeAnswer {
+		t.Fatalf("relayed answer %+v, want %+v", gotAnswer, fakeAnswer)
+	}
+
+	alice.Send(dto.Message{Type: dto.MessageTypeCandidate, Payload: fakeCandidate})
+	candidate := bob.Expect(dto.MessageTypeCandidate)
+	expectRelayed(t, candidate, aliceID, bobID)
+	var gotCandidate dto.ICECandidateMessage
+	DecodePayload(t, candidate, &gotCandidate)
+	if gotCandidate != fakeCandidate {
+		t.Fatalf("relayed candidate %+v, want %+v", gotCandidate, fakeCandidate)
+	}
+
+	// Alice hangs up; Bob is told and leaves the empty call too
+	alice.Send(dto.Message{Type: dto.MessageTypeLeave})
+	if leave := bob.Expect(dto.MessageTypeLeave); leave.From != aliceID {
+		t.Fatalf("leave from %q, want alice %q", leave.From, aliceID)
+	}
+	bob.Send(dto.Message{Type: dto.MessageTypeLeave})
+
+	WaitFor(t, "the room to be deleted", func() bool {
+		return s.Repository.Room.GetRoom(context.Background(), roomID) == nil
+	})
+}
+
+// expectRelayed checks that a relayed message names its sender and receiver
+func expectRelayed(t testing.TB, msg dto.Message, from, to string) {
+	t.Helper()
+
+	if msg.From != from || msg.To != to {
+		t.Fatalf("%s went from %q to %q, want from %q to %q", msg.Type, msg.From, msg.To, from, to)
+	}
+}
+
+// WaitFor polls condition until it holds, failing the test after DefaultTimeout
+func WaitFor(t testing.TB, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(DefaultTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
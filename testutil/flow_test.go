@@ -0,0 +1,12 @@
+package testutil_test
+
+import (
+	"testing"
+
+	"projectwebcurhat/testutil"
+)
+
+func TestCallFlow(t *testing.T) {
+	s := testutil.NewServer(t, nil)
+	testutil.RunCallFlow(t, s)
+}
@@ -0,0 +1,162 @@
+// Package testutil runs the whole signaling server in process for integration
+// tests: the production gin router and services on an httptest.Server, backed
+// by the in-memory repositories and message bus. Nothing outside the process
+// is needed, not even a database.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"projectwebcurhat/bus"
+	"projectwebcurhat/config"
+	"projectwebcurhat/config/logger"
+	"projectwebcurhat/config/pkg/token"
+	"projectwebcurhat/config/server"
+	"projectwebcurhat/contract"
+	"projectwebcurhat/dto"
+	"projectwebcurhat/metrics"
+	"projectwebcurhat/repository"
+	"projectwebcurhat/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jwtSecret signs the tokens of every test server; the configuration is
+// global, so all servers of one test binary share it anyway
+const jwtSecret = "testutil-secret-never-used-outside-tests"
+
+// Server is a running in-process instance of the signaling server
+type Server struct {
+	*httptest.Server
+	Repository *contract.Repository
+	Service    *contract.Service
+	t          testing.TB
+}
+
+// NewServer starts a server and stops it when the test ends. overrides are
+// config keys, as in config files and flags, applied over the defaults the
+// harness needs: memory room store and bus, HS256 tokens, no metrics. With
+// metrics.enabled overridden, /metrics is served as in production.
+//
+// The configuration is a process-wide singleton, so tests that start servers
+// with different overrides must not run in parallel.
+func NewServer(t testing.TB, overrides map[string]string) *Server {
+	t.Helper()
+
+	flags := map[string]string{
+		"server.production":   "false",
+		"log.level":           "warn",
+		"matching.room_store": "memory",
+		"cluster.bus":         "memory",
+		"jwt.algorithm":       "HS256",
+		"jwt.secret":          jwtSecret,
+		"metrics.enabled":     "false",
+		"tracing.exporter":    "none",
+	}
+	maps.Copy(flags, overrides)
+	if err := config.Load(config.Sources{Flags: flags}); err != nil {
+		t.Fatalf("loading test configuration: %v", err)
+	}
+	cfg := config.Get()
+	logger.Init(cfg)
+	if err := token.Init(cfg); err != nil {
+		t.Fatalf("initializing token keys: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemory()
+	messageBus := bus.NewMemoryBus()
+	var observer contract.Metrics = metrics.Noop{}
+	var prom *metrics.Prometheus
+	if cfg.Metrics.Enabled {
+		prom = metrics.NewPrometheus()
+		observer = prom
+	}
+	serv := service.New(repo, messageBus, observer)
+	httpServer := httptest.NewServer(server.NewRouter(cfg, serv, prom))
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer cancel()
+		serv.Signaling.CloseAllClients(ctx)
+		httpServer.Close()
+		messageBus.Close()
+	})
+
+	return &Server{Server: httpServer, Repository: repo, Service: serv, t: t}
+}
+
+// Register creates a user through POST /auth/register and returns its access token
+func (s *Server) Register(username, email, password string) string {
+	s.t.Helper()
+
+	var result dto.AuthResponse
+	s.PostJSON("/auth/register", dto.RegisterRequest{Username: username, Email: email, Password: password}, http.StatusCreated, &result)
+	return result.Token
+}
+
+// Login signs a registered user in through POST /auth/login and returns a new access token
+func (s *Server) Login(email, password string) string {
+	s.t.Helper()
+
+	var result dto.AuthResponse
+	s.PostJSON("/auth/login", dto.LoginRequest{Email: email, Password: password}, http.StatusOK, &result)
+	return result.Token
+}
+
+// PostJSON sends body to path and fails the test unless the response has
+// wantStatus. The data field of the response envelope is decoded into data
+// when it is not nil.
+func (s *Server) PostJSON(path string, body any, wantStatus int, data any) {
+	s.t.Helper()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		s.t.Fatalf("encoding request for %s: %v", path, err)
+	}
+
+	resp, err := s.Client().Post(s.URL+path, "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		s.t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("reading response of POST %s: %v", path, err)
+	}
+	if resp.StatusCode != wantStatus {
+		s.t.Fatalf("POST %s: status %d, want %d: %s", path, resp.StatusCode, wantStatus, raw)
+	}
+
+	if data == nil {
+		return
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		s.t.Fatalf("decoding response of POST %s: %v", path, err)
+	}
+	if err := json.Unmarshal(envelope.Data, data); err != nil {
+		s.t.Fatalf("decoding data of POST %s: %v", path, err)
+	}
+}
+
+// WebSocketURL is the /ws endpoint, authenticated with accessToken unless it is empty
+func (s *Server) WebSocketURL(accessToken string) string {
+	endpoint := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	if accessToken != "" {
+		endpoint += "?token=" + url.QueryEscape(accessToken)
+	}
+	return endpoint
+}